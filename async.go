@@ -1,12 +1,13 @@
 package main
 
 import (
-	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 )
 
@@ -35,21 +36,126 @@ func syncResponse(response Response) MaybeSyncResponse {
 	}
 }
 
-func delayWithRetries(tryDelays []time.Duration, operation func() asyncResponse,
-	asyncOperationWg *sync.WaitGroup) MaybeSyncResponse {
+// runAsyncOperation runs a long-running operation in the background.
+type runAsyncOperation func(operation func())
 
-	if len(tryDelays) < 1 {
+// limitConcurrency returns a runAsyncOperation that runs at most limit of the
+// operations at a time. The rest of the operations wait for their turn in the
+// background.
+func limitConcurrency(runAsync runAsyncOperation, limit int) runAsyncOperation {
+	semaphore := make(chan struct{}, limit)
+	return func(operation func()) {
+		runAsync(func() {
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+			operation()
+		})
+	}
+}
+
+// scheduledRetries keeps track of the retries that have been scheduled for
+// an operation key, e.g. for a PR, so that they could be cancelled.
+type scheduledRetries struct {
+	mutex  sync.Mutex
+	groups map[string]*retryGroup
+}
+
+type retryGroup struct {
+	cancel chan struct{}
+	count  int
+}
+
+// unkeyedOperation is the key for operations that can't be cancelled.
+const unkeyedOperation = ""
+
+// mergeRetryKey is the key of the retries that look for the PRs to merge
+// after the commit's statuses or checks have succeeded. The retries are
+// cancelled by !cancel, which looks up the head commit of the PR.
+func mergeRetryKey(repository Repository, sha string) string {
+	return fmt.Sprintf("merge %s/%s@%s", repository.Owner, repository.Name, sha)
+}
+
+func newScheduledRetries() *scheduledRetries {
+	return &scheduledRetries{groups: map[string]*retryGroup{}}
+}
+
+// acquire registers a scheduled retry for the key. The returned channel is
+// closed when the retries for the key get cancelled. A nil channel, which
+// never receives, is returned for unkeyed operations.
+func (s *scheduledRetries) acquire(key string) <-chan struct{} {
+	if key == unkeyedOperation {
+		return nil
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	group, ok := s.groups[key]
+	if !ok {
+		group = &retryGroup{cancel: make(chan struct{})}
+		s.groups[key] = group
+	}
+	group.count++
+	return group.cancel
+}
+
+// release unregisters a scheduled retry for the key once it has either
+// started or been cancelled.
+func (s *scheduledRetries) release(key string, cancel <-chan struct{}) {
+	if key == unkeyedOperation {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	group, ok := s.groups[key]
+	// The group might have already been cancelled and replaced by a new one
+	if !ok || group.cancel != cancel {
+		return
+	}
+	group.count--
+	if group.count == 0 {
+		delete(s.groups, key)
+	}
+}
+
+// cancel cancels all scheduled retries for the key and returns the number of
+// retries cancelled.
+func (s *scheduledRetries) cancel(key string) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	group, ok := s.groups[key]
+	if !ok {
+		return 0
+	}
+	close(group.cancel)
+	delete(s.groups, key)
+	return group.count
+}
+
+// retrier tries the retry jobs and schedules their retries. The scheduled
+// jobs are persisted if a directory is configured, so that they could be
+// resumed after a restart.
+type retrier struct {
+	tryDelays        []time.Duration
+	run              func(retryJob) asyncResponse
+	jobs             *retryJobs
+	retries          *scheduledRetries
+	asyncOperationWg *sync.WaitGroup
+}
+
+func (r *retrier) delayWithRetries(job retryJob) MaybeSyncResponse {
+	if len(r.tryDelays) < 1 {
 		return syncResponse(ErrorResponse{
 			Code:         http.StatusInternalServerError,
 			ErrorMessage: "Cannot schedule any delayed operations when tryDelays is empty",
 		})
 	}
 
-	if tryDelays[0] == 0 {
-		response := operation()
-		if len(tryDelays) > 1 && response.MayBeRetried {
+	job.Attempt = 0
+	if r.tryDelays[0] == 0 {
+		response := r.run(job)
+		if len(r.tryDelays) > 1 && response.MayBeRetried {
 			log.Println("Operation will be retried")
-			if err := asyncDelayWithRetries(tryDelays[1:], operation, asyncOperationWg); err != nil {
+			job.Attempt = 1
+			if err := r.schedule(job); err != nil {
 				return syncResponse(
 					ErrorResponse{err, http.StatusInternalServerError, "Failed to schedule async retries"},
 				)
@@ -59,7 +165,7 @@ func delayWithRetries(tryDelays []time.Duration, operation func() asyncResponse,
 		return syncResponse(response)
 	}
 
-	if err := asyncDelayWithRetries(tryDelays, operation, asyncOperationWg); err != nil {
+	if err := r.schedule(job); err != nil {
 		return syncResponse(
 			ErrorResponse{err, http.StatusInternalServerError, "Failed to schedule async delay with retries"},
 		)
@@ -67,31 +173,73 @@ func delayWithRetries(tryDelays []time.Duration, operation func() asyncResponse,
 	return MaybeSyncResponse{OperationFinishedSynchronously: false}
 }
 
-func asyncDelayWithRetries(tryDelays []time.Duration, operation func() asyncResponse,
-	asyncOperationWg *sync.WaitGroup) error {
+// schedule persists the job and schedules it to run after the delay of its
+// attempt.
+func (r *retrier) schedule(job retryJob) error {
+	if job.Attempt >= len(r.tryDelays) {
+		return fmt.Errorf("cannot schedule try %d when there are only %d tries", job.Attempt+1, len(r.tryDelays))
+	}
+	job.Due = time.Now().Add(r.tryDelays[job.Attempt])
+	if err := r.jobs.save(&job); err != nil {
+		return err
+	}
+	r.start(job)
+	log.Printf("Scheduled an asynchronous operation to start in %s\n", r.tryDelays[job.Attempt].String())
+	return nil
+}
 
-	if len(tryDelays) < 1 {
-		return errors.New("Cannot schedule any delayed operations when tryDelays is empty")
+// resume schedules the jobs that were persisted before a restart. The jobs
+// that are already due are started immediately.
+func (r *retrier) resume(jobs []retryJob) {
+	for _, job := range jobs {
+		log.Printf("Resuming a %s operation (attempt %d) due at %s\n", job.Kind, job.Attempt+1,
+			job.Due.Format(time.RFC3339))
+		r.start(job)
 	}
+}
 
-	delay(tryDelays[0], func() {
-		response := operation()
+func (r *retrier) start(job retryJob) {
+	cancel := r.retries.acquire(job.Key)
+	delay(time.Until(job.Due), cancel, r.jobs.isPersistent(), func(cancelled bool) {
+		r.retries.release(job.Key, cancel)
+		if cancelled {
+			log.Printf("Scheduled operation for %s was cancelled\n", job.Key)
+			r.jobs.remove(job)
+			return
+		}
+		response := r.run(job)
 		handleAsyncResponse(response.Response)
-		if len(tryDelays) > 1 && response.MayBeRetried {
+		// The try delays might have changed since a resumed job was
+		// scheduled
+		if job.Attempt+1 < len(r.tryDelays) && response.MayBeRetried {
 			log.Println("Operation will be retried")
-			if err := asyncDelayWithRetries(tryDelays[1:], operation, asyncOperationWg); err != nil {
-				log.Printf("Failed to schedule another try to start in %s\n", tryDelays[1].String())
+			next := job
+			next.Attempt++
+			// The next try replaces the job in the same file
+			err := r.schedule(next)
+			if err == nil {
 				return
 			}
+			log.Printf("Failed to schedule another try to start in %s: %v\n",
+				r.tryDelays[next.Attempt].String(), err)
 		}
-	}, asyncOperationWg)
-	log.Printf("Scheduled an asynchronous operation to start in %s\n", tryDelays[0].String())
-	return nil
+		r.jobs.remove(job)
+	}, r.asyncOperationWg)
 }
 
-func delay(duration time.Duration, operation func(), asyncOperationWg *sync.WaitGroup) {
+// delay calls the operation after the duration has passed or immediately when
+// the operation is cancelled, in which case cancelled is true. When the bot
+// is interrupted, the operation is started immediately, unless it's
+// resumable, i.e. persisted, in which case it's left to be resumed after the
+// restart.
+func delay(duration time.Duration, cancel <-chan struct{}, resumable bool, operation func(cancelled bool),
+	asyncOperationWg *sync.WaitGroup) {
 	interruptChan := make(chan os.Signal, 1)
-	signal.Notify(interruptChan, os.Interrupt)
+	if resumable {
+		signal.Notify(interruptChan, os.Interrupt, syscall.SIGTERM)
+	} else {
+		signal.Notify(interruptChan, os.Interrupt)
+	}
 
 	timer := time.NewTimer(duration)
 
@@ -101,14 +249,23 @@ func delay(duration time.Duration, operation func(), asyncOperationWg *sync.Wait
 		// Avoid leaking channels
 		defer signal.Stop(interruptChan)
 
-		// Block until either of the 2 channels receives.
+		// Block until either of the 3 channels receives.
 		select {
 		case <-interruptChan:
+			if resumable {
+				log.Println("Received a stop signal. Leaving a scheduled process to be resumed after the restart.")
+				timer.Stop()
+				return
+			}
 			log.Println("Received an interrupt signal (SIGINT). Starting a scheduled process immediately.")
 		case <-timer.C:
+		case <-cancel:
+			timer.Stop()
+			operation(true)
+			return
 		}
 
-		operation()
+		operation(false)
 	}()
 }
 
@@ -3,35 +3,113 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"hash"
 	"net/http"
+	"strings"
 )
 
-func checkAuthentication(body []byte, r *http.Request, secret string) *ErrorResponse {
-	signature := r.Header.Get("X-Hub-Signature")
+// webhookSecrets are the secrets that the webhooks may be signed with.
+// Accepting several secrets at once allows rotating them without rejecting
+// any webhooks.
+type webhookSecrets struct {
+	global []string
+	// byRepository holds the secrets of the repositories that have their own
+	// secrets, keyed by the lowercased "owner/name" of the repository
+	byRepository map[string][]string
+}
+
+func newWebhookSecrets(conf Config) webhookSecrets {
+	byRepository := map[string][]string{}
+	for repository, secrets := range conf.RepositorySecrets {
+		key := strings.ToLower(repository)
+		byRepository[key] = append(byRepository[key], secrets...)
+	}
+	return webhookSecrets{
+		global:       append([]string{conf.Secret}, conf.AdditionalSecrets...),
+		byRepository: byRepository,
+	}
+}
+
+// all returns every secret that is accepted for the webhooks of any
+// repository.
+func (s webhookSecrets) all() []string {
+	secrets := append([]string{}, s.global...)
+	for _, repositorySecrets := range s.byRepository {
+		secrets = append(secrets, repositorySecrets...)
+	}
+	return secrets
+}
+
+// forRepository returns the secrets accepted for the webhooks of the
+// repository. A repository with its own secrets doesn't accept the global
+// secrets.
+func (s webhookSecrets) forRepository(repository Repository) []string {
+	key := strings.ToLower(repository.Owner + "/" + repository.Name)
+	if secrets, exists := s.byRepository[key]; exists {
+		return secrets
+	}
+	return s.global
+}
+
+func checkAuthentication(body []byte, r *http.Request, secrets webhookSecrets) *ErrorResponse {
+	// The SHA-256 signature is preferred, because GitHub only keeps sending
+	// the SHA-1 signature for backwards compatibility
+	header, prefix, hashFunc := "X-Hub-Signature-256", "sha256=", sha256.New
+	signature := r.Header.Get(header)
 	if signature == "" {
-		return &ErrorResponse{nil, http.StatusUnauthorized, "Please provide a X-Hub-Signature"}
+		header, prefix, hashFunc = "X-Hub-Signature", "sha1=", sha1.New
+		signature = r.Header.Get(header)
 	}
-	hasSecret, err := hasSecret(body, signature, secret)
+	if signature == "" {
+		return &ErrorResponse{nil, http.StatusUnauthorized, "Please provide an X-Hub-Signature-256 header"}
+	}
+	messageMAC, err := parseSignature(signature, prefix)
+	if err != nil {
+		return &ErrorResponse{err, http.StatusForbidden, "Bad " + header}
+	}
+	matchingSecret, matches := findSecret(body, messageMAC, secrets.all(), hashFunc)
+	if !matches {
+		return &ErrorResponse{nil, http.StatusForbidden, "Bad " + header}
+	} else if len(secrets.byRepository) == 0 {
+		return nil
+	}
+	// The body has been signed with one of the secrets, so it's safe to parse
+	// it for checking that the secret is accepted for the repository
+	repository, err := parseEventRepository(body)
 	if err != nil {
-		return &ErrorResponse{err, http.StatusInternalServerError, "Failed to check the signature"}
-	} else if !hasSecret {
-		return &ErrorResponse{nil, http.StatusForbidden, "Bad X-Hub-Signature"}
+		return &ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	}
+	for _, secret := range secrets.forRepository(repository) {
+		if secret == matchingSecret {
+			return nil
+		}
 	}
-	return nil
+	return &ErrorResponse{nil, http.StatusForbidden, "Bad " + header}
 }
 
-func hasSecret(message []byte, signature, key string) (bool, error) {
-	var messageMACString string
-	fmt.Sscanf(signature, "sha1=%s", &messageMACString)
-	messageMAC, err := hex.DecodeString(messageMACString)
-	if err != nil {
-		return false, err
+// findSecret returns the secret that the message has been signed with.
+func findSecret(message, messageMAC []byte, secrets []string, hashFunc func() hash.Hash) (string, bool) {
+	for _, secret := range secrets {
+		if hasSecret(message, messageMAC, secret, hashFunc) {
+			return secret, true
+		}
 	}
+	return "", false
+}
+
+func parseSignature(signature, prefix string) ([]byte, error) {
+	if !strings.HasPrefix(signature, prefix) {
+		return nil, fmt.Errorf("expected the signature to start with %s", prefix)
+	}
+	return hex.DecodeString(strings.TrimPrefix(signature, prefix))
+}
 
-	mac := hmac.New(sha1.New, []byte(key))
+func hasSecret(message, messageMAC []byte, key string, hashFunc func() hash.Hash) bool {
+	mac := hmac.New(hashFunc, []byte(key))
 	mac.Write(message)
 	expectedMAC := mac.Sum(nil)
-	return hmac.Equal(messageMAC, expectedMAC), nil
+	return hmac.Equal(messageMAC, expectedMAC)
 }
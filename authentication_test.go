@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 
+	grh "github.com/salemove/github-review-helper"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -15,16 +17,19 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			headers     = context.Headers
 			requestJSON = context.RequestJSON
 
+			conf             *grh.Config
 			responseRecorder *httptest.ResponseRecorder
 		)
 		BeforeEach(func() {
+			conf = context.Config
 			responseRecorder = *context.ResponseRecorder
 		})
 
-		Context("with an empty X-Hub-Signature header", func() {
+		Context("with empty X-Hub-Signature and X-Hub-Signature-256 headers", func() {
 			headers.Is(func() map[string]string {
 				return map[string]string{
-					"X-Hub-Signature": "",
+					"X-Hub-Signature":     "",
+					"X-Hub-Signature-256": "",
 				}
 			})
 			It("fails with StatusUnauthorized", func() {
@@ -39,7 +44,8 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			})
 			headers.Is(func() map[string]string {
 				return map[string]string{
-					"X-Hub-Signature": "sha1=2f539a59127d552f4565b1a114ec8f4fa2d55f55",
+					"X-Hub-Signature":     "sha1=2f539a59127d552f4565b1a114ec8f4fa2d55f55",
+					"X-Hub-Signature-256": "",
 				}
 			})
 
@@ -56,7 +62,8 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			})
 			headers.Is(func() map[string]string {
 				return map[string]string{
-					"X-Hub-Signature": validSignature,
+					"X-Hub-Signature":     validSignature,
+					"X-Hub-Signature-256": "",
 				}
 			})
 
@@ -69,8 +76,9 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			Context("with a gibberish event", func() {
 				headers.Is(func() map[string]string {
 					return map[string]string{
-						"X-Hub-Signature": validSignature,
-						"X-Github-Event":  "gibberish",
+						"X-Hub-Signature":     validSignature,
+						"X-Hub-Signature-256": "",
+						"X-Github-Event":      "gibberish",
 					}
 				})
 
@@ -82,6 +90,174 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			})
 		})
 
+		Context("with only a valid X-Hub-Signature-256 header", func() {
+			requestJSON.Is(func() string {
+				return "{}"
+			})
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Hub-Signature": "",
+				}
+			})
+
+			It("succeeds with 'ignored' response", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+			})
+		})
+
+		Context("with an invalid X-Hub-Signature-256 header and a valid X-Hub-Signature header", func() {
+			requestJSON.Is(func() string {
+				return "{}"
+			})
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Hub-Signature-256": Signature256("another-secret", "{}"),
+				}
+			})
+
+			It("prefers X-Hub-Signature-256 and fails with StatusForbidden", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		Context("with a request signed with an additional secret", func() {
+			BeforeEach(func() {
+				conf.AdditionalSecrets = []string{"old-secret", "older-secret"}
+			})
+			requestJSON.Is(func() string {
+				return "{}"
+			})
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Hub-Signature":     "",
+					"X-Hub-Signature-256": Signature256("older-secret", "{}"),
+				}
+			})
+
+			It("succeeds with 'ignored' response", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+			})
+		})
+
+		Context("with the repository having its own secrets", func() {
+			BeforeEach(func() {
+				conf.RepositorySecrets = map[string][]string{
+					repositoryOwner + "/" + repositoryName: {"repo-secret"},
+				}
+			})
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("just a simple comment", arbitraryIssueAuthor)
+			})
+
+			Context("with a request signed with the repository's secret", func() {
+				headers.Is(func() map[string]string {
+					return map[string]string{
+						"X-Github-Event":      "issue_comment",
+						"X-Hub-Signature":     "",
+						"X-Hub-Signature-256": Signature256("repo-secret", requestJSON.Get()),
+					}
+				})
+
+				It("succeeds with 'ignored' response", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+
+			Context("with a request signed with the global secret", func() {
+				headers.Is(func() map[string]string {
+					return map[string]string{
+						"X-Github-Event": "issue_comment",
+					}
+				})
+
+				It("fails with StatusForbidden", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("with a malformed request with an invalid signature", func() {
+				requestJSON.Is(func() string {
+					return "{"
+				})
+				headers.Is(func() map[string]string {
+					return map[string]string{
+						"X-Hub-Signature":     "",
+						"X-Hub-Signature-256": Signature256("another-secret", "{"),
+					}
+				})
+
+				It("fails with StatusForbidden", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusForbidden))
+				})
+			})
+
+			Context("with a malformed request signed with the repository's secret", func() {
+				requestJSON.Is(func() string {
+					return "{"
+				})
+				headers.Is(func() map[string]string {
+					return map[string]string{
+						"X-Hub-Signature":     "",
+						"X-Hub-Signature-256": Signature256("repo-secret", "{"),
+					}
+				})
+
+				It("fails to parse the authenticated request", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				})
+			})
+		})
+
+		Context("with a request signed with another repository's secret", func() {
+			BeforeEach(func() {
+				conf.RepositorySecrets = map[string][]string{
+					"other/repository": {"other-secret"},
+				}
+			})
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("just a simple comment", arbitraryIssueAuthor)
+			})
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event":      "issue_comment",
+					"X-Hub-Signature":     "",
+					"X-Hub-Signature-256": Signature256("other-secret", requestJSON.Get()),
+				}
+			})
+
+			It("fails with StatusForbidden", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusForbidden))
+			})
+		})
+
+		Context("with a malformed request with an invalid signature", func() {
+			requestJSON.Is(func() string {
+				return "{"
+			})
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Hub-Signature":     "",
+					"X-Hub-Signature-256": Signature256("another-secret", "{"),
+				}
+			})
+
+			It("fails with StatusForbidden", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusForbidden))
+			})
+		})
+
 		Context("with a valid signature", func() {
 			Describe("issue_comment event", func() {
 				headers.Is(func() map[string]string {
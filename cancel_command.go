@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+func isCancelCommand(comment string) bool {
+	arguments := strings.Fields(comment)
+	switch len(arguments) {
+	case 1:
+		return arguments[0] == "!cancel"
+	case 2:
+		return arguments[0] == "!merge" && arguments[1] == "cancel"
+	}
+	return false
+}
+
+func handleCancelCommand(issueComment IssueComment, pr *github.PullRequest, repoConfig RepoConfig,
+	requestedMergeMethods *requestedMergeMethods, retries *scheduledRetries, issues Issues) Response {
+
+	issue := issueComment.Issue()
+	log.Printf("Cancelling merging PR %s.\n", issue.FullName())
+	wasMerging := true
+	resp, err := issues.RemoveLabelForIssue(context.TODO(), issue.Repository.Owner, issue.Repository.Name,
+		issue.Number, repoConfig.MergingLabel)
+	if err != nil {
+		if !is404Error(resp) {
+			message := fmt.Sprintf("Failed to remove the label %s for issue #%d", repoConfig.MergingLabel,
+				issue.Number)
+			return ErrorResponse{err, http.StatusBadGateway, message}
+		}
+		// GitHub responds with a 404 if the PR doesn't have the label
+		wasMerging = false
+	}
+	requestedMergeMethods.clear(issue)
+	// The merges are retried for the commit whose statuses or checks
+	// succeeded, which is the head of the PR
+	cancelledRetries := retries.cancel(mergeRetryKey(issue.Repository, *pr.Head.SHA))
+	log.Printf("Cancelled %d scheduled merge retries for PR %s.\n", cancelledRetries, issue.FullName())
+
+	message := "OK, I've stopped merging this PR."
+	if !wasMerging {
+		message = "This PR wasn't waiting to be merged, so there was nothing to cancel."
+	}
+	if err = comment(message, issue.Repository, issue.Number, issues); err != nil {
+		errorMessage := fmt.Sprintf("Failed to confirm cancelling merging PR %s", issue.FullName())
+		return ErrorResponse{err, http.StatusBadGateway, errorMessage}
+	}
+	return SuccessResponse{fmt.Sprintf("Cancelled merging PR %s", issue.FullName())}
+}
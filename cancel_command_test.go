@@ -0,0 +1,221 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("!cancel comment", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			issues           *mocks.Issues
+
+			issueAuthor = "procoder"
+			headSHA     = "1235abcdef"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			issues = *context.Issues
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "issue_comment",
+			}
+		})
+
+		commentContaining := func(text string) func(*github.IssueComment) bool {
+			return func(issueComment *github.IssueComment) bool {
+				return strings.Contains(*issueComment.Body, text)
+			}
+		}
+
+		mockPR := func() {
+			pullRequests.
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(&github.PullRequest{
+					Number: github.Int(issueNumber),
+					Head: &github.PullRequestBranch{
+						SHA: github.String(headSHA),
+					},
+				}, emptyResponse, noError)
+		}
+
+		itCancelsMerging := func() {
+			Context("with getting the PR failing", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(emptyResult, emptyResponse, errArbitrary)
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+
+			Context("with the PR found", func() {
+				BeforeEach(mockPR)
+
+				Context("with removing the label failing", func() {
+					BeforeEach(func() {
+						issues.
+							On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+							Return(emptyResponse, errArbitrary)
+					})
+
+					It("fails with a gateway error", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+					})
+				})
+
+				Context("with the PR not having the label", func() {
+					BeforeEach(func() {
+						issues.
+							On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+							Return(notFoundResponse, errArbitrary)
+					})
+
+					It("replies that there was nothing to cancel", func() {
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+								mock.MatchedBy(commentContaining("nothing to cancel"))).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with removing the label succeeding", func() {
+					BeforeEach(func() {
+						issues.
+							On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+							Return(emptyResponse, noError)
+					})
+
+					Context("with the confirmation comment failing", func() {
+						BeforeEach(func() {
+							issues.
+								On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.Anything).
+								Return(emptyResult, emptyResponse, errArbitrary)
+						})
+
+						It("fails with a gateway error", func() {
+							handle()
+							Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+						})
+					})
+
+					It("confirms the cancellation with a comment", func() {
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+								mock.MatchedBy(commentContaining("stopped merging"))).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+		}
+
+		Context("with a '!cancel' comment", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!cancel", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, itCancelsMerging)
+		})
+
+		Context("with a '!merge cancel' comment", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge cancel", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, itCancelsMerging)
+		})
+
+		Context("with a merge retry scheduled for the PR's head", func() {
+			var conf *grh.Config
+			BeforeEach(func() {
+				conf = context.Config
+				conf.GithubAPITryDeltas = []time.Duration{0, time.Hour}
+			})
+
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!cancel", issueAuthor)
+			})
+
+			It("stops the retry", func() {
+				search := *context.Search
+				repositories := *context.Repositories
+				searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s", headSHA, grh.MergingLabel,
+					repositoryOwner, repositoryName)
+				search.
+					On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+					Return(&github.IssuesSearchResult{
+						Total:  github.Int(0),
+						Issues: []*github.Issue{},
+					}, &github.Response{}, noError).
+					Once()
+				repositories.
+					On("IsCollaborator", anyContext, repositoryOwner, repositoryName, issueAuthor).
+					Return(true, emptyResponse, noError)
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, noError)
+				mockPR()
+				issues.
+					On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+						mock.MatchedBy(commentContaining("stopped merging"))).
+					Return(emptyResult, emptyResponse, noError)
+
+				// The retries aren't waited for, unlike in handle()
+				asyncOperationWg := &sync.WaitGroup{}
+				handler := grh.CreateHandler(*conf, *context.GitRepos, asyncOperationWg, nil, pullRequests,
+					repositories, issues, search, *context.Checks)
+				checkEvent := CheckRunEvent("completed", headSHA, "success")
+				checkRequest, err := http.NewRequest("POST", "http://localhost/whatever", strings.NewReader(checkEvent))
+				Expect(err).NotTo(HaveOccurred())
+				checkRequest.Header.Set("X-Github-Event", "check_run")
+				checkRequest.Header.Set("X-Hub-Signature-256", Signature256(conf.Secret, checkEvent))
+				checkResponse := handler(httptest.NewRecorder(), checkRequest)
+				Expect(checkResponse).To(BeAssignableToTypeOf(grh.SuccessResponse{}))
+
+				response := handler(responseRecorder, *context.Request)
+				response.WriteResponse(responseRecorder)
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+				retriesDone := make(chan struct{})
+				go func() {
+					asyncOperationWg.Wait()
+					close(retriesDone)
+				}()
+				Eventually(retriesDone).Should(BeClosed())
+				search.AssertNumberOfCalls(GinkgoT(), "Issues", 1)
+			})
+		})
+	})
+})
@@ -49,6 +49,12 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 
 		ForCollaborator(context, repositoryOwner, repositoryName, arbitraryIssueAuthor, func() {
 			Context("with GitHub request to list commits failing", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(&github.PullRequest{}, emptyResponse, noError)
+				})
+
 				Context("with a 404", func() {
 					BeforeEach(func() {
 						resp, err := createGithubErrorResponse(http.StatusNotFound)
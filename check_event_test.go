@@ -0,0 +1,216 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	mockSHA := "c9b5e1096a18765a14f6fb295c585efd40487a24"
+
+	Describe("check events", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+			search           *mocks.Search
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+			search = *context.Search
+		})
+
+		searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s",
+			mockSHA, grh.MergingLabel, repositoryOwner, repositoryName)
+		issueNumber := 7331
+		userName := "bestcoder"
+
+		mockSearchReturningPRWithLabels := func(labels ...string) {
+			githubLabels := []*github.Label{}
+			for _, label := range labels {
+				githubLabels = append(githubLabels, &github.Label{Name: github.String(label)})
+			}
+			search.
+				On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+				Return(&github.IssuesSearchResult{
+					Total: github.Int(1),
+					Issues: []*github.Issue{{
+						Number: github.Int(issueNumber),
+						User: &github.User{
+							Login: github.String(userName),
+						},
+					}},
+				}, &github.Response{}, noError)
+			pullRequests.
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(&github.PullRequest{
+					Number: github.Int(issueNumber),
+					Base: &github.PullRequestBranch{
+						Ref:  github.String("master"),
+						Repo: repository,
+					},
+					Head: &github.PullRequestBranch{
+						SHA:  github.String(mockSHA),
+						Ref:  github.String("feature"),
+						Repo: repository,
+					},
+					User: &github.User{
+						Login: github.String(userName),
+					},
+					Labels: githubLabels,
+				}, emptyResponse, noError)
+		}
+		mockSearchReturningPR := func() {
+			mockSearchReturningPRWithLabels(grh.MergingLabel)
+		}
+
+		Describe("check_run event", func() {
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event": "check_run",
+				}
+			})
+
+			Context("with the check run being created", func() {
+				requestJSON.Is(func() string {
+					return CheckRunEvent("created", mockSHA, "")
+				})
+
+				It("ignores the event", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+
+			Context("with the check run having failed", func() {
+				requestJSON.Is(func() string {
+					return CheckRunEvent("completed", mockSHA, "timed_out")
+				})
+
+				BeforeEach(mockSearchReturningPR)
+
+				It("removes the 'merging' label and notifies the author of the failed check", func() {
+					issues.
+						On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+						Return(emptyResponse, noError)
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+							mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+								return commentMentioning(userName)(issueComment) &&
+									strings.Contains(*issueComment.Body, "`"+arbitraryStatusContext+"` check run failed") &&
+									strings.Contains(*issueComment.Body, arbitraryStatusTargetURL)
+							})).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with the check run having been skipped", func() {
+				requestJSON.Is(func() string {
+					return CheckRunEvent("completed", mockSHA, "skipped")
+				})
+
+				BeforeEach(mockSearchReturningPR)
+
+				It("evaluates the PR for merging", func() {
+					repositories.
+						On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListOptions")).
+						Return(&github.CombinedStatus{
+							State: github.String("failure"),
+							Statuses: []*github.RepoStatus{{
+								Context: github.String("ci/jenkins"),
+								State:   github.String("failure"),
+							}},
+						}, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		Describe("check_suite event", func() {
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event": "check_suite",
+				}
+			})
+
+			Context("with the check suite having succeeded", func() {
+				requestJSON.Is(func() string {
+					return CheckSuiteEvent("completed", mockSHA, "success")
+				})
+
+				BeforeEach(mockSearchReturningPR)
+
+				Context("with no legacy statuses, but a failed check run", func() {
+					BeforeEach(func() {
+						repositories.
+							On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListOptions")).
+							Return(&github.CombinedStatus{
+								State:    github.String("pending"),
+								Statuses: []*github.RepoStatus{},
+							}, emptyResponse, noError)
+						(*context.Checks).
+							On("ListCheckRunsForRef", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListCheckRunsOptions")).
+							Return(&github.ListCheckRunsResults{
+								Total: github.Int(1),
+								CheckRuns: []*github.CheckRun{{
+									Name:       github.String("build"),
+									Status:     github.String("completed"),
+									Conclusion: github.String("failure"),
+								}},
+							}, &github.Response{}, noError)
+					})
+
+					It("doesn't merge the PR", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+
+			Context("with the check suite having failed", func() {
+				requestJSON.Is(func() string {
+					return CheckSuiteEvent("completed", mockSHA, "failure")
+				})
+
+				Context("with the failed check run having already cancelled merging", func() {
+					// The search index might still include the PR after the
+					// label has been removed
+					BeforeEach(func() {
+						mockSearchReturningPRWithLabels()
+					})
+
+					It("doesn't notify the author again", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						issues.AssertNotCalled(GinkgoT(), "CreateComment", anyContext, repositoryOwner, repositoryName,
+							issueNumber, mock.Anything)
+					})
+				})
+			})
+		})
+	})
+})
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-github/v84/github"
+)
+
+type Checks interface {
+	ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error)
+}
+
+func isCheckEventCompleted(checkEvent CheckEvent) bool {
+	return checkEvent.Action == "completed"
+}
+
+func getCheckRuns(pr *github.PullRequest, checks Checks) ([]*github.CheckRun, *ErrorResponse) {
+	// Unlike statuses, check runs are created in the base repository, even
+	// for PRs across forks.
+	repository := baseRepository(pr)
+	pageNr := 1
+	checkRuns := []*github.CheckRun{}
+	for {
+		listOptions := &github.ListCheckRunsOptions{
+			// Only consider the latest run of every check. Older runs
+			// have been superseded by re-runs.
+			Filter: github.String("latest"),
+			ListOptions: github.ListOptions{
+				Page: pageNr,
+				// Max is 100: https://developer.github.com/v3/#pagination
+				PerPage: 100,
+			},
+		}
+		results, resp, err := checks.ListCheckRunsForRef(context.TODO(), repository.Owner, repository.Name,
+			*pr.Head.SHA, listOptions)
+		if err != nil {
+			message := fmt.Sprintf("Failed to list check runs for ref %s", *pr.Head.SHA)
+			return nil, &ErrorResponse{err, http.StatusBadGateway, message}
+		}
+		checkRuns = append(checkRuns, results.CheckRuns...)
+		if resp.NextPage == 0 {
+			break
+		}
+		pageNr = resp.NextPage
+	}
+	return checkRuns, nil
+}
+
+// checkConclusionState maps the conclusion of a completed check run or
+// check suite to the corresponding commit status state.
+func checkConclusionState(conclusion string) string {
+	switch conclusion {
+	case "success", "neutral", "skipped":
+		return "success"
+	}
+	// failure, cancelled, timed_out, action_required, stale and
+	// startup_failure all block the PR from being merged.
+	return "failure"
+}
+
+func checkRunsState(checkRuns []*github.CheckRun) string {
+	state := "success"
+	for _, checkRun := range checkRuns {
+		if checkRun.GetStatus() != "completed" {
+			state = combineStates(state, "pending")
+		} else {
+			state = combineStates(state, checkConclusionState(checkRun.GetConclusion()))
+		}
+	}
+	return state
+}
+
+// combineStates combines two states the same way GitHub combines the states
+// of multiple statuses: any failure fails the whole, otherwise any pending
+// state keeps the whole pending. Anything other than "success" or "pending"
+// is considered a failure.
+func combineStates(a, b string) string {
+	isFailed := func(state string) bool {
+		return state != "success" && state != "pending"
+	}
+	switch {
+	case isFailed(a) || isFailed(b):
+		return "failure"
+	case a == "pending" || b == "pending":
+		return "pending"
+	}
+	return "success"
+}
+
+// getMergeState evaluates whether the PR's head commit is ready for merging
+// by combining the legacy commit statuses with the check runs for the
+// commit. The returned statuses only include the legacy commit statuses.
+func getMergeState(pr *github.PullRequest, repositories Repositories, checks Checks) (string,
+	[]*github.RepoStatus, *ErrorResponse) {
+
+	state, statuses, errResp := getStatuses(pr, repositories)
+	if errResp != nil {
+		return "", nil, errResp
+	} else if state == "pending" && len(statuses) == 0 {
+		// GitHub reports the combined state as pending when there are no
+		// statuses at all, which is the norm for repositories that only use
+		// check runs.
+		state = "success"
+	} else if combineStates(state, "success") == "failure" {
+		return "failure", statuses, nil
+	}
+	checkRuns, errResp := getCheckRuns(pr, checks)
+	if errResp != nil {
+		return "", nil, errResp
+	}
+	return combineStates(state, checkRunsState(checkRuns)), statuses, nil
+}
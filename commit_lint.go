@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+)
+
+const githubStatusCommitsContext = "review/commits"
+
+// commitLintMarker identifies the comment that the bot keeps up to date with
+// the commit message rule violations of a PR.
+const commitLintMarker = "<!-- review-helper:commit-lint -->"
+
+// commitLintRules are the commit message rules configured for a repository.
+// Zero values disable the corresponding rules.
+type commitLintRules struct {
+	MaxSubjectLength  int
+	ImperativeSubject bool
+	BlankSecondLine   bool
+	SubjectPrefixes   []string
+	SubjectPattern    *regexp.Regexp
+	// BodyRequiredAbove is the number of changed lines above which the
+	// commit message has to have a body
+	BodyRequiredAbove int
+}
+
+// commitLintFile is the format of the commit_lint section of the
+// configuration file.
+type commitLintFile struct {
+	MaxSubjectLength  int      `yaml:"max_subject_length"`
+	ImperativeSubject bool     `yaml:"imperative_subject"`
+	BlankSecondLine   bool     `yaml:"blank_second_line"`
+	SubjectPrefixes   []string `yaml:"subject_prefixes"`
+	SubjectPattern    string   `yaml:"subject_pattern"`
+	BodyRequiredAbove int      `yaml:"body_required_above"`
+}
+
+func parseCommitLintRules(file commitLintFile) (*commitLintRules, error) {
+	if file.MaxSubjectLength < 0 {
+		return nil, errors.New("max_subject_length must not be negative")
+	} else if file.BodyRequiredAbove < 0 {
+		return nil, errors.New("body_required_above must not be negative")
+	}
+	rules := &commitLintRules{
+		MaxSubjectLength:  file.MaxSubjectLength,
+		ImperativeSubject: file.ImperativeSubject,
+		BlankSecondLine:   file.BlankSecondLine,
+		SubjectPrefixes:   file.SubjectPrefixes,
+		BodyRequiredAbove: file.BodyRequiredAbove,
+	}
+	if file.SubjectPattern != "" {
+		pattern, err := regexp.Compile(file.SubjectPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid subject_pattern: %v", err)
+		}
+		rules.SubjectPattern = pattern
+	}
+	return rules, nil
+}
+
+// commitViolations lists the rules that a commit's message breaks.
+type commitViolations struct {
+	SHA        string
+	Subject    string
+	Violations []string
+}
+
+// lintCommits checks the messages of the commits that will end up in the base
+// branch, i.e. all but merge commits and the commits that will be squashed.
+// If an amend! commit replaces a commit's message, the new message is checked
+// instead.
+func lintCommits(issue Issue, commits []*github.RepositoryCommit, rules *commitLintRules,
+	repositories Repositories) ([]commitViolations, *ErrorResponse) {
+
+	ordered := topologicalOrder(commits)
+	amended := amendedMessages(ordered)
+	result := []commitViolations{}
+	for _, commit := range ordered {
+		if git.IsFixup(commitSubject(commit)) || len(commit.Parents) > 1 {
+			continue
+		}
+		message, isAmended := amended[*commit.SHA]
+		if !isAmended {
+			message = *commit.Commit.Message
+		}
+		violations := lintMessage(message, rules)
+		if rules.BodyRequiredAbove > 0 && !hasBody(message) {
+			changedLines, errResp := getChangedLines(issue.Repository, *commit.SHA, repositories)
+			if errResp != nil {
+				return nil, errResp
+			} else if changedLines > rules.BodyRequiredAbove {
+				violations = append(violations, fmt.Sprintf(
+					"a body explaining the change is required for changes of more than %d lines",
+					rules.BodyRequiredAbove))
+			}
+		}
+		if len(violations) > 0 {
+			subject := strings.SplitN(message, "\n", 2)[0]
+			result = append(result, commitViolations{*commit.SHA, subject, violations})
+		}
+	}
+	return result, nil
+}
+
+// amendedMessages returns the messages that the amend! commits replace their
+// targets' messages with, keyed by the SHAs of the targets. Like with
+// autosquash, the last amend! commit of a target wins. The commits must be in
+// topological order.
+func amendedMessages(commits []*github.RepositoryCommit) map[string]string {
+	targets := git.FixupTargets(gitCommits(commits))
+	messages := map[string]string{}
+	for _, commit := range commits {
+		target, found := targets[*commit.SHA]
+		if !found || !strings.HasPrefix(commitSubject(commit), "amend! ") {
+			continue
+		}
+		parts := strings.SplitN(*commit.Commit.Message, "\n", 2)
+		if len(parts) == 2 {
+			messages[target] = strings.TrimSpace(parts[1])
+		}
+	}
+	return messages
+}
+
+// lintMessage checks the rules that only depend on the commit message.
+func lintMessage(message string, rules *commitLintRules) []string {
+	lines := strings.Split(message, "\n")
+	subject := lines[0]
+	violations := []string{}
+	if rules.MaxSubjectLength > 0 && len([]rune(subject)) > rules.MaxSubjectLength {
+		violations = append(violations, fmt.Sprintf("the subject is longer than %d characters",
+			rules.MaxSubjectLength))
+	}
+	if len(rules.SubjectPrefixes) > 0 && !hasAnyPrefix(subject, rules.SubjectPrefixes) {
+		violations = append(violations, fmt.Sprintf("the subject doesn't start with any of %s",
+			strings.Join(quoteAll(rules.SubjectPrefixes), ", ")))
+	}
+	if rules.SubjectPattern != nil && !rules.SubjectPattern.MatchString(subject) {
+		violations = append(violations, fmt.Sprintf("the subject doesn't match `%s`", rules.SubjectPattern))
+	}
+	if rules.ImperativeSubject && !isImperative(subject) {
+		violations = append(violations, "the subject isn't in the imperative mood")
+	}
+	if rules.BlankSecondLine && len(lines) > 1 && strings.TrimSpace(lines[1]) != "" {
+		violations = append(violations, "the second line isn't blank")
+	}
+	return violations
+}
+
+// conventionalPrefix matches prefixes like "docs: " or "fix(parser)!: ",
+// which are skipped when checking the mood of the subject.
+var conventionalPrefix = regexp.MustCompile(`^[\w-]+(\([^)]*\))?!?: `)
+
+// nonImperativeExceptions are imperative verbs that look like gerunds or
+// third person forms.
+var nonImperativeExceptions = map[string]bool{
+	"bring": true, "spring": true, "string": true, "ping": true, "bless": true,
+}
+
+// pastTenseForms are the most common past tense verbs that commit subjects
+// start with. Unlike for gerunds, a suffix check would reject imperative verbs
+// like "Proceed" or "Shred".
+var pastTenseForms = map[string]bool{
+	"added": true, "adjusted": true, "allowed": true, "applied": true, "bumped": true, "changed": true,
+	"cleaned": true, "converted": true, "corrected": true, "created": true, "deleted": true,
+	"deprecated": true, "disabled": true, "documented": true, "dropped": true, "enabled": true,
+	"ensured": true, "extracted": true, "fixed": true, "handled": true, "implemented": true,
+	"improved": true, "included": true, "introduced": true, "merged": true, "moved": true,
+	"optimized": true, "refactored": true, "removed": true, "renamed": true, "reordered": true,
+	"replaced": true, "resolved": true, "restored": true, "reverted": true, "simplified": true,
+	"skipped": true, "stopped": true, "supported": true, "switched": true, "tested": true,
+	"tweaked": true, "updated": true, "upgraded": true, "used": true, "wrapped": true,
+	"built": true, "made": true, "wrote": true, "rewrote": true,
+}
+
+// isImperative is a heuristic that only catches the most common non-imperative
+// subjects, i.e. the ones that start with a verb in the past tense ("Added"),
+// a gerund ("Adding") or the third person ("Adds").
+func isImperative(subject string) bool {
+	words := strings.Fields(conventionalPrefix.ReplaceAllString(subject, ""))
+	if len(words) == 0 {
+		return true
+	}
+	word := strings.ToLower(strings.Trim(words[0], ".,:;!"))
+	if nonImperativeExceptions[word] {
+		return true
+	}
+	switch {
+	case pastTenseForms[word], strings.HasSuffix(word, "ing"):
+		return false
+	case strings.HasSuffix(word, "s"):
+		// Words like "address", "focus" and "analysis" are fine
+		return strings.HasSuffix(word, "ss") || strings.HasSuffix(word, "us") || strings.HasSuffix(word, "is")
+	}
+	return true
+}
+
+func hasBody(message string) bool {
+	parts := strings.SplitN(message, "\n", 2)
+	return len(parts) == 2 && strings.TrimSpace(parts[1]) != ""
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = "`" + value + "`"
+	}
+	return quoted
+}
+
+func getChangedLines(repository Repository, sha string, repositories Repositories) (int, *ErrorResponse) {
+	commit, _, err := repositories.GetCommit(context.TODO(), repository.Owner, repository.Name, sha, nil)
+	if err != nil {
+		message := fmt.Sprintf("Getting commit %s in %s/%s failed", sha, repository.Owner, repository.Name)
+		return 0, &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	return commit.GetStats().GetTotal(), nil
+}
+
+// reportCommitViolations sets the review/commits status and keeps the sticky
+// comment listing the violations up to date.
+func reportCommitViolations(issue Issue, violations []commitViolations,
+	setStatus func(*github.RepoStatus) *ErrorResponse, botLogin string, issues Issues) *ErrorResponse {
+
+	if len(violations) == 0 {
+		status := createCommitsStatus("success", "All commit messages follow the rules")
+		if errResp := setStatus(status); errResp != nil {
+			return errResp
+		}
+		// Only update the comment of an earlier check, if there is one
+		return updateStickyComment(issue, commitLintMarker,
+			commitLintMarker+"\nAll commit messages follow the rules now. :+1:", botLogin, issues)
+	}
+	shas := make([]string, len(violations))
+	for i, commit := range violations {
+		shas[i] = shortSHA(commit.SHA)
+	}
+	description := fmt.Sprintf("Commit messages breaking the rules: %s", strings.Join(shas, ", "))
+	status := createCommitsStatus("failure", truncateStatusDescription(description))
+	if errResp := setStatus(status); errResp != nil {
+		return errResp
+	}
+	return postStickyComment(issue, commitLintMarker, commitLintComment(violations), botLogin, issues)
+}
+
+func commitLintComment(violations []commitViolations) string {
+	body := commitLintMarker + "\nSome commit messages don't follow the rules of this repository:\n\n"
+	for _, commit := range violations {
+		body += fmt.Sprintf("- %s `%s`: %s\n", shortSHA(commit.SHA), commit.Subject,
+			strings.Join(commit.Violations, "; "))
+	}
+	return body
+}
+
+func createCommitsStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(githubStatusCommitsContext),
+	}
+}
+
+func shortSHA(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
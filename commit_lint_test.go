@@ -0,0 +1,302 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("commit message lint", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+
+			headSHA = "1235abcdef"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "pull_request",
+			}
+		})
+		requestJSON.Is(func() string {
+			return PullRequestEvent("synchronize", headSHA, grh.Repository{
+				Owner: repositoryOwner,
+				Name:  repositoryName,
+				URL:   sshURL,
+			})
+		})
+
+		mockConfigFile := func(content string) {
+			repositories.
+				On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+				Return(&github.RepositoryContent{
+					Content: github.String(content),
+				}, emptyResult, emptyResponse, noError)
+		}
+		mockCommits := func(commits ...commit) {
+			pullRequests.
+				On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+				Return(githubCommits(commits...), emptyResponse, noError)
+		}
+		mockComments := func(comments ...*github.IssueComment) {
+			issues.
+				On("ListComments", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+				Return(comments, &github.Response{}, noError)
+		}
+		expectCommitsStatus := func(state string, matchesDescription func(string) bool) {
+			repositories.
+				On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+					return *status.State == state && *status.Context == "review/commits" &&
+						matchesDescription(*status.Description)
+				})).
+				Return(emptyResult, emptyResponse, noError)
+		}
+		anyDescription := func(string) bool { return true }
+
+		Context("with commit lint configured", func() {
+			BeforeEach(func() {
+				mockConfigFile(`squash_check: false
+commit_lint:
+  max_subject_length: 30
+  imperative_subject: true
+  blank_second_line: true
+  subject_prefixes: ["docs: ", "fix: "]
+`)
+			})
+
+			Context("with all commit messages following the rules", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "docs: Describe the rules\n\nBecause."},
+						commit{"1234abcdef", "fixup! docs: Describe the rules"},
+						commit{headSHA, "fix: Handle empty lists"},
+					)
+					mockComments()
+				})
+
+				It("reports success status to GitHub", func() {
+					expectCommitsStatus("success", anyDescription)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with imperative subjects that end like past tense verbs", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "fix: Proceed on errors"},
+						commit{"1234abcdef", "fix: Succeed without tags"},
+						commit{"1234fedcba", "docs: Shred the old docs"},
+						commit{headSHA, "fix: Exceed the old limit"},
+					)
+					mockComments()
+				})
+
+				It("reports success status to GitHub", func() {
+					expectCommitsStatus("success", anyDescription)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with a message breaking the rules replaced by an amend! commit", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "Added the rules"},
+						commit{headSHA, "amend! Added the rules\n\ndocs: Describe the rules"},
+					)
+					mockComments()
+				})
+
+				It("reports success status to GitHub", func() {
+					expectCommitsStatus("success", anyDescription)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with a message following the rules replaced by an amend! commit breaking them", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "docs: Describe the rules"},
+						commit{headSHA, "amend! docs: Describe the rules\n\nAdded the rules"},
+					)
+					mockComments()
+				})
+
+				It("reports the amended message as failure to GitHub", func() {
+					expectCommitsStatus("failure", func(description string) bool {
+						return strings.Contains(description, arbitrarySHA[:7])
+					})
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+							return strings.Contains(*issueComment.Body, "Added the rules") &&
+								strings.Contains(*issueComment.Body, "imperative mood")
+						})).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with commit messages breaking the rules", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "docs: Describe the rules"},
+						commit{"1234abcdef", "Added a feature that is way too long\nNo blank line"},
+						commit{headSHA, "fix: Fixes a bug"},
+					)
+				})
+
+				itListsTheViolations := func() {
+					expectCommitsStatus("failure", func(description string) bool {
+						return strings.Contains(description, "1234abc") &&
+							strings.Contains(description, "1235abc") &&
+							!strings.Contains(description, arbitrarySHA[:7])
+					})
+				}
+				isLintComment := func(issueComment *github.IssueComment) bool {
+					return strings.HasPrefix(*issueComment.Body, "<!-- review-helper:commit-lint -->") &&
+						strings.Contains(*issueComment.Body, "longer than 30 characters") &&
+						strings.Contains(*issueComment.Body, "doesn't start with any of `docs: `, `fix: `") &&
+						strings.Contains(*issueComment.Body, "imperative mood") &&
+						strings.Contains(*issueComment.Body, "second line isn't blank")
+				}
+
+				Context("without an earlier lint comment", func() {
+					BeforeEach(func() {
+						mockComments()
+					})
+
+					It("reports failure status and comments the violations", func() {
+						itListsTheViolations()
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(isLintComment)).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with an earlier lint comment", func() {
+					BeforeEach(func() {
+						mockComments(&github.IssueComment{
+							ID:   github.Int64(3),
+							Body: github.String("<!-- review-helper:commit-lint -->\nold"),
+							User: &github.User{Login: github.String(botLogin)},
+						})
+					})
+
+					It("updates the earlier comment", func() {
+						itListsTheViolations()
+						issues.
+							On("EditComment", anyContext, repositoryOwner, repositoryName, int64(3), mock.MatchedBy(isLintComment)).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with a comment by another user starting with the marker", func() {
+					BeforeEach(func() {
+						mockComments(&github.IssueComment{
+							ID:   github.Int64(3),
+							Body: github.String("<!-- review-helper:commit-lint -->\nold"),
+							User: &github.User{Login: github.String(arbitraryIssueAuthor)},
+						})
+					})
+
+					It("posts a new comment instead of editing the user's comment", func() {
+						itListsTheViolations()
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(isLintComment)).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						issues.AssertNotCalled(GinkgoT(), "EditComment", anyContext, repositoryOwner, repositoryName,
+							int64(3), mock.Anything)
+					})
+				})
+			})
+		})
+
+		Context("with a body required for big commits", func() {
+			BeforeEach(func() {
+				mockConfigFile(`squash_check: false
+commit_lint:
+  body_required_above: 20
+`)
+				mockCommits(
+					commit{arbitrarySHA, "Change a few things"},
+					commit{headSHA, "Change a lot of things"},
+				)
+				mockComments()
+				repositories.
+					On("GetCommit", anyContext, repositoryOwner, repositoryName, arbitrarySHA, (*github.ListOptions)(nil)).
+					Return(&github.RepositoryCommit{
+						Stats: &github.CommitStats{Total: github.Int(5)},
+					}, emptyResponse, noError)
+				repositories.
+					On("GetCommit", anyContext, repositoryOwner, repositoryName, headSHA, (*github.ListOptions)(nil)).
+					Return(&github.RepositoryCommit{
+						Stats: &github.CommitStats{Total: github.Int(100)},
+					}, emptyResponse, noError)
+			})
+
+			It("only reports the big commit without a body", func() {
+				expectCommitsStatus("failure", func(description string) bool {
+					return strings.Contains(description, "1235abc") && !strings.Contains(description, arbitrarySHA[:7])
+				})
+				issues.
+					On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+						return strings.Contains(*issueComment.Body, "more than 20 lines")
+					})).
+					Return(emptyResult, emptyResponse, noError)
+
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("with an invalid subject pattern", func() {
+			BeforeEach(func() {
+				mockConfigFile("commit_lint:\n  subject_pattern: \"(\"\n")
+			})
+
+			It("fails with an internal error", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Invalid .github/review-helper.yml"))
+			})
+		})
+	})
+})
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+)
+
+const githubStatusCompleteContext = "review/complete"
+
+// completeCheckMarker identifies the comment that the bot keeps up to date
+// with the result of the complete check.
+const completeCheckMarker = "<!-- review-helper:complete -->"
+
+const defaultCompleteCheckTimeout = 10 * time.Minute
+
+// completeCheckFile is the format of the complete_check section of the
+// configuration file.
+type completeCheckFile struct {
+	Command     string `yaml:"command"`
+	Timeout     string `yaml:"timeout"`
+	MaxMemoryMB int    `yaml:"max_memory_mb"`
+}
+
+func parseCompleteCheck(file completeCheckFile) (*git.CommitCheck, error) {
+	if strings.TrimSpace(file.Command) == "" {
+		return nil, errors.New("command must not be empty")
+	} else if file.MaxMemoryMB < 0 {
+		return nil, errors.New("max_memory_mb must not be negative")
+	}
+	timeout := defaultCompleteCheckTimeout
+	if file.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(file.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout: %v", err)
+		} else if timeout <= 0 {
+			return nil, errors.New("timeout must be positive")
+		}
+	}
+	return &git.CommitCheck{
+		Command:     file.Command,
+		Timeout:     timeout,
+		MaxMemoryMB: file.MaxMemoryMB,
+	}, nil
+}
+
+// startCompleteCheck starts running the complete check on every commit of
+// the PR in the background. PRs with fixup commits are checked only after
+// they have been squashed. The check runs the code of the PR as the bot's
+// user, with access to the bot's files and credentials, so the PRs from
+// forks are never checked.
+func startCompleteCheck(issueable Issueable, commits []*github.RepositoryCommit, check *git.CommitCheck,
+	setStatus func(*github.RepoStatus) *ErrorResponse, botLogin string, gitRepos git.Repos,
+	pullRequests PullRequests, repositories Repositories, issues Issues, runAsync runAsyncOperation) *ErrorResponse {
+
+	if includesFixupCommits(commits) {
+		return setStatus(createCompleteStatus("pending", "Waiting for the fixup commits to be squashed"))
+	}
+	pr, errResp := getPR(issueable, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	// getCommits has already made sure that the head can be found
+	head, _ := findTopologicalHead(commits)
+	if *head.SHA != *pr.Head.SHA {
+		log.Printf("PR %s has a new head %s. Not checking %s.\n", prFullName(pr), *pr.Head.SHA, *head.SHA)
+		return nil
+	}
+	if isAcrossForks(pr) {
+		return setStatus(createCompleteStatus("error", "The check isn't run for PRs from forks"))
+	}
+	if errResp := setStatus(createCompleteStatus("pending", "Checking that every commit is complete")); errResp != nil {
+		return errResp
+	}
+	runAsync(func() {
+		runCompleteCheck(pr, *check, botLogin, gitRepos, repositories, issues)
+	})
+	return nil
+}
+
+// runCompleteCheck runs the check and reports the result. It's run in the
+// background, so errors can only be logged.
+func runCompleteCheck(pr *github.PullRequest, check git.CommitCheck, botLogin string, gitRepos git.Repos,
+	repositories Repositories, issues Issues) {
+
+	log.Printf("Running the complete check for PR %s.\n", prFullName(pr))
+	issue := prIssue(pr)
+	report := func(status *github.RepoStatus, stickyComment func() *ErrorResponse) {
+		if errResp := setStatusForPR(pr, status, repositories); errResp != nil {
+			errResp.logResponse()
+			return
+		}
+		if errResp := stickyComment(); errResp != nil {
+			errResp.logResponse()
+		}
+	}
+
+	repository := headRepository(pr)
+	gitRepo, err := gitRepos.GetUpdatedRepo(repository.URL, repository.Owner, repository.Name)
+	if err == nil {
+		err = gitRepo.RunOnEachCommit("origin/"+*pr.Base.Ref, *pr.Head.SHA, check)
+	}
+	var incompleteErr *git.ErrIncompleteCommit
+	if errors.As(err, &incompleteErr) {
+		description := fmt.Sprintf("%s failed for %s", check.Command, shortSHA(incompleteErr.Commit.SHA))
+		report(createCompleteStatus("failure", truncateStatusDescription(description)), func() *ErrorResponse {
+			return postStickyComment(issue, completeCheckMarker, completeCheckComment(check, incompleteErr),
+				botLogin, issues)
+		})
+	} else if err != nil {
+		log.Printf("Failed to run the complete check for PR %s: %v\n", prFullName(pr), err)
+		report(createCompleteStatus("error", "Failed to run the check"), func() *ErrorResponse {
+			return nil
+		})
+	} else {
+		report(createCompleteStatus("success", "Every commit is complete"), func() *ErrorResponse {
+			body := fmt.Sprintf("%s\n`%s` succeeds for every commit now. :+1:", completeCheckMarker, check.Command)
+			return updateStickyComment(issue, completeCheckMarker, body, botLogin, issues)
+		})
+	}
+}
+
+func completeCheckComment(check git.CommitCheck, incompleteErr *git.ErrIncompleteCommit) string {
+	result := "failed"
+	if incompleteErr.TimedOut {
+		result = fmt.Sprintf("timed out after %s", check.Timeout)
+	}
+	output := strings.TrimRight(incompleteErr.Output, "\n")
+	// The fence has to be longer than any backtick sequence in the output
+	fence := "```"
+	for strings.Contains(output, fence) {
+		fence += "`"
+	}
+	return fmt.Sprintf("%s\n`%s` %s for %s. Every commit should be complete, i.e. the code should compile "+
+		"and the tests should pass for every commit.\n\n"+
+		"<details>\n<summary>Output</summary>\n\n%s\n%s\n%s\n</details>\n",
+		completeCheckMarker, check.Command, result, describeCommit(incompleteErr.Commit), fence, output, fence)
+}
+
+func createCompleteStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(description),
+		Context:     github.String(githubStatusCompleteContext),
+	}
+}
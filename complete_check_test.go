@@ -0,0 +1,290 @@
+package main_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/git"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("complete check", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+			gitRepos         *mocks.Repos
+			gitRepo          *mocks.Repo
+
+			headSHA = "1235abcdef"
+			check   = git.CommitCheck{
+				Command:     "make test",
+				Timeout:     5 * time.Minute,
+				MaxMemoryMB: 512,
+			}
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+			gitRepos = *context.GitRepos
+			gitRepo = new(mocks.Repo)
+		})
+		AfterEach(func() {
+			gitRepo.AssertExpectations(GinkgoT())
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "pull_request",
+			}
+		})
+		requestJSON.Is(func() string {
+			return PullRequestEvent("synchronize", headSHA, grh.Repository{
+				Owner: repositoryOwner,
+				Name:  repositoryName,
+				URL:   sshURL,
+			})
+		})
+
+		pr := &github.PullRequest{
+			Number: github.Int(issueNumber),
+			Base: &github.PullRequestBranch{
+				SHA:  github.String("1234"),
+				Ref:  github.String("master"),
+				Repo: repository,
+			},
+			Head: &github.PullRequestBranch{
+				SHA:  github.String(headSHA),
+				Ref:  github.String("feature"),
+				Repo: repository,
+			},
+			User: &github.User{
+				Login: github.String(arbitraryIssueAuthor),
+			},
+		}
+
+		mockCommits := func(commits ...commit) {
+			pullRequests.
+				On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+				Return(githubCommits(commits...), emptyResponse, noError)
+		}
+		mockComments := func(comments ...*github.IssueComment) {
+			issues.
+				On("ListComments", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+				Return(comments, &github.Response{}, noError)
+		}
+		expectCompleteStatus := func(state string, matchesDescription func(string) bool) {
+			repositories.
+				On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+					return *status.State == state && *status.Context == "review/complete" &&
+						matchesDescription(*status.Description)
+				})).
+				Return(emptyResult, emptyResponse, noError)
+		}
+		anyDescription := func(string) bool { return true }
+
+		Context("with the complete check configured", func() {
+			BeforeEach(func() {
+				repositories.
+					On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+					Return(&github.RepositoryContent{
+						Content: github.String(`squash_check: false
+complete_check:
+  command: make test
+  timeout: 5m
+  max_memory_mb: 512
+`),
+					}, emptyResult, emptyResponse, noError)
+			})
+
+			Context("with fixup commits in the PR", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "Add a feature"},
+						commit{headSHA, "fixup! Add a feature"},
+					)
+				})
+
+				It("waits for the commits to be squashed", func() {
+					expectCompleteStatus("pending", func(description string) bool {
+						return strings.Contains(description, "squashed")
+					})
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("without fixup commits in the PR", func() {
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "Add a feature"},
+						commit{headSHA, "Use the feature"},
+					)
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(pr, emptyResponse, noError)
+					gitRepos.
+						On("GetUpdatedRepo", sshURL, repositoryOwner, repositoryName).
+						Return(gitRepo, noError)
+					expectCompleteStatus("pending", anyDescription)
+				})
+
+				Context("with every commit passing the check", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RunOnEachCommit", "origin/master", headSHA, check).
+							Return(noError)
+						mockComments()
+					})
+
+					It("reports success status to GitHub", func() {
+						expectCompleteStatus("success", anyDescription)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with a commit failing the check", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RunOnEachCommit", "origin/master", headSHA, check).
+							Return(&git.ErrIncompleteCommit{
+								Err:    errors.New("exit status 2"),
+								Commit: git.Commit{SHA: arbitrarySHA, Subject: "Add a feature"},
+								Output: "undefined: feature",
+							})
+						mockComments()
+					})
+
+					It("reports the commit and its output", func() {
+						expectCompleteStatus("failure", func(description string) bool {
+							return strings.Contains(description, arbitrarySHA[:7])
+						})
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+								return strings.HasPrefix(*issueComment.Body, "<!-- review-helper:complete -->") &&
+									strings.Contains(*issueComment.Body, "`Add a feature`") &&
+									strings.Contains(*issueComment.Body, "undefined: feature")
+							})).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with the check failing to run", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RunOnEachCommit", "origin/master", headSHA, check).
+							Return(errors.New("worktree failure"))
+					})
+
+					It("reports error status to GitHub", func() {
+						expectCompleteStatus("error", anyDescription)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+
+			Context("with a PR from a fork", func() {
+				forkRepository := grh.Repository{
+					Owner: "other",
+					Name:  "github-review-helper-fork",
+					URL:   "git@github.com:other/github-review-helper-fork.git",
+				}
+				forkPR := *pr
+				forkPR.Head = &github.PullRequestBranch{
+					SHA: github.String(headSHA),
+					Ref: github.String("feature"),
+					Repo: &github.Repository{
+						ID:     github.Int64(repositoryID + 1),
+						Owner:  &github.User{Login: github.String(forkRepository.Owner)},
+						Name:   github.String(forkRepository.Name),
+						SSHURL: github.String(forkRepository.URL),
+					},
+				}
+				expectForkStatus := func(context, state string, matchesDescription func(string) bool) {
+					repositories.
+						On("CreateStatus", anyContext, forkRepository.Owner, forkRepository.Name, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+							return *status.State == state && *status.Context == context &&
+								matchesDescription(*status.Description)
+						})).
+						Return(emptyResult, emptyResponse, noError)
+				}
+
+				BeforeEach(func() {
+					mockCommits(
+						commit{arbitrarySHA, "Add a feature"},
+						commit{headSHA, "Use the feature"},
+					)
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(&forkPR, emptyResponse, noError)
+				})
+
+				Context("with the PR being synchronized", func() {
+					requestJSON.Is(func() string {
+						return PullRequestEvent("synchronize", headSHA, forkRepository)
+					})
+
+					It("refuses to run the check", func() {
+						expectForkStatus("review/complete", "error", func(description string) bool {
+							return strings.Contains(description, "forks")
+						})
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with a collaborator requesting the check", func() {
+					headers.Is(func() map[string]string {
+						return map[string]string{
+							"X-Github-Event": "issue_comment",
+						}
+					})
+					requestJSON.Is(func() string {
+						return IssueCommentEvent("!check", arbitraryIssueAuthor)
+					})
+
+					BeforeEach(func() {
+						repositories.
+							On("IsCollaborator", anyContext, repositoryOwner, repositoryName, arbitraryIssueAuthor).
+							Return(true, emptyResponse, noError)
+					})
+
+					It("still refuses to run the check", func() {
+						expectForkStatus("review/complete", "error", anyDescription)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						gitRepos.AssertNotCalled(GinkgoT(), "GetUpdatedRepo", forkRepository.URL, forkRepository.Owner,
+							forkRepository.Name)
+					})
+				})
+			})
+		})
+	})
+})
@@ -11,27 +11,89 @@ import (
 )
 
 var (
-	portProperty              = gonfigure.NewEnvProperty("PORT", "80")
-	accessTokenProperty       = gonfigure.NewEnvProperty("GITHUB_ACCESS_TOKEN", "")
-	secretProperty            = gonfigure.NewRequiredEnvProperty("GITHUB_SECRET")
+	portProperty        = gonfigure.NewEnvProperty("PORT", "80")
+	accessTokenProperty = gonfigure.NewEnvProperty("GITHUB_ACCESS_TOKEN", "")
+	secretProperty      = gonfigure.NewRequiredEnvProperty("GITHUB_SECRET")
+	// A comma separated list of secrets that are accepted in addition to
+	// GITHUB_SECRET, e.g. the old secret while the secret is being rotated.
+	additionalSecretsProperty = gonfigure.NewEnvProperty("GITHUB_ADDITIONAL_SECRETS", "")
+	// A comma separated list of secrets of specific repositories in the
+	// format of "owner/name=secret". E.g. "salemove/a=x,salemove/a=y". The
+	// webhooks of these repositories are only accepted with their own
+	// secrets.
+	repositorySecretsProperty = gonfigure.NewEnvProperty("GITHUB_REPOSITORY_SECRETS", "")
 	appIDProperty             = gonfigure.NewEnvProperty("GITHUB_APP_ID", "")
 	appPrivateKeyFileProperty = gonfigure.NewEnvProperty("GITHUB_APP_PRIVATE_KEY_FILE", "")
 	appInstallationIDProperty = gonfigure.NewEnvProperty("GITHUB_APP_INSTALLATION_ID", "")
+	// The login of the GitHub user that the bot comments as. The comments
+	// that the bot keeps up to date are only looked for among this user's
+	// comments. It's looked up from GitHub when empty.
+	botLoginProperty = gonfigure.NewEnvProperty("GITHUB_BOT_LOGIN", "")
 	// A comma separated list of durations in the format defined in
 	// time.ParseDuration. E.g. "300ms,1.5h,2h45m". When first duration is 0,
 	// then GitHub API requests will initially be tried synchronously and only
 	// the retries will be asynchronous.
 	githubAPITriesProperty = gonfigure.NewEnvProperty("GITHUB_API_TRIES", "0s,10s,30s,3m")
+	// The number of most recent webhook deliveries whose IDs are remembered
+	// for ignoring redeliveries.
+	deliveryHistorySizeProperty = gonfigure.NewEnvProperty("DELIVERY_HISTORY_SIZE", "10000")
+	// The file that the IDs of the processed deliveries are persisted in.
+	// They're only kept in memory when empty.
+	deliveryHistoryFileProperty = gonfigure.NewEnvProperty("DELIVERY_HISTORY_FILE", "")
+	// The directory that the webhook events are queued in. The events are
+	// processed while handling the webhook requests when empty.
+	eventQueueDirProperty = gonfigure.NewEnvProperty("EVENT_QUEUE_DIR", "")
+	// The directory that the scheduled retries of GitHub API operations are
+	// persisted in, so that they could be resumed after a restart. The
+	// retries are only kept in memory when empty.
+	retryJobsDirProperty = gonfigure.NewEnvProperty("RETRY_JOBS_DIR", "")
+	// The file that the merge methods requested with !merge are persisted in
+	// until the PRs are merged. They're only kept in memory when empty.
+	mergeMethodsFileProperty = gonfigure.NewEnvProperty("MERGE_METHODS_FILE", "")
+	// The number of complete checks that may run at the same time. The rest
+	// of the checks wait for their turn.
+	maxCompleteChecksProperty = gonfigure.NewEnvProperty("MAX_COMPLETE_CHECKS", "2")
+	// The number of workers that process the queued events.
+	eventWorkersProperty = gonfigure.NewEnvProperty("EVENT_WORKERS", "4")
+	// The number of approving reviews from collaborators required for the
+	// review/peer status to be marked as successful. The review/peer status
+	// is not reported at all when this is 0.
+	requiredApprovalsProperty = gonfigure.NewEnvProperty("REQUIRED_APPROVALS", "0")
+	// The key that the commits created by the bot are signed with, in the
+	// format of git's user.signingKey. The commits aren't signed when empty.
+	gitSigningKeyProperty = gonfigure.NewEnvProperty("GIT_SIGNING_KEY", "")
+	// The format of the signatures. One of openpgp, x509 or ssh, like git's
+	// gpg.format.
+	gitSigningFormatProperty = gonfigure.NewEnvProperty("GIT_SIGNING_FORMAT", "openpgp")
+	// The maximum duration of a single git command in the format defined in
+	// time.ParseDuration. The commands aren't limited when it's 0.
+	gitCommandTimeoutProperty = gonfigure.NewEnvProperty("GIT_COMMAND_TIMEOUT", "10m")
 )
 
+var signingFormats = []string{"openpgp", "x509", "ssh"}
+
 type Config struct {
-	Port               int
-	AccessToken        string
-	AppID              int64
-	AppPrivateKeyFile  string
-	AppInstallationID  int64
-	Secret             string
-	GithubAPITryDeltas []time.Duration
+	Port                int
+	AccessToken         string
+	AppID               int64
+	AppPrivateKeyFile   string
+	AppInstallationID   int64
+	BotLogin            string
+	Secret              string
+	AdditionalSecrets   []string
+	RepositorySecrets   map[string][]string
+	GithubAPITryDeltas  []time.Duration
+	RequiredApprovals   int
+	SigningKey          string
+	SigningFormat       string
+	GitCommandTimeout   time.Duration
+	DeliveryHistorySize int
+	DeliveryHistoryFile string
+	EventQueueDir       string
+	EventWorkers        int
+	RetryJobsDir        string
+	MergeMethodsFile    string
+	MaxCompleteChecks   int
 }
 
 func (c Config) IsAppAuth() bool {
@@ -49,6 +111,51 @@ func NewConfig() Config {
 		panic(fmt.Sprintf("Failed to get deltas from GITHUB_API_TRIES durations string: %v", err))
 	}
 
+	requiredApprovals, err := strconv.Atoi(requiredApprovalsProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("REQUIRED_APPROVALS must be a number: %v", err))
+	} else if requiredApprovals < 0 {
+		panic("REQUIRED_APPROVALS must not be negative")
+	}
+
+	signingFormat := gitSigningFormatProperty.Value()
+	if !contains(signingFormats, signingFormat) {
+		panic(fmt.Sprintf("GIT_SIGNING_FORMAT must be one of %v, got \"%s\"", signingFormats, signingFormat))
+	}
+
+	repositorySecrets, err := parseRepositorySecrets(repositorySecretsProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("Failed to parse GITHUB_REPOSITORY_SECRETS: %v", err))
+	}
+
+	deliveryHistorySize, err := strconv.Atoi(deliveryHistorySizeProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("DELIVERY_HISTORY_SIZE must be a number: %v", err))
+	} else if deliveryHistorySize <= 0 {
+		panic("DELIVERY_HISTORY_SIZE must be positive")
+	}
+
+	eventWorkers, err := strconv.Atoi(eventWorkersProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("EVENT_WORKERS must be a number: %v", err))
+	} else if eventWorkers <= 0 {
+		panic("EVENT_WORKERS must be positive")
+	}
+
+	maxCompleteChecks, err := strconv.Atoi(maxCompleteChecksProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("MAX_COMPLETE_CHECKS must be a number: %v", err))
+	} else if maxCompleteChecks <= 0 {
+		panic("MAX_COMPLETE_CHECKS must be positive")
+	}
+
+	gitCommandTimeout, err := time.ParseDuration(gitCommandTimeoutProperty.Value())
+	if err != nil {
+		panic(fmt.Sprintf("GIT_COMMAND_TIMEOUT must be a duration: %v", err))
+	} else if gitCommandTimeout < 0 {
+		panic("GIT_COMMAND_TIMEOUT must not be negative")
+	}
+
 	accessToken := accessTokenProperty.Value()
 	appIDStr := appIDProperty.Value()
 	appPrivateKeyFile := appPrivateKeyFileProperty.Value()
@@ -80,14 +187,53 @@ func NewConfig() Config {
 	}
 
 	return Config{
-		Port:               port,
-		AccessToken:        accessToken,
-		AppID:              appID,
-		AppPrivateKeyFile:  appPrivateKeyFile,
-		AppInstallationID:  appInstallationID,
-		Secret:             secretProperty.Value(),
-		GithubAPITryDeltas: githubAPITryDeltas,
+		Port:                port,
+		AccessToken:         accessToken,
+		AppID:               appID,
+		AppPrivateKeyFile:   appPrivateKeyFile,
+		AppInstallationID:   appInstallationID,
+		BotLogin:            botLoginProperty.Value(),
+		Secret:              secretProperty.Value(),
+		AdditionalSecrets:   splitList(additionalSecretsProperty.Value()),
+		RepositorySecrets:   repositorySecrets,
+		GithubAPITryDeltas:  githubAPITryDeltas,
+		RequiredApprovals:   requiredApprovals,
+		SigningKey:          gitSigningKeyProperty.Value(),
+		SigningFormat:       signingFormat,
+		GitCommandTimeout:   gitCommandTimeout,
+		DeliveryHistorySize: deliveryHistorySize,
+		DeliveryHistoryFile: deliveryHistoryFileProperty.Value(),
+		EventQueueDir:       eventQueueDirProperty.Value(),
+		EventWorkers:        eventWorkers,
+		RetryJobsDir:        retryJobsDirProperty.Value(),
+		MergeMethodsFile:    mergeMethodsFileProperty.Value(),
+		MaxCompleteChecks:   maxCompleteChecks,
+	}
+}
+
+// splitList splits a comma separated list, leaving out empty items.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseRepositorySecrets(list string) (map[string][]string, error) {
+	secrets := map[string][]string{}
+	for i, item := range splitList(list) {
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || parts[1] == "" || strings.Count(parts[0], "/") != 1 {
+			// The entry itself isn't included, so that the secret isn't
+			// logged
+			return nil, fmt.Errorf("entry %d isn't in the format of \"owner/name=secret\"", i+1)
+		}
+		secrets[parts[0]] = append(secrets[parts[0]], parts[1])
 	}
+	return secrets, nil
 }
 
 func getDeltasFromDurationsString(durationsString string) ([]time.Duration, error) {
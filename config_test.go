@@ -65,6 +65,44 @@ var _ = Describe("Config", func() {
 		})
 	})
 
+	Describe("GITHUB_ADDITIONAL_SECRETS and GITHUB_REPOSITORY_SECRETS", func() {
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "GITHUB_ADDITIONAL_SECRETS", value: "old-secret, older-secret"})
+			setEnvVar(envVar{name: "GITHUB_REPOSITORY_SECRETS", value: "salemove/a=x,salemove/a=y=z,salemove/b=w"})
+
+			It("are passed as lists of secrets", func() {
+				conf := grh.NewConfig()
+				Expect(conf.AdditionalSecrets).To(Equal([]string{"old-secret", "older-secret"}))
+				Expect(conf.RepositorySecrets).To(Equal(map[string][]string{
+					"salemove/a": {"x", "y=z"},
+					"salemove/b": {"w"},
+				}))
+			})
+		})
+
+		Context("with a repository secret without a repository", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "GITHUB_REPOSITORY_SECRETS", value: "x"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("default to no additional secrets", func() {
+				conf := grh.NewConfig()
+				Expect(conf.AdditionalSecrets).To(BeEmpty())
+				Expect(conf.RepositorySecrets).To(BeEmpty())
+			})
+		})
+	})
+
 	Describe("PORT", func() {
 		name := "PORT"
 
@@ -194,6 +232,282 @@ var _ = Describe("Config", func() {
 		})
 	})
 
+	Describe("REQUIRED_APPROVALS", func() {
+		name := "REQUIRED_APPROVALS"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "2"})
+
+			It("is passed as an int", func() {
+				conf := grh.NewConfig()
+				Expect(conf.RequiredApprovals).To(Equal(2))
+			})
+		})
+
+		Context("when negative", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "-1"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("defaults to 0, disabling the review/peer status", func() {
+				conf := grh.NewConfig()
+				Expect(conf.RequiredApprovals).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("GIT_SIGNING_KEY and GIT_SIGNING_FORMAT", func() {
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "GIT_SIGNING_KEY", value: "/keys/bot"})
+			setEnvVar(envVar{name: "GIT_SIGNING_FORMAT", value: "ssh"})
+
+			It("are passed as strings", func() {
+				conf := grh.NewConfig()
+				Expect(conf.SigningKey).To(Equal("/keys/bot"))
+				Expect(conf.SigningFormat).To(Equal("ssh"))
+			})
+		})
+
+		Context("with an unknown format", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "GIT_SIGNING_FORMAT", value: "pgp"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("default to not signing with the openpgp format", func() {
+				conf := grh.NewConfig()
+				Expect(conf.SigningKey).To(Equal(""))
+				Expect(conf.SigningFormat).To(Equal("openpgp"))
+			})
+		})
+	})
+
+	Describe("DELIVERY_HISTORY_SIZE and DELIVERY_HISTORY_FILE", func() {
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "DELIVERY_HISTORY_SIZE", value: "50"})
+			setEnvVar(envVar{name: "DELIVERY_HISTORY_FILE", value: "/var/lib/review-helper/deliveries"})
+
+			It("are passed as an int and a string", func() {
+				conf := grh.NewConfig()
+				Expect(conf.DeliveryHistorySize).To(Equal(50))
+				Expect(conf.DeliveryHistoryFile).To(Equal("/var/lib/review-helper/deliveries"))
+			})
+		})
+
+		Context("with a size of 0", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "DELIVERY_HISTORY_SIZE", value: "0"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("default to remembering the deliveries in memory", func() {
+				conf := grh.NewConfig()
+				Expect(conf.DeliveryHistorySize).To(Equal(10000))
+				Expect(conf.DeliveryHistoryFile).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("EVENT_QUEUE_DIR and EVENT_WORKERS", func() {
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "EVENT_QUEUE_DIR", value: "/var/lib/review-helper/queue"})
+			setEnvVar(envVar{name: "EVENT_WORKERS", value: "8"})
+
+			It("are passed as a string and an int", func() {
+				conf := grh.NewConfig()
+				Expect(conf.EventQueueDir).To(Equal("/var/lib/review-helper/queue"))
+				Expect(conf.EventWorkers).To(Equal(8))
+			})
+		})
+
+		Context("with no workers", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: "EVENT_WORKERS", value: "0"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("default to processing the events without a queue", func() {
+				conf := grh.NewConfig()
+				Expect(conf.EventQueueDir).To(Equal(""))
+				Expect(conf.EventWorkers).To(Equal(4))
+			})
+		})
+	})
+
+	Describe("MAX_COMPLETE_CHECKS", func() {
+		name := "MAX_COMPLETE_CHECKS"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "5"})
+
+			It("is passed as an int", func() {
+				conf := grh.NewConfig()
+				Expect(conf.MaxCompleteChecks).To(Equal(5))
+			})
+		})
+
+		Context("when not positive", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "0"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("defaults to 2", func() {
+				conf := grh.NewConfig()
+				Expect(conf.MaxCompleteChecks).To(Equal(2))
+			})
+		})
+	})
+
+	Describe("RETRY_JOBS_DIR", func() {
+		name := "RETRY_JOBS_DIR"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "/var/lib/review-helper/retries"})
+
+			It("is passed as a string", func() {
+				conf := grh.NewConfig()
+				Expect(conf.RetryJobsDir).To(Equal("/var/lib/review-helper/retries"))
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("defaults to keeping the retries in memory", func() {
+				conf := grh.NewConfig()
+				Expect(conf.RetryJobsDir).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("MERGE_METHODS_FILE", func() {
+		name := "MERGE_METHODS_FILE"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "/var/lib/review-helper/merge-methods.json"})
+
+			It("is passed as a string", func() {
+				conf := grh.NewConfig()
+				Expect(conf.MergeMethodsFile).To(Equal("/var/lib/review-helper/merge-methods.json"))
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("defaults to keeping the merge methods in memory", func() {
+				conf := grh.NewConfig()
+				Expect(conf.MergeMethodsFile).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("GITHUB_BOT_LOGIN", func() {
+		name := "GITHUB_BOT_LOGIN"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "review-helper[bot]"})
+
+			It("is passed as a string", func() {
+				conf := grh.NewConfig()
+				Expect(conf.BotLogin).To(Equal("review-helper[bot]"))
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("is left to be looked up from GitHub", func() {
+				conf := grh.NewConfig()
+				Expect(conf.BotLogin).To(Equal(""))
+			})
+		})
+	})
+
+	Describe("GIT_COMMAND_TIMEOUT", func() {
+		name := "GIT_COMMAND_TIMEOUT"
+
+		Context("when set", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "90s"})
+
+			It("is passed as a duration", func() {
+				conf := grh.NewConfig()
+				Expect(conf.GitCommandTimeout).To(Equal(90 * time.Second))
+			})
+		})
+
+		Context("when not a duration", func() {
+			setEnvVars(requiredEnvVars)
+			setEnvVar(envVar{name: name, value: "10"})
+
+			It("panics", func() {
+				Expect(func() {
+					grh.NewConfig()
+				}).To(Panic())
+			})
+		})
+
+		Context("when not set", func() {
+			setEnvVars(requiredEnvVars)
+
+			It("defaults to a value", func() {
+				conf := grh.NewConfig()
+				Expect(conf.GitCommandTimeout).To(Equal(10 * time.Minute))
+			})
+		})
+	})
+
 	Describe("GitHub App authentication", func() {
 		var appAuthEnvVars = []envVar{
 			{name: "GITHUB_SECRET", value: "secret"},
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+type deliveryState int
+
+const (
+	deliveryInProgress deliveryState = iota
+	deliveryProcessed
+)
+
+// processedDeliveries remembers the IDs of the most recently processed
+// webhook deliveries, so that the deliveries that GitHub or a user
+// redelivers aren't processed twice. If a file is configured, the processed
+// IDs are appended to it and loaded from it on startup.
+type processedDeliveries struct {
+	mutex    sync.Mutex
+	capacity int
+	states   map[string]deliveryState
+	// processed lists the processed IDs, oldest first, for forgetting the
+	// oldest ones when there are more than capacity of them
+	processed []string
+	path      string
+	file      *os.File
+	// fileLines is the number of IDs in the file, which is compacted when
+	// it grows to twice the capacity
+	fileLines int
+}
+
+func newProcessedDeliveries(capacity int, path string) (*processedDeliveries, error) {
+	d := &processedDeliveries{
+		capacity: capacity,
+		states:   map[string]deliveryState{},
+		path:     path,
+	}
+	if path == "" {
+		return d, nil
+	}
+	if err := d.load(); err != nil {
+		return nil, fmt.Errorf("failed to load the processed deliveries from %s: %v", path, err)
+	}
+	if err := d.compact(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// start marks the delivery as being processed. Returns false if the delivery
+// has already been processed or is being processed. Deliveries without an ID
+// are always processed.
+func (d *processedDeliveries) start(id string) bool {
+	if id == "" {
+		return true
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if _, exists := d.states[id]; exists {
+		return false
+	}
+	d.states[id] = deliveryInProgress
+	return true
+}
+
+// finish records the delivery as processed if processing it succeeded.
+// Deliveries that failed are forgotten, so that they could be redelivered.
+func (d *processedDeliveries) finish(id string, succeeded bool) {
+	if id == "" {
+		return
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if !succeeded {
+		delete(d.states, id)
+		return
+	}
+	d.add(id)
+	if d.file == nil {
+		return
+	}
+	if _, err := fmt.Fprintln(d.file, id); err != nil {
+		log.Printf("Failed to persist the processed delivery %s: %v\n", id, err)
+		return
+	}
+	d.fileLines++
+	if d.fileLines >= 2*d.capacity {
+		if err := d.compact(); err != nil {
+			log.Printf("Failed to compact the processed deliveries file: %v\n", err)
+		}
+	}
+}
+
+// add records the delivery as processed, forgetting the oldest processed
+// delivery if there are too many.
+func (d *processedDeliveries) add(id string) {
+	if state, exists := d.states[id]; exists && state == deliveryProcessed {
+		// The file might list a delivery more than once
+		return
+	}
+	d.states[id] = deliveryProcessed
+	d.processed = append(d.processed, id)
+	if len(d.processed) > d.capacity {
+		delete(d.states, d.processed[0])
+		d.processed = d.processed[1:]
+	}
+}
+
+func (d *processedDeliveries) load() error {
+	file, err := os.Open(d.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id := strings.TrimSpace(scanner.Text()); id != "" {
+			d.add(id)
+		}
+	}
+	return scanner.Err()
+}
+
+// compact replaces the file with one that only lists the remembered IDs and
+// opens it for appending.
+func (d *processedDeliveries) compact() error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(d.path), filepath.Base(d.path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create the processed deliveries file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	writer := bufio.NewWriter(tmpFile)
+	for _, id := range d.processed {
+		fmt.Fprintln(writer, id)
+	}
+	if err := writer.Flush(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write the processed deliveries file: %v", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write the processed deliveries file: %v", err)
+	}
+	if err := os.Rename(tmpFile.Name(), d.path); err != nil {
+		return fmt.Errorf("failed to replace the processed deliveries file: %v", err)
+	}
+	file, err := os.OpenFile(d.path, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the processed deliveries file: %v", err)
+	}
+	if d.file != nil {
+		d.file.Close()
+	}
+	d.file = file
+	d.fileLines = len(d.processed)
+	return nil
+}
@@ -0,0 +1,163 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("X-GitHub-Delivery", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			conf             *grh.Config
+			request          *http.Request
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+		)
+		BeforeEach(func() {
+			conf = context.Config
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+		})
+		JustBeforeEach(func() {
+			request = *context.Request
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event":    "issue_comment",
+				"X-GitHub-Delivery": "delivery-1",
+			}
+		})
+		requestJSON.Is(func() string {
+			return IssueCommentEvent("!cancel", arbitraryIssueAuthor)
+		})
+
+		// expectCancels expects the !cancel command to be processed the given
+		// number of times
+		expectCancels := func(times int) {
+			repositories.
+				On("IsCollaborator", anyContext, repositoryOwner, repositoryName, arbitraryIssueAuthor).
+				Return(true, emptyResponse, noError).
+				Times(times)
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError).
+				Times(times)
+			issues.
+				On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.Anything).
+				Return(emptyResult, emptyResponse, noError).
+				Times(times)
+			pullRequests.
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(&github.PullRequest{
+					Number: github.Int(issueNumber),
+					Head: &github.PullRequestBranch{
+						SHA: github.String(arbitrarySHA),
+					},
+				}, emptyResponse, noError).
+				Times(times)
+		}
+
+		Context("with the same delivery received twice", func() {
+			It("only processes it once", func() {
+				expectCancels(1)
+
+				handle()
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("already been received"))
+			})
+		})
+
+		Context("with different deliveries of the same event", func() {
+			It("processes both", func() {
+				expectCancels(2)
+
+				handle()
+				request.Header.Set("X-GitHub-Delivery", "delivery-2")
+				handle()
+				Expect(responseRecorder.Body.String()).NotTo(ContainSubstring("already been received"))
+			})
+		})
+
+		Context("with processing the first delivery failing", func() {
+			It("processes the redelivery", func() {
+				repositories.
+					On("IsCollaborator", anyContext, repositoryOwner, repositoryName, arbitraryIssueAuthor).
+					Return(false, emptyResponse, errArbitrary).
+					Once()
+				expectCancels(1)
+
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				handle()
+				Expect(responseRecorder.Body.String()).NotTo(ContainSubstring("already been received"))
+			})
+		})
+
+		Context("with only one delivery remembered", func() {
+			BeforeEach(func() {
+				conf.DeliveryHistorySize = 1
+			})
+
+			It("processes a redelivery after another delivery", func() {
+				expectCancels(3)
+
+				handle()
+				request.Header.Set("X-GitHub-Delivery", "delivery-2")
+				handle()
+				request.Header.Set("X-GitHub-Delivery", "delivery-1")
+				handle()
+			})
+		})
+
+		Context("with a history file", func() {
+			var dir, historyFile string
+			BeforeEach(func() {
+				var err error
+				dir, err = os.MkdirTemp("", "deliveries-test")
+				Expect(err).NotTo(HaveOccurred())
+				historyFile = filepath.Join(dir, "deliveries")
+				Expect(os.WriteFile(historyFile, []byte("delivery-1\n"), 0600)).To(Succeed())
+				conf.DeliveryHistoryFile = historyFile
+			})
+			AfterEach(func() {
+				os.RemoveAll(dir)
+			})
+
+			It("ignores the deliveries processed before the restart", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("already been received"))
+			})
+
+			It("persists the processed deliveries", func() {
+				expectCancels(1)
+
+				request.Header.Set("X-GitHub-Delivery", "delivery-2")
+				handle()
+				contents, err := os.ReadFile(historyFile)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(contents)).To(Equal("delivery-1\ndelivery-2\n"))
+			})
+		})
+	})
+})
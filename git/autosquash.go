@@ -0,0 +1,130 @@
+package git
+
+import "strings"
+
+// squashGroup is a commit together with the fixup!, squash! and amend!
+// commits that autosquash squashes into it.
+type squashGroup struct {
+	commit   commitMessage
+	squashed []commitMessage
+}
+
+// IsFixup reports whether the subject is the subject of a fixup!, squash! or
+// amend! commit.
+func IsFixup(subject string) bool {
+	_, isFixup := fixupTargetSubject(subject)
+	return isFixup
+}
+
+// OrphanedFixups returns the fixup!, squash! and amend! commits that `git
+// rebase --autosquash` leaves as they are, because their target isn't among
+// the commits before them. The commits must be ordered like they are rebased,
+// i.e. parents before their children.
+func OrphanedFixups(commits []Commit) []Commit {
+	orphans := []Commit{}
+	for _, group := range squashGroups(toCommitMessages(commits)) {
+		if IsFixup(group.commit.Subject) {
+			orphans = append(orphans, group.commit.Commit)
+		}
+	}
+	return orphans
+}
+
+// FixupTargets returns the SHAs of the commits that `git rebase --autosquash`
+// squashes the fixup!, squash! and amend! commits into, keyed by the SHAs of
+// the fixups. Orphaned fixups are left out. The commits must be ordered like
+// for OrphanedFixups.
+func FixupTargets(commits []Commit) map[string]string {
+	targets := map[string]string{}
+	for _, group := range squashGroups(toCommitMessages(commits)) {
+		for _, squashed := range group.squashed {
+			targets[squashed.SHA] = group.commit.SHA
+		}
+	}
+	return targets
+}
+
+func toCommitMessages(commits []Commit) []commitMessage {
+	messages := make([]commitMessage, len(commits))
+	for i, commit := range commits {
+		messages[i] = commitMessage{Commit: commit}
+	}
+	return messages
+}
+
+// squashGroups groups the commits, oldest first, like `git rebase
+// --autosquash` does. The fixup!, squash! and amend! commits whose target
+// isn't found are left as they are, so they form groups of their own.
+func squashGroups(commits []commitMessage) []squashGroup {
+	groups := []squashGroup{}
+	groupOf := make([]int, len(commits))
+	// Like in git, only the subjects of the commits that aren't squashed are
+	// matched exactly
+	indexBySubject := map[string]int{}
+	for i, commit := range commits {
+		if target, isFixup := fixupTargetSubject(commit.Subject); isFixup {
+			if j, found := findFixupTarget(commits[:i], indexBySubject, target); found {
+				group := groupOf[j]
+				groups[group].squashed = append(groups[group].squashed, commit)
+				groupOf[i] = group
+				continue
+			}
+		}
+		groupOf[i] = len(groups)
+		if _, exists := indexBySubject[commit.Subject]; !exists {
+			indexBySubject[commit.Subject] = i
+		}
+		groups = append(groups, squashGroup{commit: commit})
+	}
+	return groups
+}
+
+// fixupTargetSubject strips all the fixup!, squash! and amend! prefixes from
+// the subject.
+func fixupTargetSubject(subject string) (string, bool) {
+	target, isFixup := trimFixupPrefix(subject)
+	if !isFixup {
+		return subject, false
+	}
+	for {
+		target = strings.TrimLeft(target, " \t")
+		stripped, isNested := trimFixupPrefix(target)
+		if !isNested {
+			return target, true
+		}
+		target = stripped
+	}
+}
+
+func trimFixupPrefix(subject string) (string, bool) {
+	for _, prefix := range []string{"fixup! ", "squash! ", "amend! "} {
+		if strings.HasPrefix(subject, prefix) {
+			return strings.TrimPrefix(subject, prefix), true
+		}
+	}
+	return subject, false
+}
+
+// findFixupTarget finds the commit that a fixup commit with the given target
+// is squashed into. Like in git, the target is matched by the subject, by
+// the SHA and finally by a prefix of the subject.
+func findFixupTarget(earlierCommits []commitMessage, indexBySubject map[string]int, target string) (int,
+	bool) {
+
+	if i, found := indexBySubject[target]; found {
+		return i, true
+	}
+	if len(target) >= 4 && !strings.Contains(target, " ") {
+		for i, commit := range earlierCommits {
+			if strings.HasPrefix(commit.SHA, target) {
+				return i, true
+			}
+		}
+	}
+	for i, commit := range earlierCommits {
+		if strings.HasPrefix(commit.Subject, target) {
+			return i, true
+		}
+	}
+	return 0, false
+}
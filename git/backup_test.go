@@ -0,0 +1,179 @@
+package git_test
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/salemove/github-review-helper/git"
+)
+
+func TestBackup(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "--fixup=@")
+	originalSHA := testRepoGit("rev-parse", featureBranchName)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	checkError(t, err)
+
+	// Check that the original head was backed up
+	backups := testRepoGit("for-each-ref", "--format=%(objectname)", backupPrefix)
+	if backups != originalSHA {
+		t.Fatalf("Expected %s to be backed up, but got backups: %q", originalSHA, backups)
+	}
+
+	// Check that the backup records the pushed head
+	squashedSHA := testRepoGit("rev-parse", featureBranchName)
+	backupRefs := testRepoGit("for-each-ref", "--format=%(refname)", backupPrefix)
+	if !strings.HasSuffix(backupRefs, "-"+squashedSHA) {
+		t.Fatalf("Expected the backup to record %s, but got backups: %q", squashedSHA, backupRefs)
+	}
+
+	restoredSHA, err := repo.RestoreLatestBackup(backupPrefix, featureBranchName)
+	checkError(t, err)
+
+	if restoredSHA != originalSHA {
+		t.Fatalf("Expected %s to be restored, but got %s", originalSHA, restoredSHA)
+	}
+	if featureSHA := testRepoGit("rev-parse", featureBranchName); featureSHA != originalSHA {
+		t.Fatalf("Expected the feature branch to point to %s, but it points to %s", originalSHA, featureSHA)
+	}
+	if backups := testRepoGit("for-each-ref", backupPrefix); backups != "" {
+		t.Fatalf("Expected the restored backup to be deleted, but got backups: %q", backups)
+	}
+
+	_, err = repo.RestoreLatestBackup(backupPrefix, featureBranchName)
+	if !errors.Is(err, git.ErrNoBackup) {
+		t.Fatalf("Expected a no backup error, but got: %v", err)
+	}
+}
+
+func TestBackup_withNothingToSquash(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	originalSHA := testRepoGit("rev-parse", featureBranchName)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	squashedSHA, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName,
+		backupPrefix, false)
+	checkError(t, err)
+
+	if squashedSHA != originalSHA {
+		t.Fatalf("Expected the squashed HEAD to be %s, but got %s", originalSHA, squashedSHA)
+	}
+	if backups := testRepoGit("for-each-ref", backupPrefix); backups != "" {
+		t.Fatalf("Expected nothing to be backed up, but got backups: %q", backups)
+	}
+
+	_, err = repo.RestoreLatestBackup(backupPrefix, featureBranchName)
+	if !errors.Is(err, git.ErrNoBackup) {
+		t.Fatalf("Expected a no backup error, but got: %v", err)
+	}
+}
+
+func TestRestoreLatestBackup_withCommitsPushedAfterTheBackup(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	testRepoGit("commit", "--allow-empty", "--fixup=@")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	checkError(t, err)
+
+	// Push a new commit on top of the squashed one
+	testRepoGit("checkout", featureBranchName)
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+	newSHA := testRepoGit("rev-parse", "@")
+	testRepoGit("checkout", "master")
+
+	err = repo.Fetch()
+	checkError(t, err)
+
+	_, err = repo.RestoreLatestBackup(backupPrefix, featureBranchName)
+	var movedErr *git.ErrBranchMoved
+	if !errors.As(err, &movedErr) {
+		t.Fatalf("Expected a branch moved error, but got: %v", err)
+	}
+	if featureSHA := testRepoGit("rev-parse", featureBranchName); featureSHA != newSHA {
+		t.Fatalf("Expected the feature branch to still point to %s, but it points to %s", newSHA, featureSHA)
+	}
+	if backups := testRepoGit("for-each-ref", backupPrefix); backups == "" {
+		t.Fatal("Expected the backup to be kept")
+	}
+}
+
+func TestDeleteBackups(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	headSHA := testRepoGit("rev-parse", "@")
+	testRepoGit("update-ref", backupPrefix+"1", headSHA)
+	testRepoGit("update-ref", backupPrefix+"2", headSHA)
+	otherBackupRef := git.BackupPrefix(2) + "1"
+	testRepoGit("update-ref", otherBackupRef, headSHA)
+
+	reposDir, cleanup := createTempDir(t)
+	defer cleanup()
+
+	err := git.NewRepos(reposDir, git.Config{}).DeleteBackups(testRepoDir, backupPrefix)
+	checkError(t, err)
+
+	// The backups are deleted without cloning the repo
+	if entries, err := os.ReadDir(reposDir); err != nil || len(entries) != 0 {
+		t.Fatalf("Expected the repos directory to be left empty, but got: %v, %v", entries, err)
+	}
+
+	backups := strings.Fields(testRepoGit("for-each-ref", "--format=%(refname)", "refs/review-helper/"))
+	if len(backups) != 1 || backups[0] != otherBackupRef {
+		t.Fatalf("Expected only the backups of the other PR to be left, but got: %v", backups)
+	}
+}
@@ -0,0 +1,78 @@
+package git
+
+import (
+	"fmt"
+	"strings"
+)
+
+// addCoAuthors adds the authors of the commits that were squashed as
+// Co-authored-by trailers to the squashed commits between upstreamRef and
+// the current HEAD. The current HEAD is moved to the rewritten commits.
+func (r *repo) addCoAuthors(upstreamRef, originalRef string) error {
+	originalCommits, err := r.commitMessages(upstreamRef + ".." + originalRef)
+	if err != nil {
+		return err
+	}
+	groups := squashGroups(originalCommits)
+	squashedCommits, err := r.commitMessages(upstreamRef + "..@")
+	if err != nil {
+		return err
+	} else if len(squashedCommits) != len(groups) {
+		return fmt.Errorf("expected %d commits after squashing, but found %d", len(groups),
+			len(squashedCommits))
+	}
+	rewriting := false
+	for i, squashed := range squashedCommits {
+		coAuthors := groups[i].coAuthors()
+		if !rewriting && len(coAuthors) == 0 {
+			continue
+		}
+		if !rewriting {
+			// The commits before the first one that gets co-authors can be
+			// kept as they are
+			if err := r.git("checkout", "--detach", squashed.SHA); err != nil {
+				return fmt.Errorf("failed to check out %s: %v", squashed.SHA, err)
+			}
+			rewriting = true
+		} else if err := r.git("cherry-pick", "--allow-empty", "--keep-redundant-commits", squashed.SHA); err != nil {
+			return fmt.Errorf("failed to cherry-pick %s: %v", squashed.SHA, err)
+		}
+		if len(coAuthors) == 0 {
+			continue
+		}
+		// Trailers that the message already has aren't added again
+		args := []string{"-c", "trailer.ifExists=addIfDifferent", "commit", "--amend", "--no-edit", "--allow-empty"}
+		for _, coAuthor := range coAuthors {
+			args = append(args, "--trailer", "Co-authored-by: "+coAuthor)
+		}
+		if err := r.git(args...); err != nil {
+			return fmt.Errorf("failed to add the co-authors to %s: %v", squashed.SHA, err)
+		}
+	}
+	return nil
+}
+
+// coAuthors returns the distinct authors of the squashed commits, other than
+// the author of the commit they're squashed into, in the order of the
+// commits.
+func (g squashGroup) coAuthors() []string {
+	seen := map[string]bool{authorEmail(g.commit.author): true}
+	coAuthors := []string{}
+	for _, commit := range g.squashed {
+		email := authorEmail(commit.author)
+		if seen[email] {
+			continue
+		}
+		seen[email] = true
+		coAuthors = append(coAuthors, commit.author)
+	}
+	return coAuthors
+}
+
+func authorEmail(author string) string {
+	start := strings.LastIndex(author, "<")
+	if start == -1 {
+		return strings.ToLower(author)
+	}
+	return strings.ToLower(strings.TrimSuffix(author[start+1:], ">"))
+}
@@ -0,0 +1,138 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// commandWaitDelay limits how long a cancelled command's output is waited for
+// after the command has been killed.
+const commandWaitDelay = 5 * time.Second
+
+// command describes a single invocation of an external command.
+type command struct {
+	name string
+	args []string
+	// dir is the working directory of the command. The current directory
+	// is used if it's empty.
+	dir string
+	// env is added to the environment of the process for this invocation
+	// only, so that concurrent commands don't affect each other
+	env []string
+	// quiet disables logging the output of the command
+	quiet bool
+}
+
+func (c command) String() string {
+	return strings.Join(append([]string{c.name}, c.args...), " ")
+}
+
+// ErrCommand is returned when a command fails or is cancelled.
+type ErrCommand struct {
+	Err     error
+	Command string
+	Stdout  string
+	Stderr  string
+}
+
+func (e *ErrCommand) Error() string {
+	message := fmt.Sprintf("`%s` failed: %v", e.Command, e.Err)
+	if stderr := strings.TrimSpace(e.Stderr); stderr != "" {
+		message += ": " + stderr
+	}
+	return message
+}
+
+func (e *ErrCommand) Unwrap() error {
+	return e.Err
+}
+
+// runCommand runs the command until it exits or ctx is done, and returns its
+// standard output. The output is logged line by line unless the command is
+// quiet. If the command fails, an *ErrCommand with both the standard output
+// and the standard error is returned.
+func runCommand(ctx context.Context, c command) (string, error) {
+	cmd := exec.CommandContext(ctx, c.name, c.args...)
+	cmd.Dir = c.dir
+	if len(c.env) > 0 {
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	cmd.WaitDelay = commandWaitDelay
+	stdout := &outputLogger{prefix: c.name, quiet: c.quiet}
+	stderr := &outputLogger{prefix: c.name, quiet: c.quiet}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	err := cmd.Run()
+	stdout.flush()
+	stderr.flush()
+	if err != nil {
+		if ctx.Err() != nil {
+			// The exit status of a killed process doesn't tell why it
+			// was killed
+			err = ctx.Err()
+		}
+		return stdout.String(), &ErrCommand{
+			Err:     err,
+			Command: c.String(),
+			Stdout:  stdout.String(),
+			Stderr:  stderr.String(),
+		}
+	}
+	return stdout.String(), nil
+}
+
+// outputLogger keeps everything that's written to it and logs it line by
+// line.
+type outputLogger struct {
+	sync.Mutex
+	prefix  string
+	quiet   bool
+	output  bytes.Buffer
+	partial []byte
+}
+
+func (l *outputLogger) Write(p []byte) (int, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	l.output.Write(p)
+	if l.quiet {
+		return len(p), nil
+	}
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i == -1 {
+			break
+		}
+		log.Printf("%s: %s\n", l.prefix, l.partial[:i])
+		l.partial = l.partial[i+1:]
+	}
+	return len(p), nil
+}
+
+// flush logs the last line if it didn't end with a newline.
+func (l *outputLogger) flush() {
+	l.Lock()
+	defer l.Unlock()
+
+	if len(l.partial) > 0 {
+		log.Printf("%s: %s\n", l.prefix, l.partial)
+		l.partial = nil
+	}
+}
+
+func (l *outputLogger) String() string {
+	l.Lock()
+	defer l.Unlock()
+
+	return l.output.String()
+}
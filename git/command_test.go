@@ -0,0 +1,53 @@
+package git_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/salemove/github-review-helper/git"
+)
+
+func TestGetUpdatedRepo_commandTimeout(t *testing.T) {
+	skipWithoutGit(t)
+
+	_, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	reposDir, cleanup := createTempDir(t)
+	defer cleanup()
+
+	gitRepos := git.NewRepos(reposDir, git.Config{CommandTimeout: time.Nanosecond})
+	_, err := gitRepos.GetUpdatedRepo(testRepoDir, "my", "test-repo")
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Fatalf("Expected cloning to time out, got: %v", err)
+	}
+}
+
+func TestSquash_doesNotChangeProcessEnvironment(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	testRepoGit("checkout", "-b", "feature")
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "fixup! Add foo")
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/feature", "feature", backupPrefix, false)
+	checkError(t, err)
+
+	if _, isSet := os.LookupEnv("GIT_SEQUENCE_EDITOR"); isSet {
+		t.Fatal("Expected GIT_SEQUENCE_EDITOR to only be set for the rebase command")
+	}
+}
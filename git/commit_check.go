@@ -0,0 +1,156 @@
+package git
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// maxCommitCheckOutput is the number of bytes kept from the end of a failed
+// command's output.
+const maxCommitCheckOutput = 10000
+
+// CommitCheck configures a command that is run on commits.
+type CommitCheck struct {
+	// Command is run with `sh -c` in the root of the worktree. It runs as
+	// the bot's user, so it isn't isolated from the bot's files and
+	// processes. It must only be run for trusted code.
+	Command string
+	// Timeout limits the time the command may run for a single commit. The
+	// processes that the command started are killed as well.
+	Timeout time.Duration
+	// MaxMemoryMB limits the virtual memory of the command. Zero means no
+	// limit.
+	MaxMemoryMB int
+}
+
+// ErrIncompleteCommit is returned when the command of a CommitCheck fails for
+// a commit.
+type ErrIncompleteCommit struct {
+	Err    error
+	Commit Commit
+	// Output is the end of the command's combined stdout and stderr
+	Output   string
+	TimedOut bool
+}
+
+func (e *ErrIncompleteCommit) Error() string {
+	if e.TimedOut {
+		return fmt.Sprintf("command timed out for commit %s %s", e.Commit.SHA, e.Commit.Subject)
+	}
+	return fmt.Sprintf("command failed for commit %s %s: %v", e.Commit.SHA, e.Commit.Subject, e.Err)
+}
+
+func (r *repo) RunOnEachCommit(upstreamRef, branchRef string, check CommitCheck) error {
+	return r.withWorktree(branchRef, func(w *repo) error {
+		commits, err := w.commitMessages(upstreamRef + ".." + branchRef)
+		if err != nil {
+			return err
+		}
+		for _, commit := range commits {
+			log.Printf("Running the commit check for %s %s\n", commit.SHA, commit.Subject)
+			if err := w.git("checkout", "--detach", "--force", commit.SHA); err != nil {
+				return fmt.Errorf("failed to check out %s: %v", commit.SHA, err)
+			}
+			// Leftovers from checking the previous commit must not affect
+			// the result
+			if err := w.git("clean", "-ffdx"); err != nil {
+				return fmt.Errorf("failed to clean the worktree: %v", err)
+			}
+			if err := runCheckCommand(w.path, check, commit.Commit); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func runCheckCommand(dir string, check CommitCheck, commit Commit) error {
+	home, err := os.MkdirTemp("", "commit-check-home")
+	if err != nil {
+		return fmt.Errorf("failed to create a home directory for the command: %v", err)
+	}
+	defer os.RemoveAll(home)
+
+	ctx, cancel := context.WithTimeout(context.Background(), check.Timeout)
+	defer cancel()
+
+	script := check.Command
+	if check.MaxMemoryMB > 0 {
+		script = fmt.Sprintf("ulimit -v %d && %s", check.MaxMemoryMB*1024, script)
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", script)
+	cmd.Dir = dir
+	cmd.Env = checkCommandEnv(home)
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// The command runs in a process group of its own, so that the processes
+	// it starts could be killed together with it
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return killProcessGroup(cmd.Process)
+	}
+	// Don't wait forever for the processes that the command left behind and
+	// that still hold on to the output
+	cmd.WaitDelay = commandWaitDelay
+
+	err = cmd.Run()
+	if cmd.Process != nil {
+		// Don't leave anything that the command started in the background
+		// running
+		killProcessGroup(cmd.Process)
+	}
+	if err == nil {
+		return nil
+	}
+	timedOut := errors.Is(ctx.Err(), context.DeadlineExceeded)
+	return &ErrIncompleteCommit{
+		Err:      err,
+		Commit:   commit,
+		Output:   tail(output.String(), maxCommitCheckOutput),
+		TimedOut: timedOut,
+	}
+}
+
+// checkCommandEnv returns the environment of the check command. It only
+// includes the variables needed for finding and running programs, so that
+// the results don't depend on the bot's configuration.
+func checkCommandEnv(home string) []string {
+	env := []string{"HOME=" + home}
+	for _, name := range []string{"PATH", "LANG", "TMPDIR"} {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// killProcessGroup kills the process group that the process leads.
+func killProcessGroup(process *os.Process) error {
+	err := syscall.Kill(-process.Pid, syscall.SIGKILL)
+	if errors.Is(err, syscall.ESRCH) {
+		// Everything in the group has already exited
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+func tail(output string, maxLength int) string {
+	if len(output) <= maxLength {
+		return output
+	}
+	output = output[len(output)-maxLength:]
+	// Start from a full line
+	if i := strings.Index(output, "\n"); i >= 0 {
+		output = output[i+1:]
+	}
+	return output
+}
@@ -0,0 +1,179 @@
+package git_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/salemove/github-review-helper/git"
+)
+
+func TestRunOnEachCommit(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	testRepoGit("rm", foo.Name)
+	testRepoGit("commit", "-m", "Remove foo")
+	removingSHA := testRepoGit("rev-parse", "@")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	check := git.CommitCheck{
+		Command: "echo checking; test -f " + foo.Name,
+		Timeout: time.Minute,
+	}
+	err := repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName+"~", check)
+	checkError(t, err)
+
+	err = repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName, check)
+	var incompleteErr *git.ErrIncompleteCommit
+	if !errors.As(err, &incompleteErr) {
+		t.Fatalf("Expected an incomplete commit error, but got: %v", err)
+	}
+	if incompleteErr.Commit.SHA != removingSHA || incompleteErr.Commit.Subject != "Remove foo" {
+		t.Fatalf("Expected the check to fail for \"Remove foo\", but it failed for %v", incompleteErr.Commit)
+	}
+	if !strings.Contains(incompleteErr.Output, "checking") {
+		t.Fatalf("Expected the output of the command to be included, but got: %q", incompleteErr.Output)
+	}
+}
+
+func TestRunOnEachCommit_withTimeout(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	check := git.CommitCheck{
+		Command: "exec sleep 10",
+		Timeout: 100 * time.Millisecond,
+	}
+	err := repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName, check)
+	var incompleteErr *git.ErrIncompleteCommit
+	if !errors.As(err, &incompleteErr) || !incompleteErr.TimedOut {
+		t.Fatalf("Expected a timeout, but got: %v", err)
+	}
+}
+
+func TestRunOnEachCommit_withTimeoutKillsStartedProcesses(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	marker := filepath.Join(t.TempDir(), "marker")
+	check := git.CommitCheck{
+		Command: "(sleep 1; touch " + marker + ") & sleep 10",
+		Timeout: 100 * time.Millisecond,
+	}
+	err := repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName, check)
+	var incompleteErr *git.ErrIncompleteCommit
+	if !errors.As(err, &incompleteErr) || !incompleteErr.TimedOut {
+		t.Fatalf("Expected a timeout, but got: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Fatalf("Expected the background process to be killed, but it created %s", marker)
+	}
+}
+
+func TestRunOnEachCommit_doesNotPassTheEnvironment(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	t.Setenv("GITHUB_SECRET", "a-secret")
+	check := git.CommitCheck{
+		Command: `test -z "$GITHUB_SECRET" && test -n "$PATH"`,
+		Timeout: time.Minute,
+	}
+	err := repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName, check)
+	checkError(t, err)
+}
+
+func TestRunOnEachCommit_runsInTheBasePath(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+
+	reposDir, cleanup := createTempDir(t)
+	defer cleanup()
+	reposDir, err := filepath.EvalSymlinks(reposDir)
+	checkError(t, err)
+	repo, err := git.NewRepos(reposDir, git.Config{}).GetUpdatedRepo(testRepoDir, "my", "test-repo")
+	checkError(t, err)
+
+	check := git.CommitCheck{
+		Command: `case "$(pwd -P)" in "` + reposDir + `"/*) ;; *) pwd -P; exit 1 ;; esac`,
+		Timeout: time.Minute,
+	}
+	err = repo.RunOnEachCommit("origin/master", "origin/"+featureBranchName, check)
+	checkError(t, err)
+}
@@ -0,0 +1,59 @@
+package git
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// tokenEnvVar is the environment variable that the credential helper reads
+// the token from. Passing the token in the environment keeps it out of the
+// command line arguments and the repo's configuration.
+const tokenEnvVar = "REVIEW_HELPER_GIT_TOKEN"
+
+// credentialHelper answers git's requests for credentials with the token.
+// GitHub accepts any username with an installation token, but recommends
+// x-access-token.
+const credentialHelper = `!f() { test "$1" = get && echo username=x-access-token && echo "password=$` +
+	tokenEnvVar + `"; }; f`
+
+// gitCommand creates a git command with the given arguments that runs in dir,
+// or in the current directory if dir is empty. If the config has a token, the
+// command authenticates HTTPS requests with it.
+func gitCommand(config Config, dir string, args ...string) (command, error) {
+	cmd := command{name: "git", args: args, dir: dir}
+	if config.Token == nil {
+		return cmd, nil
+	}
+	token, err := config.Token()
+	if err != nil {
+		return command{}, fmt.Errorf("failed to get a token for git: %v", err)
+	}
+	// The empty helper resets the helpers that might be configured
+	// globally, so that only the token is used
+	cmd.args = append([]string{"-c", "credential.helper=", "-c", "credential.helper=" + credentialHelper},
+		args...)
+	cmd.env = []string{
+		tokenEnvVar + "=" + token,
+		// Failing is better than hanging on a prompt if the token is
+		// rejected
+		"GIT_TERMINAL_PROMPT=0",
+	}
+	return cmd, nil
+}
+
+var (
+	scpLikeURL = regexp.MustCompile(`^[^/@:]+@([^/:]+):(.+)$`)
+	sshURL     = regexp.MustCompile(`^ssh://(?:[^/@]+@)?([^/:]+)(?::\d+)?/(.+)$`)
+)
+
+// httpsURL converts SSH URLs like the ones in GitHub's payloads, e.g.
+// git@github.com:owner/repo.git, into HTTPS URLs. Other URLs are returned as
+// they are.
+func httpsURL(url string) string {
+	if match := scpLikeURL.FindStringSubmatch(url); match != nil {
+		return fmt.Sprintf("https://%s/%s", match[1], match[2])
+	} else if match := sshURL.FindStringSubmatch(url); match != nil {
+		return fmt.Sprintf("https://%s/%s", match[1], match[2])
+	}
+	return url
+}
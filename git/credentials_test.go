@@ -0,0 +1,66 @@
+package git_test
+
+import (
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/salemove/github-review-helper/git"
+)
+
+func TestSquash_withToken(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+	testRepoGit("config", "http.receivepack", "true")
+
+	token := "installation-token"
+	gitHTTPBackend := &cgi.Handler{
+		Path: filepath.Join(testRepoGit("--exec-path"), "git-http-backend"),
+		Env:  []string{"GIT_PROJECT_ROOT=" + filepath.Dir(testRepoDir), "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if username, password, ok := r.BasicAuth(); !ok || username != "x-access-token" || password != token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="git"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		gitHTTPBackend.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "--fixup=@")
+
+	testRepoGit("checkout", "master")
+
+	tokenRequests := 0
+	repo, cleanup := cloneTestRepoWithConfig(t, server.URL+"/"+filepath.Base(testRepoDir)+"/.git", git.Config{
+		Token: func() (string, error) {
+			tokenRequests++
+			return token, nil
+		},
+	})
+	defer cleanup()
+
+	squashedSHA, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName,
+		backupPrefix, false)
+	checkError(t, err)
+
+	if head := testRepoGit("rev-parse", featureBranchName); head != squashedSHA {
+		t.Fatalf("Expected %s to be pushed over HTTPS, but the branch points to %s", squashedSHA, head)
+	} else if tokenRequests == 0 {
+		t.Fatal("Expected the token to be requested")
+	}
+}
@@ -53,6 +53,10 @@ func TestDeleteRemoteBranch_noBranch(t *testing.T) {
 	if err == nil {
 		t.Fatal("Expected deletion of a non-existent branch to fail")
 	}
+	// The standard error of git explains why the command failed
+	if !strings.Contains(err.Error(), "remote ref does not exist") {
+		t.Fatalf("Expected the error to include git's explanation, got: %v", err)
+	}
 }
 
 func getBranches(git gitClient) []string {
@@ -1,91 +1,248 @@
 package git
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
+// ErrNoBackup is returned when there's no backup to restore.
+var ErrNoBackup = errors.New("no backups found")
+
 type Repos interface {
 	// GetUpdatedRepo either clones the specified repository if it hasn't been cloned yet or simply
 	// fetches the latest changes for it. Returns the Repo in any case.
 	GetUpdatedRepo(url, repoOwner, repoName string) (Repo, error)
+	// DeleteBackups deletes all the backups under backupPrefix from the repository at url without
+	// cloning or fetching it.
+	DeleteBackups(url, backupPrefix string) error
 }
 
 type Repo interface {
 	Fetch() error
-	// Runs `git rebase --interactive --autosquash` for the given refs and automatically saves and closes
-	// the editor for interactive rebase. Then force pushes the current HEAD to destinationRef on origin.
-	AutosquashAndPush(upstreamRef, branchRef, destinationRef string) error
+	// Runs `git rebase --interactive --autosquash --keep-base` for the given refs and automatically
+	// saves and closes the editor for interactive rebase. Unless the squash changed the contents of
+	// the branch, the squashed commits are then rebased onto upstreamRef and the current HEAD is
+	// force pushed to destinationRef on origin, unless the rebase didn't rewrite the commit messages
+	// as specified by the amend! commits. If addCoAuthors is true, the
+	// authors of the squashed commits are added as Co-authored-by trailers to the commits they were
+	// squashed into. If signing is configured, the rewritten commits are signed and the new HEAD
+	// isn't pushed unless its signature can be verified. The push fails with ErrBranchMoved if
+	// destinationRef on origin no longer points to branchRef. The original branchRef is backed up
+	// under backupPrefix in the same push. Nothing is pushed or backed up if squashing didn't change
+	// branchRef. Returns the SHA of the squashed HEAD.
+	AutosquashAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string, addCoAuthors bool) (string,
+		error)
+	// Rebases branchRef onto upstreamRef, also autosquashing the commits if autosquash is true. Then
+	// force pushes the current HEAD to destinationRef on origin. If signing is configured, the
+	// rewritten commits are signed and the new HEAD isn't pushed unless its signature can be
+	// verified. The push fails with ErrBranchMoved if destinationRef on origin no longer points to
+	// branchRef. The original branchRef is backed up under backupPrefix in the same push. Nothing
+	// is pushed or backed up if the rebase didn't change branchRef.
+	RebaseAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string, autosquash bool) error
+	// Force pushes the most recent backup under backupPrefix to destinationRef on origin and
+	// deletes the restored backup. The push fails with ErrBranchMoved if destinationRef on origin
+	// no longer points to the commit that the bot pushed when it created the backup. Returns the
+	// SHA of the restored commit or ErrNoBackup if there are no backups.
+	RestoreLatestBackup(backupPrefix, destinationRef string) (string, error)
+	// Runs `git range-diff` to compare the commits between upstreamRef and oldRef with the commits
+	// between upstreamRef and newRef.
+	RangeDiff(upstreamRef, oldRef, newRef string) (string, error)
+	// Runs the check's command on each commit between upstreamRef and branchRef in a separate
+	// worktree, oldest commit first. Returns ErrIncompleteCommit for the first commit the command
+	// fails for.
+	RunOnEachCommit(upstreamRef, branchRef string, check CommitCheck) error
 	DeleteRemoteBranch(remoteRef string) error
 }
 
+// BackupPrefix returns the prefix of the refs that the original heads of PR
+// prNumber are backed up to before force pushing to the PR.
+func BackupPrefix(prNumber int) string {
+	return fmt.Sprintf("refs/review-helper/backup/%d/", prNumber)
+}
+
+// Commit identifies a commit that took part in a rebase.
+type Commit struct {
+	SHA     string
+	Subject string
+}
+
 type ErrSquashConflict struct {
 	Err error
+	// Commit is the commit that failed to be applied, e.g. a fixup! commit
+	Commit Commit
+	// FixupTarget is the commit that Commit was being squashed into. It is
+	// empty if Commit was not being squashed into another commit.
+	FixupTarget Commit
+	// Files lists the paths that had conflicts when the rebase stopped
+	Files []string
 }
 
 func (e *ErrSquashConflict) Error() string {
-	return fmt.Sprintf("failed to rebase with autosquash: %v", e.Err)
+	if e.Commit.SHA == "" {
+		return fmt.Sprintf("failed to rebase with autosquash: %v", e.Err)
+	}
+	return fmt.Sprintf("failed to rebase with autosquash: could not apply %s %s: %v", e.Commit.SHA,
+		e.Commit.Subject, e.Err)
+}
+
+// ErrTreeMismatch is returned when squashing would have changed the contents
+// of the branch. The squashed HEAD is not pushed in that case.
+type ErrTreeMismatch struct {
+	ExpectedTree string
+	ActualTree   string
 }
 
+func (e *ErrTreeMismatch) Error() string {
+	return fmt.Sprintf("the squashed tree %s differs from the original tree %s", e.ActualTree,
+		e.ExpectedTree)
+}
+
+// ErrMessageMismatch is returned when squashing an amend! commit didn't
+// produce the commit message that the amend! commit specified. The squashed
+// HEAD is not pushed in that case.
+type ErrMessageMismatch struct {
+	// Commit is the amend! commit whose message was not applied
+	Commit          Commit
+	ExpectedMessage string
+}
+
+func (e *ErrMessageMismatch) Error() string {
+	return fmt.Sprintf("no squashed commit has the message specified by %s %s", e.Commit.SHA, e.Commit.Subject)
+}
+
+// ErrBranchMoved is returned when the remote branch has changed since the
+// bot started working on it. The push is rejected to avoid overwriting the
+// changes.
+type ErrBranchMoved struct {
+	Err         error
+	Branch      string
+	ExpectedSHA string
+}
+
+func (e *ErrBranchMoved) Error() string {
+	return fmt.Sprintf("remote branch %s no longer points to %s: %v", e.Branch, e.ExpectedSHA, e.Err)
+}
+
+type ErrRebaseConflict struct {
+	Err error
+	// Files lists the paths that had conflicts when the rebase stopped
+	Files []string
+}
+
+func (e *ErrRebaseConflict) Error() string {
+	return fmt.Sprintf("failed to rebase: %v", e.Err)
+}
+
+// Config configures how the repos create commits.
+type Config struct {
+	// SigningKey is used for signing the commits that the bot creates, like
+	// git's user.signingKey. The commits aren't signed if it's empty.
+	SigningKey string
+	// SigningFormat is the format of the signatures, like git's gpg.format.
+	// One of openpgp, x509 and ssh. Defaults to openpgp.
+	SigningFormat string
+	// Token returns the token that's used for cloning, fetching and pushing
+	// over HTTPS. SSH URLs are converted to HTTPS URLs if it's set. It's
+	// called before every git command, so it should cache the token until
+	// the token expires. SSH is used if it's nil.
+	Token func() (string, error)
+	// CommandTimeout limits the time every git command may run for. The
+	// commands aren't limited if it's zero.
+	CommandTimeout time.Duration
+}
+
+// repos only locks its map of repos, so that cloning or fetching one repo
+// doesn't block the others.
 type repos struct {
 	sync.Mutex
 	basePath string
+	config   Config
 	repos    map[string]*repo
 }
 
 // NewRepos creates a new Repos instance which will hold all its repos in the specified base path
-func NewRepos(basePath string) Repos {
+func NewRepos(basePath string, config Config) Repos {
 	return &repos{
 		basePath: basePath,
+		config:   config,
 		repos:    make(map[string]*repo),
 	}
 }
 
 func (g *repos) repo(path string) *repo {
+	g.Lock()
+	defer g.Unlock()
+
 	existingRepo, exists := g.repos[path]
 	if !exists {
-		newRepo := &repo{path: path}
+		newRepo := &repo{
+			path:          path,
+			config:        g.config,
+			worktreesPath: filepath.Join(g.basePath, ".worktrees"),
+		}
 		g.repos[path] = newRepo
 		return newRepo
 	}
 	return existingRepo
 }
 
-func (g *repos) clone(url, localPath string) (Repo, error) {
-	if err := runWithLogging("git", "clone", url, localPath); err != nil {
-		return nil, fmt.Errorf("failed to clone: %v", err)
+// clone creates a bare clone of the repo at url. Every operation that needs
+// a working tree gets a worktree of its own, so that operations on the same
+// repo can run in parallel.
+func (r *repo) clone(url string) error {
+	if r.config.Token != nil {
+		url = httpsURL(url)
 	}
-	newRepo := g.repo(localPath)
-	if err := newRepo.configureNameEmail(); err != nil {
-		return nil, fmt.Errorf("failed to configure name and email: %v", err)
+	cmd, err := gitCommand(r.config, "", "clone", "--bare", url, r.path)
+	if err != nil {
+		return err
+	}
+	if _, err := r.run(cmd); err != nil {
+		return fmt.Errorf("failed to clone: %v", err)
+	}
+	// Bare clones don't have remote-tracking branches by default, but the
+	// operations refer to the upstream branches as origin/<branch>
+	if err := r.git("config", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		return fmt.Errorf("failed to configure fetching: %v", err)
 	}
-	return newRepo, nil
+	if err := r.configureNameEmail(); err != nil {
+		return fmt.Errorf("failed to configure name and email: %v", err)
+	}
+	if err := r.configureSigning(); err != nil {
+		return fmt.Errorf("failed to configure signing: %v", err)
+	}
+	return r.fetch()
 }
 
 func (g *repos) GetUpdatedRepo(url, repoOwner, repoName string) (Repo, error) {
-	g.Lock()
-	defer g.Unlock()
-
 	localPath := filepath.Join(g.basePath, repoOwner, repoName)
+	repo := g.repo(localPath)
+	repo.Lock()
+	defer repo.Unlock()
+
 	exists, err := exists(localPath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to check if the repo exists locally: %v", err)
 	}
 	if !exists {
 		log.Printf("Cloning %s into %s\n", url, localPath)
-		return g.clone(url, localPath)
+		if err := repo.clone(url); err != nil {
+			return nil, err
+		}
+		return repo, nil
 	}
 
 	log.Printf("Fetching latest changes for %s\n", url)
-	repo := g.repo(localPath)
-	err = repo.Fetch()
-	return repo, err
+	return repo, repo.fetch()
 }
 
 func exists(path string) (bool, error) {
@@ -98,25 +255,243 @@ func exists(path string) (bool, error) {
 	return true, nil
 }
 
+// repo is either a bare clone or one of its worktrees. The lock of a bare
+// clone is held while the operations that use the clone directly run, e.g.
+// fetching and adding or removing worktrees.
 type repo struct {
 	sync.Mutex
-	path string
+	path   string
+	config Config
+	// worktreesPath is the directory that the worktrees of a bare clone are
+	// added in. It's empty for worktrees.
+	worktreesPath string
+}
+
+func (r *repo) AutosquashAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string,
+	addCoAuthors bool) (string, error) {
+
+	var squashedSHA string
+	err := r.withWorktree(branchRef, func(w *repo) error {
+		var err error
+		squashedSHA, err = w.autosquashAndPush(upstreamRef, branchRef, destinationRef, backupPrefix, addCoAuthors)
+		return err
+	})
+	return squashedSHA, err
 }
 
-func (r *repo) AutosquashAndPush(upstreamRef, branchRef, destinationRef string) error {
+func (r *repo) autosquashAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string,
+	addCoAuthors bool) (string, error) {
+
+	expectedSHA, err := r.resolveCommit(branchRef)
+	if err != nil {
+		return "", err
+	}
+	// Other operations might fetch while this one is running, so the
+	// upstream is resolved only once
+	upstreamRef, err = r.resolveCommit(upstreamRef)
+	if err != nil {
+		return "", err
+	}
+	if err := r.rebaseAutosquash(upstreamRef, expectedSHA); err != nil {
+		return "", err
+	}
+	if addCoAuthors {
+		if err := r.addCoAuthors(upstreamRef, expectedSHA); err != nil {
+			return "", err
+		}
+	}
+	// The squash keeps the commits on their current base, so that its
+	// result can be compared with the original tree. Only then are the
+	// squashed commits moved onto the latest upstream.
+	if err := r.checkTreeUnchanged(expectedSHA); err != nil {
+		return "", err
+	}
+	if err := r.rebaseOntoUpstream(upstreamRef); err != nil {
+		return "", err
+	}
+	// The remaining checks run on the final HEAD, so that the commits that
+	// get pushed are the ones that were verified
+	if err := r.checkAmendedMessages(upstreamRef, expectedSHA); err != nil {
+		return "", err
+	}
+	squashedSHA, err := r.resolveCommit("@")
+	if err != nil {
+		return "", err
+	}
+	if err := r.verifyHeadSignature(expectedSHA); err != nil {
+		return "", err
+	}
+	if err := r.forcePushHeadTo(destinationRef, expectedSHA, backupPrefix); err != nil {
+		return "", err
+	}
+	return squashedSHA, nil
+}
+
+func (r *repo) RebaseAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string,
+	autosquash bool) error {
+
+	return r.withWorktree(branchRef, func(w *repo) error {
+		return w.rebaseAndPush(upstreamRef, branchRef, destinationRef, backupPrefix, autosquash)
+	})
+}
+
+func (r *repo) rebaseAndPush(upstreamRef, branchRef, destinationRef, backupPrefix string,
+	autosquash bool) error {
+
+	expectedSHA, err := r.resolveCommit(branchRef)
+	if err != nil {
+		return err
+	}
+	args := []string{upstreamRef, expectedSHA}
+	if autosquash {
+		args = append([]string{"--autosquash"}, args...)
+	}
+	if state, err := r.rebase(autosquash, args...); err != nil {
+		return &ErrRebaseConflict{err, state.Files}
+	}
+	if err := r.verifyHeadSignature(expectedSHA); err != nil {
+		return err
+	}
+	return r.forcePushHeadTo(destinationRef, expectedSHA, backupPrefix)
+}
+
+func (r *repo) RestoreLatestBackup(backupPrefix, destinationRef string) (string, error) {
 	r.Lock()
 	defer r.Unlock()
 
-	if err := r.rebaseAutosquash(upstreamRef, branchRef); err != nil {
+	backups, err := r.listBackups(backupPrefix)
+	if err != nil {
+		return "", err
+	}
+	restorable := []backup{}
+	for _, backup := range backups {
+		if backup.pushedSHA != "" {
+			restorable = append(restorable, backup)
+		}
+	}
+	if len(restorable) == 0 {
+		return "", ErrNoBackup
+	}
+	latest := restorable[len(restorable)-1]
+	// The backed up commit has to be available locally for pushing it
+	if err := r.git("fetch", "origin", latest.ref); err != nil {
+		return "", fmt.Errorf("failed to fetch the backup %s: %v", latest.ref, err)
+	}
+	// Leasing against the commit that the bot pushed makes sure that the
+	// commits pushed to the branch after it aren't overwritten
+	err = r.forcePush(destinationRef, latest.pushedSHA, latest.sha+":"+destinationRef, ":"+latest.ref)
+	if err != nil {
+		return "", err
+	}
+	return latest.sha, nil
+}
+
+func (g *repos) DeleteBackups(url, backupPrefix string) error {
+	if g.config.Token != nil {
+		url = httpsURL(url)
+	}
+	// Listing and deleting remote refs doesn't need any objects, so an empty
+	// repository with the remote is enough
+	if err := os.MkdirAll(g.basePath, 0755); err != nil {
+		return fmt.Errorf("failed to create the base path: %v", err)
+	}
+	scratchPath, err := os.MkdirTemp(g.basePath, ".scratch-")
+	if err != nil {
+		return fmt.Errorf("failed to create a scratch repository: %v", err)
+	}
+	defer os.RemoveAll(scratchPath)
+	scratch := &repo{path: scratchPath, config: g.config}
+	if err := scratch.git("init", "--bare"); err != nil {
+		return fmt.Errorf("failed to create a scratch repository: %v", err)
+	}
+	if err := scratch.git("remote", "add", "origin", url); err != nil {
+		return fmt.Errorf("failed to configure the remote: %v", err)
+	}
+	return scratch.deleteBackups(backupPrefix)
+}
+
+func (r *repo) deleteBackups(backupPrefix string) error {
+	backups, err := r.listBackups(backupPrefix)
+	if err != nil {
 		return err
+	} else if len(backups) == 0 {
+		return nil
+	}
+	args := []string{"push", "origin"}
+	for _, backup := range backups {
+		args = append(args, ":"+backup.ref)
+	}
+	if err := r.git(args...); err != nil {
+		return fmt.Errorf("failed to delete the backups under %s: %v", backupPrefix, err)
+	}
+	return nil
+}
+
+func (r *repo) RangeDiff(upstreamRef, oldRef, newRef string) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	out, err := r.gitOutput("range-diff", "--no-color", upstreamRef, oldRef, newRef)
+	if err != nil {
+		return "", fmt.Errorf("failed to compute the range-diff of %s and %s: %v", oldRef, newRef, err)
+	}
+	return out, nil
+}
+
+type backup struct {
+	ref string
+	sha string
+	// pushedSHA is the commit that the bot pushed in place of the backed up
+	// one. It's empty for backups that don't record it.
+	pushedSHA string
+	timestamp int64
+}
+
+// backupRef returns the ref that sha is backed up to when the bot pushes
+// pushedSHA in its place.
+func backupRef(backupPrefix, pushedSHA string) string {
+	return fmt.Sprintf("%s%d-%s", backupPrefix, time.Now().UnixNano(), pushedSHA)
+}
+
+// listBackups lists the backups under backupPrefix on origin, oldest first.
+func (r *repo) listBackups(backupPrefix string) ([]backup, error) {
+	out, err := r.gitOutput("ls-remote", "origin", backupPrefix+"*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the backups under %s: %v", backupPrefix, err)
 	}
-	return r.forcePushHeadTo(destinationRef)
+	backups := []backup{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || !strings.HasPrefix(fields[1], backupPrefix) {
+			continue
+		}
+		name, pushedSHA, _ := strings.Cut(strings.TrimPrefix(fields[1], backupPrefix), "-")
+		timestamp, err := strconv.ParseInt(name, 10, 64)
+		if err != nil {
+			log.Printf("Ignoring unexpected backup ref %s\n", fields[1])
+			continue
+		}
+		backups = append(backups, backup{
+			ref:       fields[1],
+			sha:       fields[0],
+			pushedSHA: pushedSHA,
+			timestamp: timestamp,
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].timestamp < backups[j].timestamp
+	})
+	return backups, nil
 }
 
 func (r *repo) Fetch() error {
 	r.Lock()
 	defer r.Unlock()
 
+	return r.fetch()
+}
+
+func (r *repo) fetch() error {
 	if err := r.git("fetch"); err != nil {
 		return fmt.Errorf("failed to fetch: %v", err)
 	}
@@ -124,77 +499,385 @@ func (r *repo) Fetch() error {
 }
 
 func (r *repo) rebaseAutosquash(upstreamRef, branchRef string) error {
-	// This makes the --interactive rebase not actually interactive
-	if err := os.Setenv("GIT_SEQUENCE_EDITOR", "true"); err != nil {
-		return fmt.Errorf("failed to change the env variable: %v", err)
+	// Keeping the base makes sure that squashing doesn't change the contents
+	// of the branch, which is verified before pushing.
+	if state, err := r.rebase(true, "--autosquash", "--keep-base", upstreamRef, branchRef); err != nil {
+		return &ErrSquashConflict{
+			Err:         err,
+			Commit:      state.Commit,
+			FixupTarget: state.FixupTarget,
+			Files:       state.Files,
+		}
 	}
-	defer os.Unsetenv("GIT_SEQUENCE_EDITOR")
+	return nil
+}
 
-	if err := r.git("rebase", "--interactive", "--autosquash", upstreamRef, branchRef); err != nil {
-		err = &ErrSquashConflict{err}
-		log.Println(err, " Trying to clean up.")
+// rebaseOntoUpstream rebases the current HEAD onto upstreamRef. It's a no-op
+// if HEAD is already based on upstreamRef.
+func (r *repo) rebaseOntoUpstream(upstreamRef string) error {
+	if state, err := r.rebase(false, upstreamRef); err != nil {
+		return &ErrSquashConflict{Err: err, Commit: state.Commit, Files: state.Files}
+	}
+	return nil
+}
+
+// rebaseState describes where a failed rebase stopped.
+type rebaseState struct {
+	Commit      Commit
+	FixupTarget Commit
+	Files       []string
+}
+
+// rebase runs `git rebase` with the given arguments. If the rebase fails,
+// then the state of the rebase is returned with the error and the rebase is
+// aborted.
+func (r *repo) rebase(interactive bool, args ...string) (rebaseState, error) {
+	args = append([]string{"rebase"}, args...)
+	var env []string
+	if interactive {
+		// This makes the --interactive rebase not actually interactive
+		env = []string{"GIT_SEQUENCE_EDITOR=true"}
+		args = append([]string{"rebase", "--interactive"}, args[1:]...)
+	}
+
+	if _, err := r.gitWithEnv(env, args...); err != nil {
+		log.Println("Rebase failed: ", err, " Trying to clean up.")
+		state := r.failedRebaseState()
 		if cleanupErr := r.git("rebase", "--abort"); cleanupErr != nil {
 			log.Println("Also failed to clean up after the failed rebase: ", cleanupErr)
 		}
-		return err
+		return state, err
 	}
-	return nil
+	return rebaseState{}, nil
 }
 
-func (r *repo) forcePushHeadTo(destinationRef string) error {
-	if err := r.git("push", "--force", "origin", "@:"+destinationRef); err != nil {
-		return fmt.Errorf("failed to force push to remote: %v", err)
+// checkTreeUnchanged verifies that the tree of the current HEAD is the same
+// as the tree of originalRef.
+func (r *repo) checkTreeUnchanged(originalRef string) error {
+	expectedTree, err := r.gitOutput("rev-parse", originalRef+"^{tree}")
+	if err != nil {
+		return fmt.Errorf("failed to get the tree of %s: %v", originalRef, err)
+	}
+	actualTree, err := r.gitOutput("rev-parse", "@^{tree}")
+	if err != nil {
+		return fmt.Errorf("failed to get the tree of HEAD: %v", err)
+	}
+	if actualTree != expectedTree {
+		return &ErrTreeMismatch{ExpectedTree: expectedTree, ActualTree: actualTree}
 	}
 	return nil
 }
 
-func (r *repo) configureNameEmail() error {
-	if err := r.git("config", "user.name", "github-review-helper"); err != nil {
+// checkAmendedMessages verifies that the messages specified by the amend!
+// commits between upstreamRef and originalRef ended up on the commits between
+// upstreamRef and the current HEAD. The Co-authored-by trailers that
+// addCoAuthors adds after the messages are allowed.
+func (r *repo) checkAmendedMessages(upstreamRef, originalRef string) error {
+	originalCommits, err := r.commitMessages(upstreamRef + ".." + originalRef)
+	if err != nil {
 		return err
 	}
-	return r.git("config", "user.email", "<>")
+	amends := amendCommits(originalCommits)
+	if len(amends) == 0 {
+		return nil
+	}
+	squashedCommits, err := r.commitMessages(upstreamRef + "..@")
+	if err != nil {
+		return err
+	}
+	for _, amend := range amends {
+		expectedMessage := amendedMessage(amend.message)
+		if expectedMessage == "" {
+			// Git keeps the original message if the amend! commit has no
+			// message of its own
+			continue
+		}
+		found := false
+		for _, squashed := range squashedCommits {
+			if hasAmendedMessage(squashed.message, expectedMessage) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return &ErrMessageMismatch{Commit: amend.Commit, ExpectedMessage: expectedMessage}
+		}
+	}
+	return nil
 }
 
-func (r *repo) git(args ...string) error {
-	allArgs := append([]string{"-C", r.path}, args...)
-	return runWithLogging("git", allArgs...)
+type commitMessage struct {
+	Commit
+	// author is formatted like "Name <email>"
+	author  string
+	message string
 }
 
-func (r *repo) DeleteRemoteBranch(remoteRef string) error {
-	r.Lock()
-	defer r.Unlock()
+// commitMessages lists the commits in the revision range, oldest first.
+// Merge commits are left out, like rebase leaves them out.
+func (r *repo) commitMessages(revisionRange string) ([]commitMessage, error) {
+	// The commits are separated with record separators and the fields with
+	// unit separators, neither of which appear in messages
+	out, err := r.gitOutput("log", "--reverse", "--no-merges", "--format=%H%x1f%an <%ae>%x1f%B%x1e",
+		revisionRange)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the commits in %s: %v", revisionRange, err)
+	}
+	commits := []commitMessage{}
+	for _, record := range strings.Split(out, "\x1e") {
+		fields := strings.SplitN(strings.TrimSpace(record), "\x1f", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		subject := strings.SplitN(fields[2], "\n", 2)[0]
+		commits = append(commits, commitMessage{Commit{SHA: fields[0], Subject: subject}, fields[1], fields[2]})
+	}
+	return commits, nil
+}
 
-	if err := runWithLogging("git", "-C", r.path, "push", "origin", "--delete", remoteRef); err != nil {
-		return fmt.Errorf("failed to remove remote branch %s: %v", remoteRef, err)
+// amendCommits returns the amend! commits whose messages autosquash applies.
+// When several amend! commits target the same commit, only the last one's
+// message is kept.
+func amendCommits(commits []commitMessage) []commitMessage {
+	lastAmendByTarget := map[string]int{}
+	targets := []string{}
+	for i, commit := range commits {
+		if !strings.HasPrefix(commit.Subject, "amend! ") {
+			continue
+		}
+		target := commit.Subject
+		for strings.HasPrefix(target, "amend! ") {
+			target = strings.TrimPrefix(target, "amend! ")
+		}
+		if _, seen := lastAmendByTarget[target]; !seen {
+			targets = append(targets, target)
+		}
+		lastAmendByTarget[target] = i
 	}
-	return nil
+	amends := []commitMessage{}
+	for _, target := range targets {
+		amends = append(amends, commits[lastAmendByTarget[target]])
+	}
+	return amends
 }
 
-func runWithLogging(name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	stdout, err := cmd.StdoutPipe()
+// hasAmendedMessage reports whether message is expectedMessage, possibly
+// followed by Co-authored-by trailers.
+func hasAmendedMessage(message, expectedMessage string) bool {
+	rest, found := strings.CutPrefix(strings.TrimSpace(message), expectedMessage)
+	if !found {
+		return false
+	} else if rest == "" {
+		return true
+	} else if !strings.HasPrefix(rest, "\n") {
+		return false
+	}
+	for _, line := range strings.Split(strings.TrimSpace(rest), "\n") {
+		if !strings.HasPrefix(line, "Co-authored-by: ") {
+			return false
+		}
+	}
+	return true
+}
+
+// amendedMessage returns the message that an amend! commit replaces its
+// target's message with, i.e. the message without the amend! subject line.
+func amendedMessage(amendMessage string) string {
+	parts := strings.SplitN(amendMessage, "\n", 2)
+	if len(parts) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+// failedRebaseState collects the details of a stopped rebase. The details
+// are only used for reporting, so failures are logged and the details that
+// could be collected are returned.
+func (r *repo) failedRebaseState() rebaseState {
+	var state rebaseState
+	if out, err := r.gitOutput("status", "--porcelain"); err != nil {
+		log.Println("Failed to list the conflicting files: ", err)
+	} else {
+		state.Files = parseConflictingFiles(out)
+	}
+	// The todo list of an interactive rebase is moved line by line to the
+	// done file as the rebase progresses. The commit that failed to be
+	// applied is the last line of the file.
+	donePath, err := r.gitOutput("rev-parse", "--git-path", "rebase-merge/done")
 	if err != nil {
-		return err
+		log.Println("Failed to find the rebase's done file: ", err)
+		return state
+	}
+	if !filepath.IsAbs(donePath) {
+		donePath = filepath.Join(r.path, donePath)
+	}
+	done, err := os.ReadFile(donePath)
+	if err != nil {
+		// Non-interactive rebases might not use the done file
+		log.Println("Failed to read the rebase's done file: ", err)
+		return state
+	}
+	state.Commit, state.FixupTarget = parseDoneCommands(string(done))
+	return state
+}
+
+// parseConflictingFiles parses the unmerged paths from the output of `git
+// status --porcelain`.
+func parseConflictingFiles(statusOutput string) []string {
+	files := []string{}
+	for _, line := range strings.Split(statusOutput, "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		switch line[:2] {
+		case "DD", "AU", "UD", "UA", "DU", "AA", "UU":
+			files = append(files, line[3:])
+		}
+	}
+	return files
+}
+
+// parseDoneCommands returns the last commit in the done list of a rebase and,
+// if the commit is a fixup or a squash, the commit it was squashed into.
+func parseDoneCommands(done string) (Commit, Commit) {
+	type command struct {
+		name   string
+		commit Commit
+	}
+	commands := []command{}
+	for _, line := range strings.Split(done, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		name, fields := fields[0], fields[1:]
+		// Skip options like the -C in "fixup -C <commit>"
+		if strings.HasPrefix(fields[0], "-") && len(fields) > 1 {
+			fields = fields[1:]
+		}
+		commands = append(commands, command{name, Commit{
+			SHA:     fields[0],
+			Subject: strings.Join(fields[1:], " "),
+		}})
+	}
+	if len(commands) == 0 {
+		return Commit{}, Commit{}
+	}
+	last := commands[len(commands)-1]
+	if !isSquashingCommand(last.name) {
+		return last.commit, Commit{}
+	}
+	for i := len(commands) - 2; i >= 0; i-- {
+		if !isSquashingCommand(commands[i].name) {
+			return last.commit, commands[i].commit
+		}
+	}
+	return last.commit, Commit{}
+}
+
+func isSquashingCommand(name string) bool {
+	switch name {
+	case "fixup", "f", "squash", "s":
+		return true
+	}
+	return false
+}
+
+// resolveCommit returns the SHA of the commit that ref points to.
+func (r *repo) resolveCommit(ref string) (string, error) {
+	sha, err := r.gitOutput("rev-parse", "--verify", ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %v", ref, err)
 	}
-	stderr, err := cmd.StderrPipe()
+	return sha, nil
+}
+
+// forcePushHeadTo force pushes the current HEAD to destinationRef on origin,
+// but only if destinationRef on origin still points to expectedSHA. The
+// expected commit is backed up under backupPrefix in the same push, so that
+// it's only backed up if the branch is actually overwritten. The backup's ref
+// records the pushed HEAD, so that restoring the backup can make sure that
+// nothing has been pushed on top of it. Nothing is pushed if the current HEAD
+// is expectedSHA, because a backup of it couldn't be told apart from the
+// pushed HEAD.
+func (r *repo) forcePushHeadTo(destinationRef, expectedSHA, backupPrefix string) error {
+	headSHA, err := r.resolveCommit("@")
 	if err != nil {
 		return err
+	} else if headSHA == expectedSHA {
+		return nil
+	}
+	backupRef := backupRef(backupPrefix, headSHA)
+	return r.forcePush(destinationRef, expectedSHA, "@:"+destinationRef, expectedSHA+":"+backupRef)
+}
+
+// forcePush atomically pushes the refspecs to origin, force pushing to
+// destinationRef only if it still points to expectedSHA on origin.
+func (r *repo) forcePush(destinationRef, expectedSHA string, refspecs ...string) error {
+	lease := fmt.Sprintf("--force-with-lease=%s:%s", destinationRef, expectedSHA)
+	args := append([]string{"push", "--atomic", lease, "origin"}, refspecs...)
+	if err := r.git(args...); err != nil {
+		// Git reports a failed lease as a "stale info" rejection
+		var cmdErr *ErrCommand
+		if errors.As(err, &cmdErr) && strings.Contains(cmdErr.Stderr, "stale info") {
+			return &ErrBranchMoved{Err: err, Branch: destinationRef, ExpectedSHA: expectedSHA}
+		}
+		return fmt.Errorf("failed to force push to remote: %v", err)
 	}
+	return nil
+}
 
-	if err := cmd.Start(); err != nil {
+func (r *repo) configureNameEmail() error {
+	if err := r.git("config", "user.name", "github-review-helper"); err != nil {
 		return err
 	}
+	return r.git("config", "user.email", "<>")
+}
+
+func (r *repo) git(args ...string) error {
+	_, err := r.gitWithEnv(nil, args...)
+	return err
+}
+
+// gitWithEnv runs a git command in the repo with the environment variables
+// added to the command's environment. The output of the command is logged
+// and its standard output is returned.
+func (r *repo) gitWithEnv(env []string, args ...string) (string, error) {
+	cmd, err := gitCommand(r.config, r.path, args...)
+	if err != nil {
+		return "", err
+	}
+	cmd.env = append(cmd.env, env...)
+	return r.run(cmd)
+}
 
-	scanner := bufio.NewScanner(io.MultiReader(stdout, stderr))
-	for scanner.Scan() {
-		log.Printf("%s: %s\n", name, scanner.Text())
+// gitOutput runs a git command in the repo and returns its standard output
+// instead of logging it.
+func (r *repo) gitOutput(args ...string) (string, error) {
+	cmd, err := gitCommand(r.config, r.path, args...)
+	if err != nil {
+		return "", err
 	}
-	if err := scanner.Err(); err != nil {
-		log.Printf("error reading %s's stdout/stderr: %s\n", name, err)
+	cmd.quiet = true
+	out, err := r.run(cmd)
+	return strings.TrimSpace(out), err
+}
+
+// run runs the command, cancelling it if it takes longer than the configured
+// timeout.
+func (r *repo) run(cmd command) (string, error) {
+	ctx := context.Background()
+	if r.config.CommandTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.config.CommandTimeout)
+		defer cancel()
 	}
+	return runCommand(ctx, cmd)
+}
 
-	if err := cmd.Wait(); err != nil {
-		return err
+func (r *repo) DeleteRemoteBranch(remoteRef string) error {
+	if err := r.git("push", "origin", "--delete", remoteRef); err != nil {
+		return fmt.Errorf("failed to remove remote branch %s: %v", remoteRef, err)
 	}
 	return nil
 }
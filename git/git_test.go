@@ -32,12 +32,20 @@ var (
 	}
 )
 
+// backupPrefix is the prefix the tests back up the original feature branch
+// under before force pushing.
+var backupPrefix = git.BackupPrefix(1)
+
 type gitClient func(...string) string
 
 func cloneTestRepo(t *testing.T, testRepoDir string) (git.Repo, func()) {
+	return cloneTestRepoWithConfig(t, testRepoDir, git.Config{})
+}
+
+func cloneTestRepoWithConfig(t *testing.T, testRepoDir string, config git.Config) (git.Repo, func()) {
 	reposDir, cleanup := createTempDir(t)
 
-	gitRepos := git.NewRepos(reposDir)
+	gitRepos := git.NewRepos(reposDir, config)
 	repo, err := gitRepos.GetUpdatedRepo(testRepoDir, "my", "test-repo")
 	checkError(t, err)
 
@@ -0,0 +1,51 @@
+package git_test
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRangeDiff(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "foo\nbar\nbaz\nqux\n"})
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "foo\nbar\nbaz\nquux\n"})
+	testRepoGit("commit", "-a", "--fixup=@")
+	originalSHA := testRepoGit("rev-parse", featureBranchName)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	squashedSHA, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName,
+		backupPrefix, false)
+	checkError(t, err)
+
+	if featureSHA := testRepoGit("rev-parse", featureBranchName); squashedSHA != featureSHA {
+		t.Fatalf("Expected the squashed HEAD %s to be pushed, but the feature branch points to %s", squashedSHA,
+			featureSHA)
+	}
+
+	rangeDiff, err := repo.RangeDiff("origin/master", originalSHA, squashedSHA)
+	checkError(t, err)
+
+	// The original commit was changed by squashing the fixup into it and the
+	// fixup commit itself was removed
+	lines := strings.Split(rangeDiff, "\n")
+	if !strings.Contains(lines[0], " ! 1:") || !strings.HasSuffix(lines[0], "Add foo") {
+		t.Fatalf("Expected the first commit to be reported as changed, but got:\n%s", rangeDiff)
+	}
+	if !strings.Contains(rangeDiff, " < -:  ------- fixup! Add foo") {
+		t.Fatalf("Expected the fixup commit to be reported as removed, but got:\n%s", rangeDiff)
+	}
+}
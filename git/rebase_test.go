@@ -0,0 +1,165 @@
+package git_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/salemove/github-review-helper/git"
+)
+
+func TestRebase(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+	masterSHA := testRepoGit("rev-parse", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	err := repo.RebaseAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	checkError(t, err)
+
+	// Check that the feature branch now includes the latest master
+	testRepoGit("checkout", featureBranchName)
+
+	checkFile(t, testRepoDir, readme)
+	checkFile(t, testRepoDir, foo)
+	checkFile(t, testRepoDir, bar)
+
+	parentSHA := testRepoGit("rev-parse", "@^")
+	if parentSHA != masterSHA {
+		t.Fatalf("Expected the feature branch to be based on %s, but it's based on %s", masterSHA, parentSHA)
+	}
+}
+
+func TestRebase_withAutosquash(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	commitToFixMessage := "Add foo"
+	testRepoGit("commit", "-m", commitToFixMessage)
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "fixed foo\n"})
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "--fixup=@")
+
+	testRepoGit("checkout", "master")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	err := repo.RebaseAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, true)
+	checkError(t, err)
+
+	testRepoGit("checkout", featureBranchName)
+
+	checkFile(t, testRepoDir, bar)
+	checkFile(t, testRepoDir, file{Name: foo.Name, Contents: "fixed foo\n"})
+
+	headCommitMessage := testRepoGit("show", "-s", "--format=%B", "@")
+	if headCommitMessage != commitToFixMessage {
+		t.Fatalf(
+			"Expected HEAD commit to have message \"%s\", but got \"%s\"",
+			commitToFixMessage,
+			headCommitMessage,
+		)
+	}
+}
+
+func TestRebase_withConflict(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "feature foo\n"})
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	featureSHA := testRepoGit("rev-parse", featureBranchName)
+
+	testRepoGit("checkout", "master")
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "master foo\n"})
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add a different foo")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	err := repo.RebaseAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	var conflictErr *git.ErrRebaseConflict
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected a rebase conflict error, but got: %v", err)
+	}
+	if len(conflictErr.Files) != 1 || conflictErr.Files[0] != foo.Name {
+		t.Fatalf("Expected %s to be the only conflicting file, but got: %v", foo.Name, conflictErr.Files)
+	}
+
+	if newFeatureSHA := testRepoGit("rev-parse", featureBranchName); newFeatureSHA != featureSHA {
+		t.Fatal("Expected the feature branch not to be changed")
+	}
+}
+
+func TestRebase_withMovedBranch(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	testRepoGit("checkout", "master")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	// Update the feature branch after the bot has fetched it
+	testRepoGit("checkout", featureBranchName)
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "updated foo\n"})
+	testRepoGit("commit", "-am", "Update foo")
+	featureSHA := testRepoGit("rev-parse", featureBranchName)
+	testRepoGit("checkout", "master")
+
+	err := repo.RebaseAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	var movedErr *git.ErrBranchMoved
+	if !errors.As(err, &movedErr) {
+		t.Fatalf("Expected a branch moved error, but got: %v", err)
+	}
+
+	if newFeatureSHA := testRepoGit("rev-parse", featureBranchName); newFeatureSHA != featureSHA {
+		t.Fatal("Expected the feature branch not to be changed")
+	}
+}
@@ -0,0 +1,105 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// allowedSignersFile is the file in the bare clone that lists the bot's own
+// SSH key for verifying the signatures it creates.
+const allowedSignersFile = "review-helper-allowed-signers"
+
+// configureSigning makes git sign all the commits it creates in the repo with
+// the configured key.
+func (r *repo) configureSigning() error {
+	if r.config.SigningKey == "" {
+		return nil
+	}
+	format := r.config.SigningFormat
+	if format == "" {
+		format = "openpgp"
+	}
+	settings := [][]string{
+		{"user.signingKey", r.config.SigningKey},
+		{"gpg.format", format},
+		{"commit.gpgSign", "true"},
+	}
+	if format == "ssh" {
+		// Unlike GPG, SSH has no keyring, so git needs to be told which keys
+		// to trust when verifying signatures
+		path, err := r.writeAllowedSigners()
+		if err != nil {
+			return err
+		}
+		settings = append(settings, []string{"gpg.ssh.allowedSignersFile", path})
+	}
+	for _, setting := range settings {
+		if err := r.git("config", setting[0], setting[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeAllowedSigners writes the public key of the SSH signing key into an
+// allowed signers file and returns the path of the file.
+func (r *repo) writeAllowedSigners() (string, error) {
+	publicKey, err := sshPublicKey(r.config.SigningKey)
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(r.path, allowedSignersFile)
+	if err := os.WriteFile(path, []byte("* "+publicKey+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to write the allowed signers file: %v", err)
+	}
+	return path, nil
+}
+
+// sshPublicKey returns the public key of the signing key, which can be given
+// like git accepts it: either as a literal key prefixed with "key::" or as a
+// path to a private or a public key file.
+func sshPublicKey(signingKey string) (string, error) {
+	if strings.HasPrefix(signingKey, "key::") {
+		return strings.TrimPrefix(signingKey, "key::"), nil
+	} else if strings.HasPrefix(signingKey, "ssh-") {
+		// Git also accepts literal keys without the prefix
+		return signingKey, nil
+	} else if strings.HasSuffix(signingKey, ".pub") {
+		publicKey, err := os.ReadFile(signingKey)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the public key: %v", err)
+		}
+		return strings.TrimSpace(string(publicKey)), nil
+	}
+	out, err := runCommand(context.Background(), command{
+		name:  "ssh-keygen",
+		args:  []string{"-y", "-f", signingKey},
+		quiet: true,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get the public key of %s: %v", signingKey, err)
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// verifyHeadSignature verifies the signature of the current HEAD if signing
+// is configured and the HEAD was created by the bot, i.e. it isn't the
+// original commit anymore.
+func (r *repo) verifyHeadSignature(originalSHA string) error {
+	if r.config.SigningKey == "" {
+		return nil
+	}
+	head, err := r.resolveCommit("@")
+	if err != nil {
+		return err
+	} else if head == originalSHA {
+		return nil
+	}
+	if err := r.git("verify-commit", head); err != nil {
+		return fmt.Errorf("failed to verify the signature of %s: %v", head, err)
+	}
+	return nil
+}
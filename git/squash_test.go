@@ -1,6 +1,14 @@
 package git_test
 
-import "testing"
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/salemove/github-review-helper/git"
+)
 
 func TestSquash(t *testing.T) {
 	skipWithoutGit(t)
@@ -28,7 +36,7 @@ func TestSquash(t *testing.T) {
 	repo, cleanup := cloneTestRepo(t, testRepoDir)
 	defer cleanup()
 
-	err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName)
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
 	checkError(t, err)
 
 	// Check that all files still exist in the feature branch and that the
@@ -48,3 +56,340 @@ func TestSquash(t *testing.T) {
 		)
 	}
 }
+
+func TestSquash_withUpdatedUpstream(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	testRepoGit("commit", "--allow-empty", "--fixup=@")
+
+	testRepoGit("checkout", "master")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+	masterSHA := testRepoGit("rev-parse", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	checkError(t, err)
+
+	// Check that the squashed commit has been rebased onto the latest master
+	if parentSHA := testRepoGit("rev-parse", featureBranchName+"^"); parentSHA != masterSHA {
+		t.Fatalf("Expected the squashed commit to be based on %s, but got %s", masterSHA, parentSHA)
+	}
+	testRepoGit("checkout", featureBranchName)
+	checkFile(t, testRepoDir, foo)
+	checkFile(t, testRepoDir, bar)
+}
+
+func TestSquash_withConflict(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "foo 1\n"})
+	testRepoGit("add", foo.Name)
+	commitToFixMessage := "Add foo"
+	testRepoGit("commit", "-m", commitToFixMessage)
+	commitToFixSHA := testRepoGit("rev-parse", "@")
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "foo 2\n"})
+	testRepoGit("commit", "-am", "Change foo")
+
+	// Fixing the first commit based on the second one conflicts when the
+	// fixup is moved right after the first commit
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "foo 3\n"})
+	testRepoGit("commit", "-a", "--fixup="+commitToFixSHA)
+	fixupSHA := testRepoGit("rev-parse", "@")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	var conflictErr *git.ErrSquashConflict
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("Expected a squash conflict error, but got: %v", err)
+	}
+	if conflictErr.Commit.SHA != fixupSHA || conflictErr.Commit.Subject != "fixup! "+commitToFixMessage {
+		t.Fatalf("Expected the fixup commit to have failed, but got: %+v", conflictErr.Commit)
+	}
+	if conflictErr.FixupTarget.SHA != commitToFixSHA || conflictErr.FixupTarget.Subject != commitToFixMessage {
+		t.Fatalf("Expected the fixup target to be %s, but got: %+v", commitToFixSHA, conflictErr.FixupTarget)
+	}
+	if len(conflictErr.Files) != 1 || conflictErr.Files[0] != foo.Name {
+		t.Fatalf("Expected %s to be the only conflicting file, but got: %v", foo.Name, conflictErr.Files)
+	}
+}
+
+func TestSquash_withChangedTree(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	testRepoGit("checkout", "-b", "side")
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName, "master")
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+	testRepoGit("commit", "--allow-empty", "--fixup=@")
+
+	// A merge with additional changes in the merge commit itself. Rebasing
+	// drops merge commits, so these changes get lost.
+	testRepoGit("merge", "--no-ff", "--no-commit", "side")
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "merged foo\n"})
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Merge side")
+	featureSHA := testRepoGit("rev-parse", featureBranchName)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	var treeErr *git.ErrTreeMismatch
+	if !errors.As(err, &treeErr) {
+		t.Fatalf("Expected a tree mismatch error, but got: %v", err)
+	}
+
+	if newFeatureSHA := testRepoGit("rev-parse", featureBranchName); newFeatureSHA != featureSHA {
+		t.Fatal("Expected the feature branch not to be changed")
+	}
+}
+
+func TestSquash_withAmend(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	// Equivalent of `git commit --fixup=amend:@~`, which needs an editor
+	amendedMessage := "Add a better foo\n\nWith a body."
+	testRepoGit("commit", "--allow-empty", "-m", "amend! Add foo\n\n"+amendedMessage)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, false)
+	checkError(t, err)
+
+	testRepoGit("checkout", featureBranchName)
+
+	checkFile(t, testRepoDir, foo)
+	checkFile(t, testRepoDir, bar)
+
+	messages := testRepoGit("log", "--reverse", "--format=%B", "master..@")
+	if messages != amendedMessage+"\n\nAdd bar" {
+		t.Fatalf("Expected the amend! commit to reword the first commit, but got messages:\n%s", messages)
+	}
+}
+
+func TestSquash_withCoAuthors(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	reviewer := "Reviewer <reviewer@example.com>"
+	otherReviewer := "Other Reviewer <other@example.com>"
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "-m", "Add bar")
+
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "better foo\n"})
+	testRepoGit("commit", "-a", "--fixup=@~", "--author="+reviewer)
+	createFile(t, testRepoDir, file{Name: foo.Name, Contents: "best foo\n"})
+	testRepoGit("commit", "-a", "-m", "fixup! fixup! Add foo", "--author="+reviewer)
+	createFile(t, testRepoDir, file{Name: bar.Name, Contents: "better bar\n"})
+	testRepoGit("commit", "-a", "-m", "fixup! Add bar", "--author="+otherReviewer)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	_, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName, backupPrefix, true)
+	checkError(t, err)
+
+	testRepoGit("checkout", featureBranchName)
+
+	checkFile(t, testRepoDir, file{Name: foo.Name, Contents: "best foo\n"})
+	checkFile(t, testRepoDir, file{Name: bar.Name, Contents: "better bar\n"})
+
+	messages := testRepoGit("log", "--reverse", "--format=%an:%B", "master..@")
+	expectedMessages := "git-test:Add foo\n\nCo-authored-by: " + reviewer + "\n\n" +
+		"git-test:Add bar\n\nCo-authored-by: " + otherReviewer
+	if messages != expectedMessages {
+		t.Fatalf("Expected the squashed commits to credit the fixup authors, but got:\n%s", messages)
+	}
+}
+
+func TestSquash_withAmendAndCoAuthors(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	reviewer := "Reviewer <reviewer@example.com>"
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	amendedMessage := "Add a better foo\n\nWith a body."
+	testRepoGit("commit", "--allow-empty", "-m", "amend! Add foo\n\n"+amendedMessage, "--author="+reviewer)
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	squashedSHA, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName,
+		backupPrefix, true)
+	checkError(t, err)
+
+	if featureSHA := testRepoGit("rev-parse", featureBranchName); featureSHA != squashedSHA {
+		t.Fatalf("Expected the feature branch to point to %s, but it points to %s", squashedSHA, featureSHA)
+	}
+	messages := testRepoGit("log", "--reverse", "--format=%B", "master.."+featureBranchName)
+	if messages != amendedMessage+"\n\nCo-authored-by: "+reviewer {
+		t.Fatalf("Expected the amended message with the co-author, but got:\n%s", messages)
+	}
+}
+
+func TestSquash_withSigning(t *testing.T) {
+	skipWithoutGit(t)
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is required for signing commits")
+	}
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	keyDir, cleanupKey := createTempDir(t)
+	defer cleanupKey()
+	signingKey := filepath.Join(keyDir, "bot")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-f", signingKey).CombinedOutput(); err != nil {
+		t.Fatalf("Failed to generate the signing key: %v\n%s", err, out)
+	}
+
+	featureBranchName := "feature"
+	testRepoGit("checkout", "-b", featureBranchName)
+
+	createFile(t, testRepoDir, foo)
+	testRepoGit("add", foo.Name)
+	testRepoGit("commit", "-m", "Add foo")
+
+	createFile(t, testRepoDir, bar)
+	testRepoGit("add", bar.Name)
+	testRepoGit("commit", "--fixup=@")
+
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepoWithConfig(t, testRepoDir, git.Config{
+		SigningKey:    signingKey,
+		SigningFormat: "ssh",
+	})
+	defer cleanup()
+
+	squashedSHA, err := repo.AutosquashAndPush("origin/master", "origin/"+featureBranchName, featureBranchName,
+		backupPrefix, false)
+	checkError(t, err)
+
+	if head := testRepoGit("rev-parse", featureBranchName); head != squashedSHA {
+		t.Fatalf("Expected %s to be pushed, but the branch points to %s", squashedSHA, head)
+	}
+	squashedCommit := testRepoGit("cat-file", "commit", squashedSHA)
+	if !strings.Contains(squashedCommit, "-----BEGIN SSH SIGNATURE-----") {
+		t.Fatalf("Expected the squashed commit to be signed, but got:\n%s", squashedCommit)
+	}
+}
+
+func TestSquash_inParallel(t *testing.T) {
+	skipWithoutGit(t)
+
+	testRepoGit, testRepoDir, cleanup := createTestRepo(t)
+	defer cleanup()
+
+	branches := []string{"feature", "other-feature"}
+	for _, branch := range branches {
+		testRepoGit("checkout", "-b", branch, "master")
+
+		createFile(t, testRepoDir, file{Name: branch, Contents: branch + "\n"})
+		testRepoGit("add", branch)
+		testRepoGit("commit", "-m", "Add "+branch)
+
+		createFile(t, testRepoDir, file{Name: branch, Contents: "better " + branch + "\n"})
+		testRepoGit("commit", "-a", "--fixup=@")
+	}
+	testRepoGit("checkout", "master")
+
+	repo, cleanup := cloneTestRepo(t, testRepoDir)
+	defer cleanup()
+
+	// Each squash gets a worktree of its own, so they don't interfere with
+	// each other
+	errs := make(chan error, len(branches))
+	for i, branch := range branches {
+		go func(branch string, backupPrefix string) {
+			_, err := repo.AutosquashAndPush("origin/master", "origin/"+branch, branch, backupPrefix, false)
+			errs <- err
+		}(branch, git.BackupPrefix(i+1))
+	}
+	for range branches {
+		checkError(t, <-errs)
+	}
+
+	for _, branch := range branches {
+		messages := testRepoGit("log", "--format=%s", "master.."+branch)
+		if messages != "Add "+branch {
+			t.Fatalf("Expected %s to be squashed, but got commits:\n%s", branch, messages)
+		}
+	}
+}
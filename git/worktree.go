@@ -0,0 +1,52 @@
+package git
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// withWorktree runs the operation in a new worktree that has ref checked out.
+// The bare clone is only locked while the worktree is added and removed, so
+// that operations on different PRs can run in parallel.
+func (r *repo) withWorktree(ref string, operation func(*repo) error) error {
+	worktreePath, err := r.addWorktree(ref)
+	if err != nil {
+		return err
+	}
+	defer r.removeWorktree(worktreePath)
+
+	return operation(&repo{path: worktreePath, config: r.config})
+}
+
+func (r *repo) addWorktree(ref string) (string, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	// The worktrees are kept next to the clones, so that they're stored and
+	// cleaned up together with them
+	if err := os.MkdirAll(r.worktreesPath, 0755); err != nil {
+		return "", fmt.Errorf("failed to create a directory for the worktrees: %v", err)
+	}
+	worktreePath, err := os.MkdirTemp(r.worktreesPath, filepath.Base(r.path)+"-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create a directory for the worktree: %v", err)
+	}
+	if err := r.git("worktree", "add", "--detach", worktreePath, ref); err != nil {
+		os.RemoveAll(worktreePath)
+		return "", fmt.Errorf("failed to add a worktree: %v", err)
+	}
+	return worktreePath, nil
+}
+
+func (r *repo) removeWorktree(worktreePath string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if err := r.git("worktree", "remove", "--force", worktreePath); err != nil {
+		log.Printf("Failed to remove the worktree %s: %v\n", worktreePath, err)
+		os.RemoveAll(worktreePath)
+		r.git("worktree", "prune")
+	}
+}
@@ -17,18 +17,23 @@ type PullRequests interface {
 	Get(ctx context.Context, owner, repo string, number int) (*github.PullRequest, *github.Response, error)
 	ListCommits(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.RepositoryCommit, *github.Response, error)
 	Merge(ctx context.Context, owner, repo string, number int, commitMessage string, opt *github.PullRequestOptions) (*github.PullRequestMergeResult, *github.Response, error)
+	ListReviews(ctx context.Context, owner, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error)
 }
 
 type Repositories interface {
 	CreateStatus(ctx context.Context, owner, repo, ref string, status github.RepoStatus) (*github.RepoStatus, *github.Response, error)
 	GetCombinedStatus(ctx context.Context, owner, repo, ref string, opt *github.ListOptions) (*github.CombinedStatus, *github.Response, error)
 	IsCollaborator(ctx context.Context, owner, repo, user string) (bool, *github.Response, error)
+	GetContents(ctx context.Context, owner, repo, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error)
+	GetCommit(ctx context.Context, owner, repo, sha string, opts *github.ListOptions) (*github.RepositoryCommit, *github.Response, error)
 }
 
 type Issues interface {
 	AddLabelsToIssue(ctx context.Context, owner, repo string, number int, labels []string) ([]*github.Label, *github.Response, error)
 	RemoveLabelForIssue(ctx context.Context, owner, repo string, number int, label string) (*github.Response, error)
 	CreateComment(ctx context.Context, owner string, repo string, number int, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
+	ListComments(ctx context.Context, owner string, repo string, number int, opts *github.IssueListCommentsOptions) ([]*github.IssueComment, *github.Response, error)
+	EditComment(ctx context.Context, owner string, repo string, commentID int64, comment *github.IssueComment) (*github.IssueComment, *github.Response, error)
 }
 
 type Search interface {
@@ -67,6 +72,20 @@ func setStatusForPR(pr *github.PullRequest, status *github.RepoStatus, repositor
 	return setStatus(revision, repository, status, repositories)
 }
 
+// maxStatusDescriptionLength is the maximum length of a status description
+// that GitHub accepts.
+const maxStatusDescriptionLength = 140
+
+// truncateStatusDescription shortens the description so that it would fit
+// into a status.
+func truncateStatusDescription(description string) string {
+	runes := []rune(description)
+	if len(runes) <= maxStatusDescriptionLength {
+		return description
+	}
+	return string(runes[:maxStatusDescriptionLength-1]) + "…"
+}
+
 func setStatus(revision string, repository Repository, status *github.RepoStatus, repositories Repositories) *ErrorResponse {
 	_, _, err := repositories.CreateStatus(context.TODO(), repository.Owner, repository.Name, revision, *status)
 	if err != nil {
@@ -190,6 +209,31 @@ func getCommits(issueable Issueable, isExpectedHead func(string) bool,
 	return commits, nil
 }
 
+func getReviews(issueable Issueable, pullRequests PullRequests) ([]*github.PullRequestReview, *ErrorResponse) {
+	issue := issueable.Issue()
+	pageNr := 1
+	reviews := []*github.PullRequestReview{}
+	for {
+		listOptions := &github.ListOptions{
+			Page: pageNr,
+			// Max is 100: https://developer.github.com/v3/#pagination
+			PerPage: 100,
+		}
+		pageReviews, resp, err := pullRequests.ListReviews(context.TODO(), issue.Repository.Owner,
+			issue.Repository.Name, issue.Number, listOptions)
+		if err != nil {
+			message := fmt.Sprintf("Getting reviews for PR %s failed", issue.FullName())
+			return nil, &ErrorResponse{err, http.StatusBadGateway, message}
+		}
+		reviews = append(reviews, pageReviews...)
+		if resp.NextPage == 0 {
+			break
+		}
+		pageNr = resp.NextPage
+	}
+	return reviews, nil
+}
+
 // findTopologicalHead is a dumb O(n*n) algorithm for finding the HEAD commit
 // from an unsorted list of commits that contain references to their parent
 // commits. HEAD commit is taken to be the commit that has no children, i.e. a
@@ -251,11 +295,13 @@ func removeLabel(repository Repository, issueNumber int, label string, issues Is
 	return nil
 }
 
-func merge(repository Repository, issueNumber int, pullRequests PullRequests) error {
-	additionalCommitMessage := ""
-	opt := &github.PullRequestOptions{MergeMethod: "merge"}
+func merge(repository Repository, issueNumber int, options mergeOptions, pullRequests PullRequests) error {
+	opt := &github.PullRequestOptions{
+		MergeMethod: options.Method,
+		CommitTitle: options.Title,
+	}
 	result, resp, err := pullRequests.Merge(context.TODO(), repository.Owner, repository.Name,
-		issueNumber, additionalCommitMessage, opt)
+		issueNumber, options.Message, opt)
 	if err != nil {
 		if resp != nil && resp.StatusCode == http.StatusMethodNotAllowed {
 			return ErrNotMergeable
@@ -286,6 +332,15 @@ func is404Error(resp *github.Response) bool {
 	return resp != nil && resp.StatusCode == http.StatusNotFound
 }
 
+func hasLabel(pr *github.PullRequest, label string) bool {
+	for _, prLabel := range pr.Labels {
+		if prLabel.GetName() == label {
+			return true
+		}
+	}
+	return false
+}
+
 func isAcrossForks(pr *github.PullRequest) bool {
 	return *pr.Base.Repo.ID != *pr.Head.Repo.ID
 }
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -36,7 +37,12 @@ const (
 	issueNumber          = 7
 	arbitraryIssueAuthor = "author"
 	arbitrarySHA         = "1afdea0acb09ff392fcdb89acfa9d7e9feac4bc1"
+	botLogin             = "review-helper[bot]"
 	numberOfGithubTries  = 4
+
+	arbitraryStatusContext     = "ci/jenkins"
+	arbitraryStatusDescription = "The build failed"
+	arbitraryStatusTargetURL   = "https://ci.example.com/builds/1"
 )
 
 var (
@@ -48,11 +54,12 @@ var (
 		Name:   github.String(repositoryName),
 		SSHURL: github.String(sshURL),
 	}
-	emptyResult   = (interface{})(nil)
-	emptyResponse = &github.Response{Response: &http.Response{}}
-	noError       = (error)(nil)
-	errArbitrary  = errors.New("GitHub is down. Or something.")
-	anyContext    = mock.MatchedBy(func(ctx context.Context) bool { return true })
+	emptyResult      = (interface{})(nil)
+	emptyResponse    = &github.Response{Response: &http.Response{}}
+	notFoundResponse = &github.Response{Response: &http.Response{StatusCode: http.StatusNotFound}}
+	noError          = (error)(nil)
+	errArbitrary     = errors.New("GitHub is down. Or something.")
+	anyContext       = mock.MatchedBy(func(ctx context.Context) bool { return true })
 )
 
 func TestGithubReviewHelper(t *testing.T) {
@@ -62,15 +69,19 @@ func TestGithubReviewHelper(t *testing.T) {
 }
 
 type WebhookTestContext struct {
+	Config           *grh.Config
 	RequestJSON      StringMemoizer
 	Headers          StringMapMemoizer
 	Handle           func()
+	Queue            **grh.EventQueue
+	Request          **http.Request
 	ResponseRecorder **httptest.ResponseRecorder
 	GitRepos         **mocks.Repos
 	PullRequests     **mocks.PullRequests
 	Repositories     **mocks.Repositories
 	Issues           **mocks.Issues
 	Search           **mocks.Search
+	Checks           **mocks.Checks
 }
 
 type WebhookTest func(WebhookTestContext)
@@ -78,7 +89,7 @@ type WebhookTest func(WebhookTestContext)
 var TestWebhookHandler = func(test WebhookTest) bool {
 	Describe("webhook handler", func() {
 		var (
-			conf             grh.Config
+			conf             = new(grh.Config)
 			asyncOperationWg *sync.WaitGroup
 
 			requestJSON = NewStringMemoizer(func() string {
@@ -89,6 +100,7 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 			})
 
 			handler          = new(grh.Handler)
+			queue            = new(*grh.EventQueue)
 			request          = new(*http.Request)
 			responseRecorder = new(*httptest.ResponseRecorder)
 			gitRepos         = new(*mocks.Repos)
@@ -96,6 +108,7 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 			repositories     = new(*mocks.Repositories)
 			issues           = new(*mocks.Issues)
 			search           = new(*mocks.Search)
+			checks           = new(*mocks.Checks)
 		)
 
 		BeforeEach(func() {
@@ -104,8 +117,12 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 			*repositories = new(mocks.Repositories)
 			*issues = new(mocks.Issues)
 			*search = new(mocks.Search)
+			*checks = new(mocks.Checks)
 
 			*responseRecorder = httptest.NewRecorder()
+			// The events are processed synchronously, unless a test sets
+			// up a queue
+			*queue = nil
 
 			githubAPITryDeltas := make([]time.Duration, numberOfGithubTries)
 			for i := range githubAPITryDeltas {
@@ -117,17 +134,37 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 					githubAPITryDeltas[i] = time.Millisecond
 				}
 			}
-			conf = grh.Config{
-				Secret:             "a-secret",
-				GithubAPITryDeltas: githubAPITryDeltas,
+			*conf = grh.Config{
+				Secret:              "a-secret",
+				GithubAPITryDeltas:  githubAPITryDeltas,
+				DeliveryHistorySize: 100,
+				MaxCompleteChecks:   1,
+				BotLogin:            botLogin,
 			}
-
-			asyncOperationWg = &sync.WaitGroup{}
-			*handler = grh.CreateHandler(conf, *gitRepos, asyncOperationWg, *pullRequests,
-				*repositories, *issues, *search)
 		})
 
 		JustBeforeEach(func() {
+			// Create the handler only after all BeforeEach blocks have run to
+			// allow tests to modify the configuration.
+			asyncOperationWg = &sync.WaitGroup{}
+			*handler = grh.CreateHandler(*conf, *gitRepos, asyncOperationWg, *queue, *pullRequests,
+				*repositories, *issues, *search, *checks)
+
+			// Most tests don't care about check runs. Registered last, so that
+			// expectations set up by the tests themselves take precedence.
+			(*checks).
+				On("ListCheckRunsForRef", anyContext, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+				Return(&github.ListCheckRunsResults{
+					Total:     github.Int(0),
+					CheckRuns: []*github.CheckRun{},
+				}, &github.Response{}, noError).
+				Maybe()
+			// Most repositories don't have a configuration file either
+			(*repositories).
+				On("GetContents", anyContext, mock.Anything, mock.Anything, ".github/review-helper.yml", mock.Anything).
+				Return(emptyResult, emptyResult, notFoundResponse, errArbitrary).
+				Maybe()
+
 			data := []byte(requestJSON.Get())
 			var err error
 			*request, err = http.NewRequest("GET", "http://localhost/whatever", bytes.NewBuffer(data))
@@ -139,6 +176,7 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 			mac.Write([]byte(requestJSON.Get()))
 			sig := hex.EncodeToString(mac.Sum(nil))
 			(*request).Header.Add("X-Hub-Signature", "sha1="+sig)
+			(*request).Header.Add("X-Hub-Signature-256", Signature256(conf.Secret, requestJSON.Get()))
 
 			for key, val := range headers.Get() {
 				(*request).Header.Set(key, val)
@@ -152,9 +190,12 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 			(*repositories).AssertExpectations(GinkgoT())
 			(*issues).AssertExpectations(GinkgoT())
 			(*search).AssertExpectations(GinkgoT())
+			(*checks).AssertExpectations(GinkgoT())
 		})
 
 		var handle = func() {
+			// Allow handling the same request more than once
+			(*request).Body = ioutil.NopCloser(strings.NewReader(requestJSON.Get()))
 			response := (*handler)(*responseRecorder, *request)
 			response.WriteResponse(*responseRecorder)
 			// The delay is set to 0 for tests. Wait for all of the operations
@@ -163,15 +204,19 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 		}
 
 		test(WebhookTestContext{
+			Config:           conf,
 			RequestJSON:      requestJSON,
 			Headers:          headers,
 			Handle:           handle,
+			Queue:            queue,
+			Request:          request,
 			ResponseRecorder: responseRecorder,
 			GitRepos:         gitRepos,
 			PullRequests:     pullRequests,
 			Repositories:     repositories,
 			Issues:           issues,
 			Search:           search,
+			Checks:           checks,
 		})
 	})
 
@@ -180,6 +225,14 @@ var TestWebhookHandler = func(test WebhookTest) bool {
 	return true
 }
 
+// Signature256 signs the body with the secret like GitHub signs the
+// X-Hub-Signature-256 header.
+var Signature256 = func(secret, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
 var IssueCommentEvent = func(comment, issueAuthor string) string {
 	commentMsg, err := json.Marshal(comment)
 	if err != nil {
@@ -238,6 +291,97 @@ var PullRequestEvent = func(action, headSHA string, headRepository grh.Repositor
 }`
 }
 
+var PullRequestReviewEvent = func(action, headSHA string, headRepository grh.Repository) string {
+	return `{
+  "action": "` + action + `",
+  "review": {
+    "state": "approved"
+  },
+  "pull_request": {
+    "number": ` + strconv.Itoa(issueNumber) + `,
+    "url": "https://api.github.com/repos/` + repositoryOwner + `/` + repositoryName + `/pulls/` + strconv.Itoa(issueNumber) + `",
+    "head": {
+      "sha": "` + headSHA + `",
+      "repo": {
+        "name": "` + headRepository.Name + `",
+        "owner": {
+          "login": "` + headRepository.Owner + `"
+        },
+        "ssh_url": "` + headRepository.URL + `"
+      }
+    },
+    "user": {
+      "login": "` + arbitraryIssueAuthor + `"
+    }
+  },
+  "repository": {
+    "name": "` + repositoryName + `",
+    "owner": {
+      "login": "` + repositoryOwner + `"
+    },
+    "ssh_url": "` + sshURL + `"
+  }
+}`
+}
+
+var CheckRunEvent = func(action, sha, conclusion string) string {
+	return `{
+  "action": "` + action + `",
+  "check_run": {
+    "head_sha": "` + sha + `",
+    "status": "completed",
+    "conclusion": "` + conclusion + `",
+    "name": "` + arbitraryStatusContext + `",
+    "details_url": "` + arbitraryStatusTargetURL + `",
+    "output": {
+      "title": "` + arbitraryStatusDescription + `"
+    }
+  },
+  "repository": {
+    "name": "` + repositoryName + `",
+    "owner": {
+      "login": "` + repositoryOwner + `"
+    },
+    "ssh_url": "` + sshURL + `"
+  }
+}`
+}
+
+var CheckSuiteEvent = func(action, sha, conclusion string) string {
+	return `{
+  "action": "` + action + `",
+  "check_suite": {
+    "head_sha": "` + sha + `",
+    "status": "completed",
+    "conclusion": "` + conclusion + `",
+    "app": {
+      "name": "GitHub Actions"
+    }
+  },
+  "repository": {
+    "name": "` + repositoryName + `",
+    "owner": {
+      "login": "` + repositoryOwner + `"
+    },
+    "ssh_url": "` + sshURL + `"
+  }
+}`
+}
+
+var PushEvent = func(ref, defaultBranch string) string {
+	return `{
+  "ref": "` + ref + `",
+  "repository": {
+    "name": "` + repositoryName + `",
+    "owner": {
+      "login": "` + repositoryOwner + `"
+    },
+    "ssh_url": "` + sshURL + `",
+    "default_branch": "` + defaultBranch + `"
+  }
+}`
+}
+
 var createStatusEvent = func(sha, state string, branches []grh.Branch) string {
 	branchSHAs := make([]string, len(branches))
 	for i, branch := range branches {
@@ -246,6 +390,9 @@ var createStatusEvent = func(sha, state string, branches []grh.Branch) string {
 	return `{
   "sha": "` + sha + `",
   "state": "` + state + `",
+  "context": "` + arbitraryStatusContext + `",
+  "description": "` + arbitraryStatusDescription + `",
+  "target_url": "` + arbitraryStatusTargetURL + `",
   "branches": [
     {
       "commit": {
@@ -37,6 +37,28 @@ func (r ErrorResponse) logResponse() {
 	}
 }
 
+func isErrorResponse(response Response) bool {
+	switch response.(type) {
+	case ErrorResponse, *ErrorResponse:
+		return true
+	}
+	return false
+}
+
+// isTransientFailure reports whether the response is an error that might
+// go away when the request is handled again later, i.e. a failed GitHub API
+// request. Other errors, e.g. invalid configuration or failed git
+// operations, either persist or might have already pushed something.
+func isTransientFailure(response Response) bool {
+	switch r := response.(type) {
+	case ErrorResponse:
+		return r.Code == http.StatusBadGateway
+	case *ErrorResponse:
+		return r.Code == http.StatusBadGateway
+	}
+	return false
+}
+
 type SuccessResponse struct {
 	Message string
 }
@@ -4,10 +4,12 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"log"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -24,32 +26,65 @@ const (
 	githubStatusPeerReviewContext = "review/peer"
 )
 
-type retryGithubOperation func(func() asyncResponse) MaybeSyncResponse
+// retryGithubOperation tries the job's operation and retries it
+// asynchronously if needed. Jobs that need to be cancellable should be keyed,
+// e.g. with mergeRetryKey.
+type retryGithubOperation func(job retryJob) MaybeSyncResponse
 
 func main() {
 	conf := NewConfig()
-	githubClient := initGithubClient(conf)
+	tokenSource := newTokenSource(conf)
+	githubClient := initGithubClient(tokenSource)
 	if conf.IsAppAuth() {
 		slog.Info("Authenticated as GitHub App", "app_id", conf.AppID, "installation_id", conf.AppInstallationID)
 	}
+	if conf.BotLogin == "" {
+		conf.BotLogin = lookUpBotLogin(conf, githubClient)
+	}
+	slog.Info("Commenting as", "login", conf.BotLogin)
 	reposDir, err := os.MkdirTemp("", "github-review-helper")
 	if err != nil {
 		panic(err)
 	}
 	defer os.RemoveAll(reposDir)
 
-	gitRepos := git.NewRepos(reposDir)
+	gitConfig := git.Config{
+		SigningKey:     conf.SigningKey,
+		SigningFormat:  conf.SigningFormat,
+		CommandTimeout: conf.GitCommandTimeout,
+	}
+	if conf.IsAppAuth() {
+		// Installation tokens work for git over HTTPS, so no SSH key is
+		// needed with App auth
+		gitConfig.Token = func() (string, error) {
+			token, err := tokenSource.Token()
+			if err != nil {
+				return "", err
+			}
+			return token.AccessToken, nil
+		}
+	}
+	gitRepos := git.NewRepos(reposDir, gitConfig)
 	var asyncOperationWg sync.WaitGroup
+	var queue *EventQueue
+	if conf.EventQueueDir != "" {
+		queue, err = NewEventQueue(conf.EventQueueDir, conf.EventWorkers, conf.GithubAPITryDeltas)
+		if err != nil {
+			panic(err)
+		}
+	}
 
 	mux := http.NewServeMux()
 	mux.Handle("/", CreateHandler(
 		conf,
 		gitRepos,
 		&asyncOperationWg,
+		queue,
 		githubClient.PullRequests,
 		githubClient.Repositories,
 		githubClient.Issues,
 		githubClient.Search,
+		githubClient.Checks,
 	))
 
 	srv := &http.Server{
@@ -74,15 +109,100 @@ func main() {
 	if err := srv.Shutdown(ctx); err != nil {
 		panic(err)
 	}
+	if queue != nil {
+		// The events that haven't been started yet stay in the queue until
+		// the next start
+		queue.Stop()
+	}
 
 	asyncOperationWg.Wait()
 }
 
-func CreateHandler(conf Config, gitRepos git.Repos, asyncOperationWg *sync.WaitGroup,
-	pullRequests PullRequests, repositories Repositories, issues Issues, search Search) Handler {
+// CreateHandler creates the webhook handler. The events are processed while
+// handling the requests, unless a queue is given, in which case the events are
+// queued and the queue's workers are started for processing them.
+func CreateHandler(conf Config, gitRepos git.Repos, asyncOperationWg *sync.WaitGroup, queue *EventQueue,
+	pullRequests PullRequests, repositories Repositories, issues Issues, search Search, checks Checks) Handler {
+
+	runAsync := func(operation func()) {
+		asyncOperationWg.Add(1)
+		go func() {
+			defer asyncOperationWg.Done()
+			operation()
+		}()
+	}
+	// The complete checks run the tests of the PRs, which can take a lot of
+	// resources
+	runCompleteCheck := limitConcurrency(runAsync, conf.MaxCompleteChecks)
+	secrets := newWebhookSecrets(conf)
+	repoConfigs := newRepoConfigs(conf, repositories)
+	requestedMergeMethods, err := newRequestedMergeMethods(conf.MergeMethodsFile)
+	if err != nil {
+		panic(err)
+	}
+	deliveries, err := newProcessedDeliveries(conf.DeliveryHistorySize, conf.DeliveryHistoryFile)
+	if err != nil {
+		panic(err)
+	}
+	jobs, persistedJobs, err := newRetryJobs(conf.RetryJobsDir)
+	if err != nil {
+		panic(err)
+	}
+	retries := newScheduledRetries()
+	scheduler := &retrier{
+		tryDelays: conf.GithubAPITryDeltas,
+		run: func(job retryJob) asyncResponse {
+			return runRetryJob(job, repoConfigs, requestedMergeMethods, conf.BotLogin, runCompleteCheck, gitRepos,
+				search, issues, pullRequests, repositories, checks)
+		},
+		jobs:             jobs,
+		retries:          retries,
+		asyncOperationWg: asyncOperationWg,
+	}
+	retry := scheduler.delayWithRetries
+	scheduler.resume(persistedJobs)
+
+	handleEvent := func(eventType string, body []byte) Response {
+		switch eventType {
+		case "issue_comment":
+			return handleIssueComment(body, repoConfigs, requestedMergeMethods, conf.BotLogin, retry, retries,
+				gitRepos, pullRequests, repositories, issues, checks)
+		case "pull_request":
+			return handlePullRequestEvent(body, repoConfigs, requestedMergeMethods, retry, gitRepos, pullRequests,
+				repositories)
+		case "pull_request_review":
+			return handlePullRequestReviewEvent(body, repoConfigs, pullRequests, repositories)
+		case "status":
+			return handleStatusEvent(body, retry)
+		case "check_run":
+			return handleCheckEvent(body, parseCheckRunEvent, retry)
+		case "check_suite":
+			return handleCheckEvent(body, parseCheckSuiteEvent, retry)
+		case "push":
+			return handlePushEvent(body, repoConfigs)
+		}
+		return SuccessResponse{"Not an event I understand. Ignoring."}
+	}
 
-	retry := func(operation func() asyncResponse) MaybeSyncResponse {
-		return delayWithRetries(conf.GithubAPITryDeltas, operation, asyncOperationWg)
+	if queue != nil {
+		// The redeliveries of the events that were queued before a restart
+		// are ignored as well
+		for _, event := range queue.pending() {
+			deliveries.start(event.DeliveryID)
+		}
+		queue.start(func(event queuedEvent) processOutcome {
+			log.Printf("Processing a queued %s event (delivery %s)\n", event.EventType, event.DeliveryID)
+			response := handleEvent(event.EventType, event.Body)
+			handleAsyncResponse(response)
+			if !isErrorResponse(response) {
+				return processed
+			} else if isTransientFailure(response) {
+				return failedTransiently
+			}
+			return failedPermanently
+		}, func(event queuedEvent, succeeded bool) {
+			deliveries.finish(event.DeliveryID, succeeded)
+		})
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) Response {
@@ -90,24 +210,49 @@ func CreateHandler(conf Config, gitRepos git.Repos, asyncOperationWg *sync.WaitG
 		if err != nil {
 			return ErrorResponse{err, http.StatusInternalServerError, "Failed to read the request's body"}
 		}
-		if errResp := checkAuthentication(body, r, conf.Secret); errResp != nil {
+		if errResp := checkAuthentication(body, r, secrets); errResp != nil {
 			return errResp
 		}
+		// The operations that are left running asynchronously aren't waited
+		// for, so a redelivery is ignored even if they fail later
+		deliveryID := r.Header.Get("X-GitHub-Delivery")
+		if !deliveries.start(deliveryID) {
+			return SuccessResponse{fmt.Sprintf("Delivery %s has already been received. Ignoring.", deliveryID)}
+		}
 		eventType := r.Header.Get("X-Github-Event")
-		switch eventType {
-		case "issue_comment":
-			return handleIssueComment(body, retry, gitRepos, pullRequests, repositories, issues)
-		case "pull_request":
-			return handlePullRequestEvent(body, retry, pullRequests, repositories)
-		case "status":
-			return handleStatusEvent(body, retry, gitRepos, search, issues, pullRequests)
+		if queue != nil {
+			return queueEvent(queue, deliveries, deliveryID, eventType, body)
 		}
-		return SuccessResponse{"Not an event I understand. Ignoring."}
+		response := handleEvent(eventType, body)
+		deliveries.finish(deliveryID, !isErrorResponse(response))
+		return response
 	}
 }
 
-func handleIssueComment(body []byte, retry retryGithubOperation, gitRepos git.Repos,
-	pullRequests PullRequests, repositories Repositories, issues Issues) Response {
+func queueEvent(queue *EventQueue, deliveries *processedDeliveries, deliveryID, eventType string,
+	body []byte) Response {
+
+	orderingKey, err := parseOrderingKey(body)
+	if err != nil {
+		deliveries.finish(deliveryID, false)
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	}
+	event := queuedEvent{
+		DeliveryID:  deliveryID,
+		EventType:   eventType,
+		OrderingKey: orderingKey,
+		Body:        body,
+	}
+	if err := queue.push(event); err != nil {
+		deliveries.finish(deliveryID, false)
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to queue the event"}
+	}
+	return SuccessResponse{"Queued the event for processing"}
+}
+
+func handleIssueComment(body []byte, repoConfigs *repoConfigs, requestedMergeMethods *requestedMergeMethods,
+	botLogin string, retry retryGithubOperation, retries *scheduledRetries, gitRepos git.Repos,
+	pullRequests PullRequests, repositories Repositories, issues Issues, checks Checks) Response {
 
 	issueComment, err := parseIssueComment(body)
 	if err != nil {
@@ -125,13 +270,31 @@ func handleIssueComment(body []byte, retry retryGithubOperation, gitRepos git.Re
 	} else if successResp != nil {
 		return successResp
 	}
+	// The configuration is read from the PR's base branch
+	pr, errResp := getPR(issueComment, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	repoConfig, errResp := repoConfigs.get(issueComment.Repository, pr.GetBase().GetRef())
+	if errResp != nil {
+		return errResp
+	} else if !repoConfig.isCommandEnabled(commentCategory) {
+		return SuccessResponse{"Command disabled for this repository. Ignoring."}
+	}
 	switch commentCategory {
 	case squashCommand:
-		return handleSquashCommand(issueComment, gitRepos, pullRequests, repositories)
+		return handleSquashCommand(pr, repoConfig, botLogin, gitRepos, repositories, issues)
 	case mergeCommand:
-		return handleMergeCommand(issueComment, issues, pullRequests, repositories, gitRepos)
+		return handleMergeCommand(issueComment, pr, repoConfig, botLogin, requestedMergeMethods, issues,
+			pullRequests, repositories, checks, gitRepos)
+	case cancelCommand:
+		return handleCancelCommand(issueComment, pr, repoConfig, requestedMergeMethods, retries, issues)
 	case checkCommand:
-		return checkForFixupCommitsOnIssueComment(issueComment, pullRequests, repositories, retry)
+		return checkCommitsOnIssueComment(issueComment, pr.GetBase().GetRef(), repoConfig, retry)
+	case rebaseCommand:
+		return handleRebaseCommand(issueComment, pr, gitRepos, repositories, issues)
+	case undoCommand:
+		return handleUndoCommand(pr, gitRepos, issues)
 	}
 	return ErrorResponse{
 		Code:         http.StatusInternalServerError,
@@ -139,27 +302,85 @@ func handleIssueComment(body []byte, retry retryGithubOperation, gitRepos git.Re
 	}
 }
 
-func handlePullRequestEvent(body []byte, retry retryGithubOperation, pullRequests PullRequests,
-	repositories Repositories) Response {
+func handlePullRequestEvent(body []byte, repoConfigs *repoConfigs, requestedMergeMethods *requestedMergeMethods,
+	retry retryGithubOperation, gitRepos git.Repos, pullRequests PullRequests, repositories Repositories) Response {
 
 	pullRequestEvent, err := parsePullRequestEvent(body)
 	if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	} else if pullRequestEvent.Action == "closed" {
+		requestedMergeMethods.clear(pullRequestEvent.Issue())
+		return pruneBackups(pullRequestEvent, gitRepos)
 	} else if !(pullRequestEvent.Action == "opened" || pullRequestEvent.Action == "synchronize") {
 		return SuccessResponse{"PR not opened or synchronized. Ignoring."}
 	}
-	return checkForFixupCommitsOnPREvent(pullRequestEvent, pullRequests, repositories, retry)
+	repoConfig, errResp := repoConfigs.get(pullRequestEvent.Repository, pullRequestEvent.BaseRef)
+	if errResp != nil {
+		return errResp
+	}
+	if repoConfig.isPeerReviewEnabled() {
+		// Approvals only count for the commit they were given for, so the
+		// new HEAD needs a review/peer status of its own.
+		if errResp := updatePeerReviewStatus(pullRequestEvent, repoConfig.RequiredApprovals, pullRequests,
+			repositories); errResp != nil {
+			return errResp
+		}
+	}
+	checks := commitChecks{
+		squash:   repoConfig.SquashCheck,
+		lint:     repoConfig.CommitLint,
+		complete: repoConfig.CompleteCheck,
+	}
+	if checks.isEmpty() {
+		return SuccessResponse{"Squash check disabled for this repository and commit messages aren't checked. " +
+			"Not checking the commits."}
+	}
+	return checkCommitsOnPREvent(pullRequestEvent, checks.squash, retry)
 }
 
-func handleStatusEvent(body []byte, retry retryGithubOperation, gitRepos git.Repos, search Search,
-	issues Issues, pullRequests PullRequests) Response {
+func handlePullRequestReviewEvent(body []byte, repoConfigs *repoConfigs, pullRequests PullRequests,
+	repositories Repositories) Response {
+
+	pullRequestReviewEvent, err := parsePullRequestReviewEvent(body)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	} else if !isPeerReviewAction(pullRequestReviewEvent.Action) {
+		return SuccessResponse{"Review not submitted, edited or dismissed. Ignoring."}
+	}
+	repoConfig, errResp := repoConfigs.get(pullRequestReviewEvent.Repository, pullRequestReviewEvent.BaseRef)
+	if errResp != nil {
+		return errResp
+	} else if !repoConfig.isPeerReviewEnabled() {
+		return SuccessResponse{"Peer review status not enabled. Ignoring."}
+	}
+	if errResp := updatePeerReviewStatus(pullRequestReviewEvent, repoConfig.RequiredApprovals, pullRequests,
+		repositories); errResp != nil {
+		return errResp
+	}
+	return SuccessResponse{fmt.Sprintf(
+		"Updated the %s status for PR %s",
+		githubStatusPeerReviewContext,
+		pullRequestReviewEvent.Issue().FullName(),
+	)}
+}
+
+func handleStatusEvent(body []byte, retry retryGithubOperation) Response {
 
 	statusEvent, err := parseStatusEvent(body)
 	if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
-	} else if newPullRequestsPossiblyReadyForMerging(statusEvent) {
-		maybeSyncResponse := retry(func() asyncResponse {
-			return mergePullRequestsReadyForMerging(statusEvent, gitRepos, search, issues, pullRequests)
+	}
+	possiblyReady := newPullRequestsPossiblyReadyForMerging(statusEvent)
+	possiblyFailed := pullRequestsPossiblyFailedForMerging(statusEvent)
+	if !possiblyReady && !possiblyFailed {
+		return SuccessResponse{"Status update does not affect any PRs mergeability. Ignoring."}
+	}
+	if possiblyReady {
+		maybeSyncResponse := retry(retryJob{
+			Kind:       mergeReadyJob,
+			Key:        mergeRetryKey(statusEvent.Repository, statusEvent.SHA),
+			Repository: statusEvent.Repository,
+			SHA:        statusEvent.SHA,
 		})
 		if maybeSyncResponse.OperationFinishedSynchronously {
 			return maybeSyncResponse.Response
@@ -167,31 +388,154 @@ func handleStatusEvent(body []byte, retry retryGithubOperation, gitRepos git.Rep
 		return SuccessResponse{"Status update might have caused a PR to become mergeable. Will check for " +
 			"mergeable PRs asynchronously"}
 	}
-	return SuccessResponse{"Status update does not affect any PRs mergeability. Ignoring."}
+	maybeSyncResponse := retry(retryJob{
+		Kind:       cancelMergingJob,
+		Key:        unkeyedOperation,
+		Repository: statusEvent.Repository,
+		SHA:        statusEvent.SHA,
+		Failure: checkFailure{
+			Kind:        statusCheckKind,
+			Name:        statusEvent.Context,
+			Description: statusEvent.Description,
+			TargetURL:   statusEvent.TargetURL,
+		},
+	})
+	if maybeSyncResponse.OperationFinishedSynchronously {
+		return maybeSyncResponse.Response
+	}
+	return SuccessResponse{"Status update might have caused a PR to become unmergeable. Will check for " +
+		"PRs to stop merging asynchronously"}
+}
+
+func handleCheckEvent(body []byte, parse func([]byte) (CheckEvent, error), retry retryGithubOperation) Response {
+
+	checkEvent, err := parse(body)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	} else if !isCheckEventCompleted(checkEvent) {
+		return SuccessResponse{"Check not completed. Ignoring."}
+	}
+	var maybeSyncResponse MaybeSyncResponse
+	if checkConclusionState(checkEvent.Conclusion) == "success" {
+		maybeSyncResponse = retry(retryJob{
+			Kind:       mergeReadyJob,
+			Key:        mergeRetryKey(checkEvent.Repository, checkEvent.SHA),
+			Repository: checkEvent.Repository,
+			SHA:        checkEvent.SHA,
+		})
+	} else {
+		maybeSyncResponse = retry(retryJob{
+			Kind:       cancelMergingJob,
+			Key:        unkeyedOperation,
+			Repository: checkEvent.Repository,
+			SHA:        checkEvent.SHA,
+			Failure: checkFailure{
+				Kind:        checkEvent.Kind,
+				Name:        checkEvent.Name,
+				Description: checkEvent.Summary,
+				TargetURL:   checkEvent.TargetURL,
+			},
+		})
+	}
+	if maybeSyncResponse.OperationFinishedSynchronously {
+		return maybeSyncResponse.Response
+	}
+	return SuccessResponse{"Completed check might have changed a PR's mergeability. Will check for " +
+		"affected PRs asynchronously"}
+}
+
+// runRetryJob tries the operation of the job once, using the current
+// configuration of the job's repository.
+func runRetryJob(job retryJob, repoConfigs *repoConfigs, requestedMergeMethods *requestedMergeMethods,
+	botLogin string, runAsync runAsyncOperation, gitRepos git.Repos, search Search, issues Issues,
+	pullRequests PullRequests, repositories Repositories, checks Checks) asyncResponse {
+
+	repoConfig, errResp := repoConfigs.get(job.Repository, job.Branch)
+	if errResp != nil {
+		return nonRetriable(errResp)
+	}
+	switch job.Kind {
+	case checkCommitsJob:
+		return runCommitChecks(job, repoConfig, botLogin, gitRepos, pullRequests, repositories, issues, runAsync)
+	case mergeReadyJob:
+		return mergePullRequestsReadyForMerging(job.SHA, job.Repository, repoConfig, repoConfigs,
+			requestedMergeMethods, gitRepos, search, issues, pullRequests, repositories, checks)
+	case cancelMergingJob:
+		return cancelMergingForFailedCheck(job.SHA, job.Repository, repoConfig, job.Failure, search, issues,
+			pullRequests)
+	}
+	return nonRetriable(ErrorResponse{
+		Code:         http.StatusInternalServerError,
+		ErrorMessage: fmt.Sprintf("Unknown retry job kind: %s", job.Kind),
+	})
+}
+
+func handlePushEvent(body []byte, repoConfigs *repoConfigs) Response {
+	pushEvent, err := parsePushEvent(body)
+	if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to parse the request's body"}
+	}
+	branch, isBranch := strings.CutPrefix(pushEvent.Ref, "refs/heads/")
+	if !isBranch {
+		return SuccessResponse{"Push not to a branch. Ignoring."}
+	}
+	repoConfigs.invalidate(pushEvent.Repository, branch)
+	return SuccessResponse{fmt.Sprintf(
+		"Cleared the cached %s of %s for %s",
+		repoConfigPath,
+		branch,
+		repoConfigKey(pushEvent.Repository),
+	)}
 }
 
-func initGithubClient(conf Config) *github.Client {
-	var transport http.RoundTripper
+// newTokenSource creates the source of the tokens that are used for both the
+// GitHub API and git. Installation tokens are refreshed as they expire.
+func newTokenSource(conf Config) oauth2.TokenSource {
 	if conf.IsAppAuth() {
-		keyData, err := os.ReadFile(conf.AppPrivateKeyFile)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to read GitHub App private key file: %v", err))
-		}
-		appTokenSource, err := githubauth.NewApplicationTokenSource(conf.AppID, keyData)
+		// The installation token source reuses the token until it expires
+		return githubauth.NewInstallationTokenSource(conf.AppInstallationID, newAppTokenSource(conf))
+	}
+	return oauth2.StaticTokenSource(
+		&oauth2.Token{AccessToken: conf.AccessToken},
+	)
+}
+
+// newAppTokenSource creates the source of the tokens that authenticate as the
+// GitHub App itself rather than as an installation of it.
+func newAppTokenSource(conf Config) oauth2.TokenSource {
+	keyData, err := os.ReadFile(conf.AppPrivateKeyFile)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to read GitHub App private key file: %v", err))
+	}
+	appTokenSource, err := githubauth.NewApplicationTokenSource(conf.AppID, keyData)
+	if err != nil {
+		panic(fmt.Sprintf("Failed to create GitHub App token source: %v", err))
+	}
+	return appTokenSource
+}
+
+// lookUpBotLogin finds the login of the user that the bot comments as. GitHub
+// Apps comment as "<app slug>[bot]", which installation tokens can't look up,
+// so the app itself is looked up instead.
+func lookUpBotLogin(conf Config, githubClient *github.Client) string {
+	if conf.IsAppAuth() {
+		appClient := github.NewClient(oauth2.NewClient(context.Background(), newAppTokenSource(conf)))
+		app, _, err := appClient.Apps.Get(context.Background(), "")
 		if err != nil {
-			panic(fmt.Sprintf("Failed to create GitHub App token source: %v", err))
-		}
-		installationTokenSource := githubauth.NewInstallationTokenSource(conf.AppInstallationID, appTokenSource)
-		transport = &oauth2.Transport{
-			Source: installationTokenSource,
-		}
-	} else {
-		tokenSource := oauth2.StaticTokenSource(
-			&oauth2.Token{AccessToken: conf.AccessToken},
-		)
-		transport = &oauth2.Transport{
-			Source: tokenSource,
+			panic(fmt.Sprintf("Failed to look up the GitHub App, set GITHUB_BOT_LOGIN instead: %v", err))
 		}
+		return app.GetSlug() + "[bot]"
+	}
+	user, _, err := githubClient.Users.Get(context.Background(), "")
+	if err != nil {
+		panic(fmt.Sprintf("Failed to look up the authenticated user, set GITHUB_BOT_LOGIN instead: %v", err))
+	}
+	return user.GetLogin()
+}
+
+func initGithubClient(tokenSource oauth2.TokenSource) *github.Client {
+	transport := &oauth2.Transport{
+		Source: tokenSource,
 	}
 
 	memoryCacheTransport := &httpcache.Transport{
@@ -212,7 +556,10 @@ type commentType int
 const (
 	squashCommand commentType = iota
 	mergeCommand
+	cancelCommand
 	checkCommand
+	rebaseCommand
+	undoCommand
 	regularComment
 )
 
@@ -220,10 +567,18 @@ func parseComment(comment string) commentType {
 	switch {
 	case isSquashCommand(comment):
 		return squashCommand
+	case isCancelCommand(comment):
+		// Checked before the merge command, because "!merge cancel" is also
+		// accepted for cancelling.
+		return cancelCommand
 	case isMergeCommand(comment):
 		return mergeCommand
 	case isCheckCommand(comment):
 		return checkCommand
+	case isRebaseCommand(comment):
+		return rebaseCommand
+	case isUndoCommand(comment):
+		return undoCommand
 	}
 	return regularComment
 }
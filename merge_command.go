@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"regexp"
+	"strings"
 
 	"github.com/google/go-github/v84/github"
 	"github.com/salemove/github-review-helper/git"
@@ -22,6 +23,18 @@ func isMergeCommand(comment string) bool {
 	return mergeCommandPattern.MatchString(comment)
 }
 
+// mergeCommandMethod returns the merge method given as the first argument
+// on the line of the !merge command or an empty string if no merge method
+// was given, like in "!merge", "!merge this" and "!merge\nThanks!".
+func mergeCommandMethod(comment string) string {
+	commandLine, _, _ := strings.Cut(strings.TrimSpace(comment), "\n")
+	arguments := strings.Fields(commandLine)[1:]
+	if len(arguments) > 0 && contains(mergeMethods, arguments[0]) {
+		return arguments[0]
+	}
+	return ""
+}
+
 func newPullRequestsPossiblyReadyForMerging(statusEvent StatusEvent) bool {
 	// We only care about success events, because only these events have the
 	// possibility of changing a PR's combined status into "success" and so
@@ -32,18 +45,34 @@ func newPullRequestsPossiblyReadyForMerging(statusEvent StatusEvent) bool {
 	return statusEvent.State == "success" && isStatusForBranchHead(statusEvent)
 }
 
-func handleMergeCommand(issueComment IssueComment, issues Issues, pullRequests PullRequests,
-	repositories Repositories, gitRepos git.Repos) Response {
-	errResp := addLabel(issueComment.Repository, issueComment.IssueNumber, MergingLabel, issues)
-	if errResp != nil {
-		return errResp
+func pullRequestsPossiblyFailedForMerging(statusEvent StatusEvent) bool {
+	// Both "failure" and "error" states turn a PR's combined status into
+	// "failure", which means that a PR waiting to be merged will never be
+	// merged before the author pushes new changes.
+	return (statusEvent.State == "failure" || statusEvent.State == "error") &&
+		isStatusForBranchHead(statusEvent)
+}
+
+func handleMergeCommand(issueComment IssueComment, pr *github.PullRequest, repoConfig RepoConfig, botLogin string,
+	requestedMergeMethods *requestedMergeMethods, issues Issues, pullRequests PullRequests,
+	repositories Repositories, checks Checks, gitRepos git.Repos) Response {
+	method := mergeCommandMethod(issueComment.Comment)
+	if method != "" {
+		if err := requestedMergeMethods.request(issueComment.Issue(), method); err != nil {
+			message := fmt.Sprintf("Failed to remember the merge method of PR %s", issueComment.Issue().FullName())
+			return ErrorResponse{err, http.StatusInternalServerError, message}
+		}
+	} else {
+		requestedMergeMethods.clear(issueComment.Issue())
 	}
-	pr, errResp := getPR(issueComment, pullRequests)
+	errResp := addLabel(issueComment.Repository, issueComment.IssueNumber, repoConfig.MergingLabel, issues)
 	if errResp != nil {
 		return errResp
-	} else if *pr.Merged {
-		log.Printf("PR #%d already merged. Removing the '%s' label.\n", issueComment.IssueNumber, MergingLabel)
-		errResp = removeLabel(issueComment.Repository, issueComment.IssueNumber, MergingLabel, issues)
+	}
+	if *pr.Merged {
+		log.Printf("PR #%d already merged. Removing the '%s' label.\n", issueComment.IssueNumber,
+			repoConfig.MergingLabel)
+		errResp = removeLabel(issueComment.Repository, issueComment.IssueNumber, repoConfig.MergingLabel, issues)
 		if errResp != nil {
 			return errResp
 		}
@@ -51,37 +80,44 @@ func handleMergeCommand(issueComment IssueComment, issues Issues, pullRequests P
 	} else if !*pr.Mergeable {
 		return SuccessResponse{}
 	}
-	state, statuses, errResp := getStatuses(pr, repositories)
+	state, statuses, errResp := getMergeState(pr, repositories, checks)
 	if errResp != nil {
 		return errResp
 	} else if state == "pending" && containsPendingSquashStatus(statuses) {
-		return squashAndReportFailure(pr, gitRepos, repositories)
+		return squashAndReportFailure(pr, repoConfig.CoAuthorTrailers, botLogin, gitRepos, repositories, issues)
 	} else if state != "success" {
 		log.Printf("PR #%d has pending and/or failed statuses. Not merging.\n", issueComment.IssueNumber)
 		return SuccessResponse{}
 	}
-	if errResp = mergeReadyPR(pr, gitRepos, issues, pullRequests); errResp != nil {
+	if errResp = mergeReadyPR(pr, repoConfig, requestedMergeMethods, gitRepos, issues,
+		pullRequests); errResp != nil {
 		return errResp
 	}
 	return SuccessResponse{fmt.Sprintf("Successfully merged PR %s", issueComment.Issue().FullName())}
 }
 
-func mergeReadyPR(pr *github.PullRequest, gitRepos git.Repos, issues Issues,
-	pullRequests PullRequests) *ErrorResponse {
+func mergeReadyPR(pr *github.PullRequest, repoConfig RepoConfig, requestedMergeMethods *requestedMergeMethods,
+	gitRepos git.Repos, issues Issues, pullRequests PullRequests) *ErrorResponse {
 	issue := prIssue(pr)
-	err := merge(issue.Repository, issue.Number, pullRequests)
+	method := requestedMergeMethods.forIssue(issue, repoConfig.MergeMethod)
+	options, errResp := getMergeOptions(pr, method, repoConfig, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	err := merge(issue.Repository, issue.Number, options, pullRequests)
 	if err == ErrMergeConflict {
-		return handleMergeConflict(issue, issues)
+		return handleMergeConflict(issue, repoConfig.MergingLabel, issues)
 	} else if err != nil {
 		message := fmt.Sprintf("Failed to merge PR %s", issue.FullName())
 		return &ErrorResponse{err, http.StatusBadGateway, message}
 	}
+	requestedMergeMethods.clear(issue)
 	log.Printf(
 		"PR %s successfully merged. Removing the '%s' label.\n",
 		issue.FullName(),
-		MergingLabel,
+		repoConfig.MergingLabel,
 	)
-	errResp := removeLabel(issue.Repository, issue.Number, MergingLabel, issues)
+	errResp = removeLabel(issue.Repository, issue.Number, repoConfig.MergingLabel, issues)
 	if errResp != nil {
 		return errResp
 	}
@@ -96,70 +132,199 @@ func mergeReadyPR(pr *github.PullRequest, gitRepos git.Repos, issues Issues,
 	return nil
 }
 
-func mergePullRequestsReadyForMerging(statusEvent StatusEvent, gitRepos git.Repos, search Search,
-	issues Issues, pullRequests PullRequests) asyncResponse {
-	// Not sure if applying the additional repo:owner/name filter to the query
-	// works for cross-fork PRs, but nothing else has been tested with
-	// cross-fork PRs either so this is left in for now.
+// mergePullRequestsReadyForMerging merges the PRs with the merging label of
+// repoConfig whose head is sha. Every PR is merged with the configuration of
+// its base branch.
+func mergePullRequestsReadyForMerging(sha string, repository Repository, repoConfig RepoConfig,
+	repoConfigs *repoConfigs, requestedMergeMethods *requestedMergeMethods, gitRepos git.Repos, search Search, issues Issues,
+	pullRequests PullRequests, repositories Repositories, checks Checks) asyncResponse {
+	// Specifying the SHA for the search query doesn't guarantee that the SHA
+	// is the HEAD of the returned PRs. This means that, if the commit is in 2
+	// different PRs, both of which have the merging label and have "success"
+	// status then it can happen that it will try to merge both. Which might
+	// not be intended, but is still okay, because both PRs do match all the
+	// criteria required for merging.
 	//
-	// Also, specifying the SHA for the search query doesn't guarantee that the
-	// SHA is the HEAD of the returned PRs. This means that, if the commit is
-	// in 2 different PRs, both of which have the "merging" label and have
-	// "success" status then it can happen that it will try to merge both.
-	// Which might not be intended, but is still okay, because both PRs do
-	// match all the criteria required for merging.
-	query := fmt.Sprintf(
-		"%s label:\"%s\" is:open repo:%s/%s status:success",
-		statusEvent.SHA,
-		MergingLabel,
-		statusEvent.Repository.Owner,
-		statusEvent.Repository.Name,
-	)
-	issuesToMerge, err := searchIssues(query, search)
-	if err != nil {
-		message := fmt.Sprintf("Searching for issues with query '%s' failed", query)
-		return nonRetriable(ErrorResponse{err, http.StatusBadGateway, message})
+	// The merge state of every found PR is evaluated before merging.
+	issuesToMerge, errResp := searchPRsBeingMerged(sha, repository, repoConfig.MergingLabel, search)
+	if errResp != nil {
+		return nonRetriable(errResp)
 	} else if len(issuesToMerge) == 0 {
 		return retriable(SuccessResponse{"Found no PRs to merge"})
 	}
 
 	var finalErrResp *ErrorResponse
-	handleErrResp := func(errResp *ErrorResponse) {
-		if finalErrResp == nil {
-			finalErrResp = errResp
-		} else {
-			log.Printf("Multiple PR merge errors have occured. Marking the latest error to be "+
-				"returned as a response, replacing the previous error. Logging the previous "+
-				"error:\n%s: %v\n", finalErrResp.ErrorMessage, finalErrResp.Error)
-			finalErrResp = errResp
+	mergedCount := 0
+	for _, issue := range issuesToMerge {
+		pr, errResp := getPR(issue, pullRequests)
+		if errResp != nil {
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
+		} else if !hasLabel(pr, repoConfig.MergingLabel) {
+			// The search results can lag behind, e.g. right after merging
+			// was cancelled
+			log.Printf("PR %s no longer has the '%s' label. Not merging.\n", issue.FullName(),
+				repoConfig.MergingLabel)
+			continue
+		}
+		state, _, errResp := getMergeState(pr, repositories, checks)
+		if errResp != nil {
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
+		} else if state != "success" {
+			log.Printf("PR %s has pending and/or failed statuses or checks. Not merging.\n", issue.FullName())
+			continue
+		}
+		prConfig, errResp := repoConfigs.get(repository, pr.GetBase().GetRef())
+		if errResp != nil {
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
+		}
+		if errResp := mergeReadyPR(pr, prConfig, requestedMergeMethods, gitRepos, issues,
+			pullRequests); errResp != nil {
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
 		}
+		mergedCount++
 	}
+	if finalErrResp != nil {
+		return nonRetriable(finalErrResp)
+	}
+	return nonRetriable(
+		SuccessResponse{fmt.Sprintf("Successfully merged %d PRs", mergedCount)},
+	)
+}
 
-	for _, issueToMerge := range issuesToMerge {
-		issue := Issue{
-			Number:     *issueToMerge.Number,
-			Repository: statusEvent.Repository,
+// searchPRsBeingMerged searches for the open PRs in the repository that have
+// the merging label and include the commit. The commit isn't necessarily the
+// HEAD of the found PRs.
+//
+// Not sure if applying the additional repo:owner/name filter to the query
+// works for cross-fork PRs, but nothing else has been tested with cross-fork
+// PRs either so this is left in for now.
+//
+// The status qualifier is not used, because it doesn't account for check runs
+// and the combined status might not yet reflect a failure.
+func searchPRsBeingMerged(sha string, repository Repository, mergingLabel string, search Search) ([]Issue,
+	*ErrorResponse) {
+
+	query := fmt.Sprintf(
+		"%s label:\"%s\" is:open repo:%s/%s",
+		sha,
+		mergingLabel,
+		repository.Owner,
+		repository.Name,
+	)
+	foundIssues, err := searchIssues(query, search)
+	if err != nil {
+		message := fmt.Sprintf("Searching for issues with query '%s' failed", query)
+		return nil, &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	prIssues := make([]Issue, len(foundIssues))
+	for i, foundIssue := range foundIssues {
+		prIssues[i] = Issue{
+			Number:     *foundIssue.Number,
+			Repository: repository,
 			User: User{
-				Login: *issueToMerge.User.Login,
+				Login: *foundIssue.User.Login,
 			},
 		}
+	}
+	return prIssues, nil
+}
+
+// The kinds of checks that can fail
+const (
+	statusCheckKind = "status check"
+	checkRunKind    = "check run"
+	checkSuiteKind  = "check suite"
+)
+
+// checkFailure describes a failed status or check for notifying the authors of
+// the affected PRs.
+type checkFailure struct {
+	// Kind is one of the check kinds, e.g. checkRunKind
+	Kind        string
+	Name        string
+	Description string
+	TargetURL   string
+}
+
+func cancelMergingForFailedCheck(sha string, repository Repository, repoConfig RepoConfig, failure checkFailure,
+	search Search, issues Issues, pullRequests PullRequests) asyncResponse {
+	issuesToCancel, errResp := searchPRsBeingMerged(sha, repository, repoConfig.MergingLabel, search)
+	if errResp != nil {
+		return nonRetriable(errResp)
+	} else if len(issuesToCancel) == 0 {
+		return nonRetriable(SuccessResponse{"Found no PRs to stop merging"})
+	}
+
+	var finalErrResp *ErrorResponse
+	cancelledCount := 0
+	for _, issue := range issuesToCancel {
 		pr, errResp := getPR(issue, pullRequests)
 		if errResp != nil {
-			handleErrResp(errResp)
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
+		} else if *pr.Head.SHA != sha {
+			// The search matches any PR that includes the commit, not only
+			// the ones where the commit is the HEAD.
+			log.Printf("PR %s has moved on from commit %s. Not cancelling the merge.\n",
+				issue.FullName(), sha)
 			continue
 		}
-		if errResp := mergeReadyPR(pr, gitRepos, issues, pullRequests); errResp != nil {
-			handleErrResp(errResp)
+		if !hasLabel(pr, repoConfig.MergingLabel) {
+			// The search index might not be up to date yet, e.g. when a failed
+			// check run and the check suite it belongs to are handled one
+			// after another. Merging has already been cancelled then.
+			log.Printf("PR %s is no longer being merged. Not cancelling the merge.\n", issue.FullName())
+			continue
 		}
+		message := failedCheckMessage(failure, issue)
+		if errResp := cancelMerging(issue, message, repoConfig.MergingLabel, issues); errResp != nil {
+			finalErrResp = replaceErrResp(finalErrResp, errResp)
+			continue
+		}
+		cancelledCount++
 	}
 	if finalErrResp != nil {
 		return nonRetriable(finalErrResp)
 	}
 	return nonRetriable(
-		SuccessResponse{fmt.Sprintf("Successfully merged %d PRs", len(issuesToMerge))},
+		SuccessResponse{fmt.Sprintf("Stopped merging %d PRs due to a failed check", cancelledCount)},
 	)
 }
 
+func failedCheckMessage(failure checkFailure, issue Issue) string {
+	kind := failure.Kind
+	if kind == "" {
+		// Jobs persisted before the kind was recorded
+		kind = "check"
+	}
+	message := fmt.Sprintf("The `%s` %s failed", failure.Name, kind)
+	if failure.Description != "" {
+		message += fmt.Sprintf(" with: %s", failure.Description)
+	}
+	message += "."
+	if failure.TargetURL != "" {
+		message += fmt.Sprintf(" See %s for details.", failure.TargetURL)
+	}
+	return message + fmt.Sprintf(" I've stopped merging this PR. @%s, can you please take a look?",
+		issue.User.Login)
+}
+
+// replaceErrResp returns the latest of multiple error responses that have
+// occurred while handling a single request. The replaced error is logged, so
+// that it wouldn't get lost.
+func replaceErrResp(previous, latest *ErrorResponse) *ErrorResponse {
+	if previous != nil {
+		log.Printf("Multiple PR errors have occured. Marking the latest error to be "+
+			"returned as a response, replacing the previous error. Logging the previous "+
+			"error:\n%s: %v\n", previous.ErrorMessage, previous.Error)
+	}
+	return latest
+}
+
 func containsPendingSquashStatus(statuses []*github.RepoStatus) bool {
 	for _, status := range statuses {
 		if *status.Context == githubStatusSquashContext && *status.State == "pending" {
@@ -178,26 +343,33 @@ func isStatusForBranchHead(statusEvent StatusEvent) bool {
 	return false
 }
 
-func handleMergeConflict(issue Issue, issues Issues) *ErrorResponse {
+func handleMergeConflict(issue Issue, mergingLabel string, issues Issues) *ErrorResponse {
+	log.Printf("Merging PR %s failed due to a merge conflict.\n", issue.FullName())
+	message := fmt.Sprintf("I'm unable to merge this PR because of a merge conflict."+
+		" @%s, can you please take a look?", issue.User.Login)
+	return cancelMerging(issue, message, mergingLabel, issues)
+}
+
+// cancelMerging removes the merging label from the PR and notifies the author
+// of the reason with the given message.
+func cancelMerging(issue Issue, message, mergingLabel string, issues Issues) *ErrorResponse {
 	log.Printf(
-		"Merging PR %s failed due to a merge conflict. Removing the '%s' label and notifying the author.\n",
+		"Removing the '%s' label from PR %s and notifying the author.\n",
+		mergingLabel,
 		issue.FullName(),
-		MergingLabel,
 	)
-	removeLabelErrResp := removeLabel(issue.Repository, issue.Number, MergingLabel, issues)
+	removeLabelErrResp := removeLabel(issue.Repository, issue.Number, mergingLabel, issues)
 	if removeLabelErrResp != nil {
 		log.Printf(
-			"Failed to remove the '%s' label. Still notifying the author of the merge conflict. %v\n",
-			MergingLabel,
+			"Failed to remove the '%s' label. Still notifying the author. %v\n",
+			mergingLabel,
 			removeLabelErrResp.Error,
 		)
 	}
-	message := fmt.Sprintf("I'm unable to merge this PR because of a merge conflict."+
-		" @%s, can you please take a look?", issue.User.Login)
 	err := comment(message, issue.Repository, issue.Number, issues)
 	if err != nil {
 		errorMessage := fmt.Sprintf(
-			"Failed to notify the author of PR %s about the merge conflict",
+			"Failed to notify the author of PR %s about the cancelled merge",
 			issue.FullName(),
 		)
 		return &ErrorResponse{err, http.StatusBadGateway, errorMessage}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"github.com/google/go-github/v84/github"
 	grh "github.com/salemove/github-review-helper"
@@ -26,6 +27,8 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 
 			responseRecorder *httptest.ResponseRecorder
 			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			checks           *mocks.Checks
 			issues           *mocks.Issues
 			search           *mocks.Search
 			gitRepos         *mocks.Repos
@@ -33,6 +36,8 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 		BeforeEach(func() {
 			responseRecorder = *context.ResponseRecorder
 			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			checks = *context.Checks
 			issues = *context.Issues
 			search = *context.Search
 			gitRepos = *context.GitRepos
@@ -44,19 +49,190 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			}
 		})
 
-		for _, badStatus := range []string{"pending", "failure", "error"} {
-			Context("with "+badStatus+" status", func() {
-				branches := []grh.Branch{{
-					SHA: mockSHA,
-				}}
+		Context("with pending status", func() {
+			branches := []grh.Branch{{
+				SHA: mockSHA,
+			}}
 
-				requestJSON.Is(func() string {
-					return createStatusEvent(mockSHA, badStatus, branches)
+			requestJSON.Is(func() string {
+				return createStatusEvent(mockSHA, "pending", branches)
+			})
+
+			It("returns 200 OK", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		for _, failedStatus := range []string{"failure", "error"} {
+			status := failedStatus
+
+			Context("with "+status+" status", func() {
+				Context("when updating a commit that is not a branch's head", func() {
+					otherSHA := "4eaf26faa8819ab5aee991461b8c4fff41778f41"
+					branches := []grh.Branch{{
+						SHA: otherSHA,
+					}}
+
+					requestJSON.Is(func() string {
+						return createStatusEvent(mockSHA, status, branches)
+					})
+
+					It("returns 200 OK", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
 				})
 
-				It("returns 200 OK", func() {
-					handle()
-					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Context("when updating a commit that is a branch's head", func() {
+					branches := []grh.Branch{{
+						SHA: mockSHA,
+					}}
+
+					requestJSON.Is(func() string {
+						return createStatusEvent(mockSHA, status, branches)
+					})
+
+					searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s",
+						mockSHA, grh.MergingLabel, repositoryOwner, repositoryName)
+
+					Context("with issue search failing", func() {
+						BeforeEach(func() {
+							search.
+								On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+								Return(emptyResult, emptyResponse, errArbitrary)
+						})
+
+						It("fails with a gateway error", func() {
+							handle()
+							Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+						})
+					})
+
+					Context("with issue search returning 0 PRs", func() {
+						BeforeEach(func() {
+							search.
+								On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+								Return(&github.IssuesSearchResult{
+									Total:  github.Int(0),
+									Issues: []*github.Issue{},
+								}, &github.Response{}, noError)
+						})
+
+						It("returns 200 OK", func() {
+							handle()
+							Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						})
+
+						It("tries once", func() {
+							handle()
+							search.AssertNumberOfCalls(GinkgoT(), "Issues", 1)
+						})
+					})
+
+					Context("with issue search returning a PR", func() {
+						userName := "bestcoder"
+						issueNumber := 7331
+
+						BeforeEach(func() {
+							search.
+								On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+								Return(&github.IssuesSearchResult{
+									Total: github.Int(1),
+									Issues: []*github.Issue{{
+										Number: github.Int(issueNumber),
+										User: &github.User{
+											Login: github.String(userName),
+										},
+									}},
+								}, &github.Response{}, noError)
+						})
+
+						Context("with the PR having moved on to another commit", func() {
+							BeforeEach(func() {
+								pullRequests.
+									On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+									Return(&github.PullRequest{
+										Number: github.Int(issueNumber),
+										Head: &github.PullRequestBranch{
+											SHA: github.String("4eaf26faa8819ab5aee991461b8c4fff41778f41"),
+										},
+									}, emptyResponse, noError)
+							})
+
+							It("leaves the PR alone", func() {
+								handle()
+								Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							})
+						})
+
+						Context("with merging the PR having already been cancelled", func() {
+							BeforeEach(func() {
+								pullRequests.
+									On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+									Return(&github.PullRequest{
+										Number: github.Int(issueNumber),
+										Head: &github.PullRequestBranch{
+											SHA: github.String(mockSHA),
+										},
+									}, emptyResponse, noError)
+							})
+
+							It("leaves the PR alone", func() {
+								handle()
+								Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							})
+						})
+
+						Context("with the failed commit being the PR's head", func() {
+							BeforeEach(func() {
+								pullRequests.
+									On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+									Return(&github.PullRequest{
+										Number: github.Int(issueNumber),
+										Head: &github.PullRequestBranch{
+											SHA: github.String(mockSHA),
+										},
+										Labels: []*github.Label{{
+											Name: github.String(grh.MergingLabel),
+										}},
+									}, emptyResponse, noError)
+							})
+
+							It("removes the 'merging' label and notifies the author of the failed status", func() {
+								issues.
+									On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+									Return(emptyResponse, noError)
+								issues.
+									On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+										mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+											return commentMentioning(userName)(issueComment) &&
+												strings.Contains(*issueComment.Body, "`"+arbitraryStatusContext+"` status check failed") &&
+												strings.Contains(*issueComment.Body, arbitraryStatusTargetURL)
+										})).
+									Return(emptyResult, emptyResponse, noError)
+
+								handle()
+								Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							})
+
+							Context("with notifying the author failing", func() {
+								BeforeEach(func() {
+									issues.
+										On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+										Return(emptyResponse, noError)
+									issues.
+										On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.Anything).
+										Return(emptyResult, emptyResponse, errArbitrary)
+								})
+
+								It("fails with a gateway error", func() {
+									handle()
+									Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+								})
+							})
+						})
+					})
 				})
 			})
 		}
@@ -90,7 +266,7 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 				})
 
 				mockSearchQuery := func(pageNr int) *mock.Call {
-					searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s status:success",
+					searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s",
 						mockSHA, grh.MergingLabel, repositoryOwner, repositoryName)
 					return search.
 						On("Issues", anyContext, searchQuery, mock.MatchedBy(func(searchOptions *github.SearchOptions) bool {
@@ -169,6 +345,26 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 						})
 					})
 
+					Context("with the PR no longer having the 'merging' label", func() {
+						BeforeEach(func() {
+							pullRequests.
+								On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+								Return(&github.PullRequest{
+									Number: github.Int(issueNumber),
+									Head: &github.PullRequestBranch{
+										SHA: github.String(mockSHA),
+									},
+								}, emptyResponse, noError)
+						})
+
+						It("doesn't merge the PR", func() {
+							handle()
+							Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							pullRequests.AssertNotCalled(GinkgoT(), "Merge", mock.Anything, mock.Anything,
+								mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+						})
+					})
+
 					Context("with GitHub API request for that PR succeeding", func() {
 						pr := &github.PullRequest{
 							Number: github.Int(issueNumber),
@@ -177,12 +373,16 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 								Repo: repository,
 							},
 							Head: &github.PullRequestBranch{
+								SHA:  github.String(mockSHA),
 								Ref:  github.String("feature"),
 								Repo: repository,
 							},
 							User: &github.User{
 								Login: github.String(userName),
 							},
+							Labels: []*github.Label{{
+								Name: github.String(grh.MergingLabel),
+							}},
 						}
 
 						BeforeEach(func() {
@@ -191,7 +391,82 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 								Return(pr, emptyResponse, noError)
 						})
 
-						ItMergesPR(context, pr)
+						Context("with the PR's statuses still pending", func() {
+							BeforeEach(func() {
+								repositories.
+									On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListOptions")).
+									Return(&github.CombinedStatus{
+										State: github.String("pending"),
+										Statuses: []*github.RepoStatus{{
+											Context: github.String("ci/other"),
+											State:   github.String("pending"),
+										}},
+									}, emptyResponse, noError)
+							})
+
+							It("doesn't merge the PR", func() {
+								handle()
+								Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+							})
+						})
+
+						Context("with the PR's statuses being successful", func() {
+							BeforeEach(func() {
+								repositories.
+									On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListOptions")).
+									Return(&github.CombinedStatus{
+										State: github.String("success"),
+										Statuses: []*github.RepoStatus{{
+											Context: github.String("ci/jenkins"),
+											State:   github.String("success"),
+										}},
+									}, emptyResponse, noError)
+							})
+
+							Context("with a check run still in progress", func() {
+								BeforeEach(func() {
+									checks.
+										On("ListCheckRunsForRef", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListCheckRunsOptions")).
+										Return(&github.ListCheckRunsResults{
+											Total: github.Int(1),
+											CheckRuns: []*github.CheckRun{{
+												Name:   github.String("build"),
+												Status: github.String("in_progress"),
+											}},
+										}, &github.Response{}, noError)
+								})
+
+								It("doesn't merge the PR", func() {
+									handle()
+									Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+								})
+							})
+
+							Context("with all check runs completed successfully or neutrally", func() {
+								BeforeEach(func() {
+									checks.
+										On("ListCheckRunsForRef", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListCheckRunsOptions")).
+										Return(&github.ListCheckRunsResults{
+											Total: github.Int(3),
+											CheckRuns: []*github.CheckRun{{
+												Name:       github.String("build"),
+												Status:     github.String("completed"),
+												Conclusion: github.String("success"),
+											}, {
+												Name:       github.String("lint"),
+												Status:     github.String("completed"),
+												Conclusion: github.String("neutral"),
+											}, {
+												Name:       github.String("deploy"),
+												Status:     github.String("completed"),
+												Conclusion: github.String("skipped"),
+											}},
+										}, &github.Response{}, noError)
+								})
+
+								ItMergesPR(context, pr)
+							})
+						})
 					})
 				})
 
@@ -211,17 +486,28 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 								Repo: repository,
 							},
 							Head: &github.PullRequestBranch{
+								SHA:  github.String(mockSHA),
 								Ref:  github.String(headRef),
 								Repo: repository,
 							},
 							User: &github.User{
 								Login: github.String(author),
 							},
+							Labels: []*github.Label{{
+								Name: github.String(grh.MergingLabel),
+							}},
 						}
 						pullRequests.
 							On("Get", anyContext, repositoryOwner, repositoryName, number).
 							Return(pr, emptyResponse, noError).
 							Once()
+						// Check statuses
+						repositories.
+							On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, mockSHA, mock.AnythingOfType("*github.ListOptions")).
+							Return(&github.CombinedStatus{
+								State: github.String("success"),
+							}, emptyResponse, noError).
+							Once()
 						// Merge
 						additionalCommitMessage := ""
 						pullRequests.
@@ -48,8 +48,24 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 		})
 
 		ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+			Context("with fetching the PR failing", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(emptyResult, emptyResponse, errors.New("an error"))
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+
 			Context("with github request to add the label failing", func() {
 				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(&github.PullRequest{}, emptyResponse, noError)
 					issues.
 						On("AddLabelsToIssue", anyContext, repositoryOwner, repositoryName, issueNumber, []string{grh.MergingLabel}).
 						Return(emptyResult, emptyResponse, errors.New("an error"))
@@ -68,19 +84,6 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 						Return(emptyResult, emptyResponse, noError)
 				})
 
-				Context("with fetching the PR failing", func() {
-					BeforeEach(func() {
-						pullRequests.
-							On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
-							Return(emptyResult, emptyResponse, errors.New("an error"))
-					})
-
-					It("fails with a gateway error", func() {
-						handle()
-						Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
-					})
-				})
-
 				Context("with the PR being already merged", func() {
 					BeforeEach(func() {
 						pullRequests.
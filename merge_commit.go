@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/google/go-github/v84/github"
+)
+
+var (
+	// linkedIssuePattern matches the closing keywords GitHub recognizes in PR
+	// descriptions, e.g. "Fixes #12" or "closes salemove/other#3".
+	linkedIssuePattern = regexp.MustCompile(
+		`(?i)\b(?:close[sd]?|fix(?:e[sd])?|resolve[sd]?)\s*:?\s+((?:[\w.-]+/[\w.-]+)?#\d+)\b`,
+	)
+
+	mergeTemplateFuncs = template.FuncMap{
+		"join": strings.Join,
+	}
+)
+
+// mergeOptions describe how a PR should be merged. Empty Title and Message
+// make GitHub use its default commit title and message.
+type mergeOptions struct {
+	Method  string
+	Title   string
+	Message string
+}
+
+// mergeCommitData is the data that the merge commit title and message
+// templates are executed with.
+type mergeCommitData struct {
+	Title        string
+	Number       int
+	Author       string
+	Approvers    []string
+	LinkedIssues []string
+}
+
+func parseMergeTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Funcs(mergeTemplateFuncs).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	// Referring to unknown fields only fails when the template is executed,
+	// so execute it once to catch these mistakes early.
+	if err = tmpl.Execute(&bytes.Buffer{}, mergeCommitData{}); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// getMergeOptions renders the merge commit title and message templates of
+// the repository for the PR. Reviews are only fetched if there are templates
+// to render.
+func getMergeOptions(pr *github.PullRequest, method string, repoConfig RepoConfig,
+	pullRequests PullRequests) (mergeOptions, *ErrorResponse) {
+
+	options := mergeOptions{Method: method}
+	if repoConfig.MergeCommitTitle == nil && repoConfig.MergeCommitMessage == nil {
+		return options, nil
+	}
+	issue := prIssue(pr)
+	reviews, errResp := getReviews(issue, pullRequests)
+	if errResp != nil {
+		return mergeOptions{}, errResp
+	}
+	data := mergeCommitData{
+		Title:        pr.GetTitle(),
+		Number:       issue.Number,
+		Author:       issue.User.Login,
+		Approvers:    approvers(reviews, issue.User),
+		LinkedIssues: linkedIssues(pr.GetBody()),
+	}
+	var err error
+	if options.Title, err = executeMergeTemplate(repoConfig.MergeCommitTitle, data); err != nil {
+		message := fmt.Sprintf("Failed to render the merge commit title for PR %s", issue.FullName())
+		return mergeOptions{}, &ErrorResponse{err, http.StatusInternalServerError, message}
+	}
+	if options.Message, err = executeMergeTemplate(repoConfig.MergeCommitMessage, data); err != nil {
+		message := fmt.Sprintf("Failed to render the merge commit message for PR %s", issue.FullName())
+		return mergeOptions{}, &ErrorResponse{err, http.StatusInternalServerError, message}
+	}
+	return options, nil
+}
+
+func executeMergeTemplate(tmpl *template.Template, data mergeCommitData) (string, error) {
+	if tmpl == nil {
+		return "", nil
+	}
+	var buffer bytes.Buffer
+	if err := tmpl.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buffer.String()), nil
+}
+
+// approvers returns the logins of the reviewers whose latest approving,
+// change requesting or dismissed review is an approval. Unlike for the
+// review/peer status, approvals for older commits are also included, because
+// squashing changes the head commit.
+func approvers(reviews []*github.PullRequestReview, author User) []string {
+	approverLogins := []string{}
+	for _, review := range latestReviews(reviews, author.Login) {
+		if review.GetState() == reviewStateApproved {
+			approverLogins = append(approverLogins, review.GetUser().GetLogin())
+		}
+	}
+	return approverLogins
+}
+
+// linkedIssues returns the issue references, e.g. "#12", that the PR's
+// description closes.
+func linkedIssues(body string) []string {
+	issueRefs := []string{}
+	for _, match := range linkedIssuePattern.FindAllStringSubmatch(body, -1) {
+		if !contains(issueRefs, match[1]) {
+			issueRefs = append(issueRefs, match[1])
+		}
+	}
+	return issueRefs
+}
+
+// requestedMergeMethods remembers the merge methods requested with !merge
+// commands until the PRs get merged. The PRs are not necessarily merged
+// immediately, but only after all of their statuses have succeeded. If a
+// file is configured, the methods are persisted in it, so that they're
+// remembered across restarts.
+type requestedMergeMethods struct {
+	mutex   sync.Mutex
+	methods map[string]string
+	path    string
+}
+
+func newRequestedMergeMethods(path string) (*requestedMergeMethods, error) {
+	r := &requestedMergeMethods{methods: map[string]string{}, path: path}
+	if path == "" {
+		return r, nil
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read the requested merge methods from %s: %v", path, err)
+	}
+	if err := json.Unmarshal(contents, &r.methods); err != nil {
+		return nil, fmt.Errorf("failed to parse the requested merge methods in %s: %v", path, err)
+	}
+	return r, nil
+}
+
+func (r *requestedMergeMethods) request(issue Issue, method string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.methods[issue.FullName()] = method
+	return r.persist()
+}
+
+// forIssue returns the merge method requested for the PR or the given
+// default, if no method has been explicitly requested.
+func (r *requestedMergeMethods) forIssue(issue Issue, defaultMethod string) string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if method, ok := r.methods[issue.FullName()]; ok {
+		return method
+	}
+	return defaultMethod
+}
+
+func (r *requestedMergeMethods) clear(issue Issue) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if _, ok := r.methods[issue.FullName()]; !ok {
+		return
+	}
+	delete(r.methods, issue.FullName())
+	if err := r.persist(); err != nil {
+		log.Printf("Failed to clear the requested merge method of PR %s: %v\n", issue.FullName(), err)
+	}
+}
+
+// persist replaces the file with the current methods. It has to be called
+// with the mutex held.
+func (r *requestedMergeMethods) persist() error {
+	if r.path == "" {
+		return nil
+	}
+	contents, err := json.Marshal(r.methods)
+	if err != nil {
+		return fmt.Errorf("failed to serialize the requested merge methods: %v", err)
+	}
+	if err := writeFileSynced(r.path, contents); err != nil {
+		return fmt.Errorf("failed to store the requested merge methods: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+package main_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("!merge comment merge options", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+
+			issueAuthor = "procoder"
+			headSHA     = "1235"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "issue_comment",
+			}
+		})
+
+		// The PR is across forks to keep the bot from deleting the head
+		// branch after merging.
+		pr := &github.PullRequest{
+			Number:    github.Int(issueNumber),
+			Title:     github.String("Add a feature"),
+			Body:      github.String("This adds a feature.\n\nFixes #12, closes salemove/other#3"),
+			Merged:    github.Bool(false),
+			Mergeable: github.Bool(true),
+			Base: &github.PullRequestBranch{
+				SHA:  github.String("1234"),
+				Ref:  github.String("master"),
+				Repo: repository,
+			},
+			Head: &github.PullRequestBranch{
+				SHA: github.String(headSHA),
+				Ref: github.String("feature"),
+				Repo: &github.Repository{
+					ID: github.Int64(repositoryID + 1),
+					Owner: &github.User{
+						Login: github.String("other"),
+					},
+					Name:   github.String(repositoryName),
+					SSHURL: github.String("git@github.com:other/github-review-helper.git"),
+				},
+			},
+			User: &github.User{
+				Login: github.String(issueAuthor),
+			},
+		}
+
+		mockConfigFile := func(content string) {
+			repositories.
+				On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+				Return(&github.RepositoryContent{
+					Content: github.String(content),
+				}, emptyResult, emptyResponse, noError)
+		}
+		mockMergeablePR := func() {
+			issues.
+				On("AddLabelsToIssue", anyContext, repositoryOwner, repositoryName, issueNumber, []string{grh.MergingLabel}).
+				Return(emptyResult, emptyResponse, noError)
+			pullRequests.
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(pr, emptyResponse, noError)
+			repositories.
+				On("GetCombinedStatus", anyContext, "other", repositoryName, headSHA, mock.AnythingOfType("*github.ListOptions")).
+				Return(&github.CombinedStatus{
+					State: github.String("success"),
+				}, emptyResponse, noError)
+		}
+		expectMerge := func(commitMessage string, opts *github.PullRequestOptions) {
+			pullRequests.
+				On("Merge", anyContext, repositoryOwner, repositoryName, issueNumber, commitMessage, opts).
+				Return(&github.PullRequestMergeResult{
+					Merged: github.Bool(true),
+				}, emptyResponse, noError).
+				Once()
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError)
+		}
+
+		Context("with a merge method argument", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge rebase", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+				BeforeEach(mockMergeablePR)
+
+				It("merges the PR with the given merge method", func() {
+					expectMerge("", &github.PullRequestOptions{MergeMethod: "rebase"})
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		Context("with the merge method on the command's line followed by other lines", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge squash\nThanks, rebase it next time!", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+				BeforeEach(mockMergeablePR)
+
+				It("merges the PR with the given merge method", func() {
+					expectMerge("", &github.PullRequestOptions{MergeMethod: "squash"})
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		for _, comment := range []string{"!merge rebse", "!merge\nrebase it next time!"} {
+			comment := comment
+
+			Context(fmt.Sprintf("with the %q comment", comment), func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent(comment, issueAuthor)
+				})
+
+				ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+					BeforeEach(mockMergeablePR)
+
+					It("merges the PR with the default merge method", func() {
+						expectMerge("", &github.PullRequestOptions{MergeMethod: "merge"})
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+		}
+
+		Context("with the PR waiting for its statuses across a restart", func() {
+			var (
+				conf            *grh.Config
+				mergeMethodsDir string
+			)
+			BeforeEach(func() {
+				conf = context.Config
+				var err error
+				mergeMethodsDir, err = os.MkdirTemp("", "merge-methods-test")
+				Expect(err).NotTo(HaveOccurred())
+				conf.MergeMethodsFile = filepath.Join(mergeMethodsDir, "merge-methods.json")
+			})
+			AfterEach(func() {
+				os.RemoveAll(mergeMethodsDir)
+			})
+
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge rebase", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+				BeforeEach(func() {
+					issues.
+						On("AddLabelsToIssue", anyContext, repositoryOwner, repositoryName, issueNumber, []string{grh.MergingLabel}).
+						Return(emptyResult, emptyResponse, noError)
+					labeledPR := *pr
+					labeledPR.Labels = []*github.Label{{Name: github.String(grh.MergingLabel)}}
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(&labeledPR, emptyResponse, noError)
+					repositories.
+						On("GetCombinedStatus", anyContext, "other", repositoryName, headSHA, mock.AnythingOfType("*github.ListOptions")).
+						Return(&github.CombinedStatus{
+							State: github.String("pending"),
+							Statuses: []*github.RepoStatus{{
+								Context: github.String("ci"),
+								State:   github.String("pending"),
+							}},
+						}, emptyResponse, noError).
+						Once()
+				})
+
+				It("merges the PR with the requested merge method after the restart", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+
+					repositories.
+						On("GetCombinedStatus", anyContext, "other", repositoryName, headSHA, mock.AnythingOfType("*github.ListOptions")).
+						Return(&github.CombinedStatus{
+							State: github.String("success"),
+						}, emptyResponse, noError)
+					search := *context.Search
+					searchQuery := fmt.Sprintf("%s label:\"%s\" is:open repo:%s/%s", headSHA, grh.MergingLabel,
+						repositoryOwner, repositoryName)
+					search.
+						On("Issues", anyContext, searchQuery, mock.AnythingOfType("*github.SearchOptions")).
+						Return(&github.IssuesSearchResult{
+							Total: github.Int(1),
+							Issues: []*github.Issue{{
+								Number: github.Int(issueNumber),
+								User:   &github.User{Login: github.String(issueAuthor)},
+							}},
+						}, &github.Response{}, noError)
+					expectMerge("", &github.PullRequestOptions{MergeMethod: "rebase"})
+
+					asyncOperationWg := &sync.WaitGroup{}
+					handler := grh.CreateHandler(*conf, *context.GitRepos, asyncOperationWg, nil, pullRequests,
+						repositories, issues, search, *context.Checks)
+					statusEvent := createStatusEvent(headSHA, "success", []grh.Branch{{SHA: headSHA}})
+					statusRequest, err := http.NewRequest("POST", "http://localhost/whatever", strings.NewReader(statusEvent))
+					Expect(err).NotTo(HaveOccurred())
+					statusRequest.Header.Set("X-Github-Event", "status")
+					statusRequest.Header.Set("X-Hub-Signature-256", Signature256(conf.Secret, statusEvent))
+					handler(httptest.NewRecorder(), statusRequest)
+					asyncOperationWg.Wait()
+
+					pullRequests.AssertNumberOfCalls(GinkgoT(), "Merge", 1)
+				})
+			})
+		})
+
+		Context("with a merge method configured for the repository", func() {
+			// The configuration is only loaded for the commands of
+			// collaborators
+			mockConfig := func() {
+				mockConfigFile("merge_method: squash\n")
+			}
+
+			Context("without a merge method argument", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!merge", issueAuthor)
+				})
+
+				ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+					BeforeEach(mockConfig)
+					BeforeEach(mockMergeablePR)
+
+					It("merges the PR with the configured merge method", func() {
+						expectMerge("", &github.PullRequestOptions{MergeMethod: "squash"})
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+
+			Context("with a merge method argument", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!merge merge", issueAuthor)
+				})
+
+				ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+					BeforeEach(mockConfig)
+					BeforeEach(mockMergeablePR)
+
+					It("merges the PR with the given merge method", func() {
+						expectMerge("", &github.PullRequestOptions{MergeMethod: "merge"})
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+		})
+
+		Context("with merge commit templates configured for the repository", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge", issueAuthor)
+			})
+
+			ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+				BeforeEach(func() {
+					mockConfigFile(`merge_method: squash
+merge_commit_title: "{{.Title}} (#{{.Number}})"
+merge_commit_message: |
+  Author: {{.Author}}
+  Approved-by: {{join .Approvers ", "}}
+  {{range .LinkedIssues}}Closes {{.}}
+  {{end}}
+`)
+				})
+				BeforeEach(mockMergeablePR)
+
+				It("renders the merge commit title and message", func() {
+					pullRequests.
+						On("ListReviews", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return([]*github.PullRequestReview{
+							{User: &github.User{Login: github.String("alice")}, State: github.String("APPROVED")},
+							{User: &github.User{Login: github.String("bob")}, State: github.String("COMMENTED")},
+							{User: &github.User{Login: github.String("carol")}, State: github.String("APPROVED")},
+						}, &github.Response{}, noError)
+					expectMerge(
+						"Author: procoder\nApproved-by: alice, carol\nCloses #12\nCloses salemove/other#3",
+						&github.PullRequestOptions{
+							MergeMethod: "squash",
+							CommitTitle: "Add a feature (#7)",
+						},
+					)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+
+		Context("with a merge commit template referring to an unknown field", func() {
+			requestJSON.Is(func() string {
+				return IssueCommentEvent("!merge", issueAuthor)
+			})
+
+			BeforeEach(func() {
+				mockConfigFile("merge_commit_title: \"{{.Subject}}\"\n")
+				repositories.
+					On("IsCollaborator", anyContext, repositoryOwner, repositoryName, issueAuthor).
+					Return(true, emptyResponse, noError)
+				pullRequests.
+					On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+					Return(pr, emptyResponse, noError)
+			})
+
+			It("fails with an internal error", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Invalid .github/review-helper.yml"))
+			})
+		})
+	})
+})
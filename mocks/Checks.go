@@ -0,0 +1,42 @@
+package mocks
+
+import "github.com/stretchr/testify/mock"
+
+import "context"
+
+import "github.com/google/go-github/v84/github"
+
+type Checks struct {
+	mock.Mock
+}
+
+func (_m *Checks) ListCheckRunsForRef(ctx context.Context, owner string, repo string, ref string, opts *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error) {
+	ret := _m.Called(ctx, owner, repo, ref, opts)
+
+	var r0 *github.ListCheckRunsResults
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, *github.ListCheckRunsOptions) *github.ListCheckRunsResults); ok {
+		r0 = rf(ctx, owner, repo, ref, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*github.ListCheckRunsResults)
+		}
+	}
+
+	var r1 *github.Response
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, *github.ListCheckRunsOptions) *github.Response); ok {
+		r1 = rf(ctx, owner, repo, ref, opts)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*github.Response)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, string, *github.ListCheckRunsOptions) error); ok {
+		r2 = rf(ctx, owner, repo, ref, opts)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
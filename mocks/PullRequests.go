@@ -100,3 +100,33 @@ func (_m *PullRequests) Merge(ctx context.Context, owner string, repo string, nu
 
 	return r0, r1, r2
 }
+func (_m *PullRequests) ListReviews(ctx context.Context, owner string, repo string, number int, opt *github.ListOptions) ([]*github.PullRequestReview, *github.Response, error) {
+	ret := _m.Called(ctx, owner, repo, number, opt)
+
+	var r0 []*github.PullRequestReview
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, int, *github.ListOptions) []*github.PullRequestReview); ok {
+		r0 = rf(ctx, owner, repo, number, opt)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*github.PullRequestReview)
+		}
+	}
+
+	var r1 *github.Response
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, int, *github.ListOptions) *github.Response); ok {
+		r1 = rf(ctx, owner, repo, number, opt)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*github.Response)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, int, *github.ListOptions) error); ok {
+		r2 = rf(ctx, owner, repo, number, opt)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
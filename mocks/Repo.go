@@ -2,6 +2,8 @@ package mocks
 
 import "github.com/stretchr/testify/mock"
 
+import "github.com/salemove/github-review-helper/git"
+
 type Repo struct {
 	mock.Mock
 }
@@ -18,12 +20,81 @@ func (_m *Repo) Fetch() error {
 
 	return r0
 }
-func (_m *Repo) AutosquashAndPush(upstreamRef string, branchRef string, destinationRef string) error {
-	ret := _m.Called(upstreamRef, branchRef, destinationRef)
+func (_m *Repo) AutosquashAndPush(upstreamRef string, branchRef string, destinationRef string, backupPrefix string, addCoAuthors bool) (string, error) {
+	ret := _m.Called(upstreamRef, branchRef, destinationRef, backupPrefix, addCoAuthors)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, string, string, bool) string); ok {
+		r0 = rf(upstreamRef, branchRef, destinationRef, backupPrefix, addCoAuthors)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string, string, bool) error); ok {
+		r1 = rf(upstreamRef, branchRef, destinationRef, backupPrefix, addCoAuthors)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *Repo) RebaseAndPush(upstreamRef string, branchRef string, destinationRef string, backupPrefix string, autosquash bool) error {
+	ret := _m.Called(upstreamRef, branchRef, destinationRef, backupPrefix, autosquash)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string, string, bool) error); ok {
+		r0 = rf(upstreamRef, branchRef, destinationRef, backupPrefix, autosquash)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+func (_m *Repo) RestoreLatestBackup(backupPrefix string, destinationRef string) (string, error) {
+	ret := _m.Called(backupPrefix, destinationRef)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string) string); ok {
+		r0 = rf(backupPrefix, destinationRef)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string) error); ok {
+		r1 = rf(backupPrefix, destinationRef)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *Repo) RangeDiff(upstreamRef string, oldRef string, newRef string) (string, error) {
+	ret := _m.Called(upstreamRef, oldRef, newRef)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, string) string); ok {
+		r0 = rf(upstreamRef, oldRef, newRef)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, string) error); ok {
+		r1 = rf(upstreamRef, oldRef, newRef)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+func (_m *Repo) RunOnEachCommit(upstreamRef string, branchRef string, check git.CommitCheck) error {
+	ret := _m.Called(upstreamRef, branchRef, check)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
-		r0 = rf(upstreamRef, branchRef, destinationRef)
+	if rf, ok := ret.Get(0).(func(string, string, git.CommitCheck) error); ok {
+		r0 = rf(upstreamRef, branchRef, check)
 	} else {
 		r0 = ret.Error(0)
 	}
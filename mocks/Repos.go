@@ -26,3 +26,15 @@ func (_m *Repos) GetUpdatedRepo(url string, repoOwner string, repoName string) (
 
 	return r0, r1
 }
+func (_m *Repos) DeleteBackups(url string, backupPrefix string) error {
+	ret := _m.Called(url, backupPrefix)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(url, backupPrefix)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
@@ -98,3 +98,72 @@ func (_m *Repositories) IsCollaborator(ctx context.Context, owner string, repo s
 
 	return r0, r1, r2
 }
+func (_m *Repositories) GetContents(ctx context.Context, owner string, repo string, path string, opts *github.RepositoryContentGetOptions) (*github.RepositoryContent, []*github.RepositoryContent, *github.Response, error) {
+	ret := _m.Called(ctx, owner, repo, path, opts)
+
+	var r0 *github.RepositoryContent
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, *github.RepositoryContentGetOptions) *github.RepositoryContent); ok {
+		r0 = rf(ctx, owner, repo, path, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*github.RepositoryContent)
+		}
+	}
+
+	var r1 []*github.RepositoryContent
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, *github.RepositoryContentGetOptions) []*github.RepositoryContent); ok {
+		r1 = rf(ctx, owner, repo, path, opts)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).([]*github.RepositoryContent)
+		}
+	}
+
+	var r2 *github.Response
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, string, *github.RepositoryContentGetOptions) *github.Response); ok {
+		r2 = rf(ctx, owner, repo, path, opts)
+	} else {
+		if ret.Get(2) != nil {
+			r2 = ret.Get(2).(*github.Response)
+		}
+	}
+
+	var r3 error
+	if rf, ok := ret.Get(3).(func(context.Context, string, string, string, *github.RepositoryContentGetOptions) error); ok {
+		r3 = rf(ctx, owner, repo, path, opts)
+	} else {
+		r3 = ret.Error(3)
+	}
+
+	return r0, r1, r2, r3
+}
+func (_m *Repositories) GetCommit(ctx context.Context, owner string, repo string, sha string, opts *github.ListOptions) (*github.RepositoryCommit, *github.Response, error) {
+	ret := _m.Called(ctx, owner, repo, sha, opts)
+
+	var r0 *github.RepositoryCommit
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, string, *github.ListOptions) *github.RepositoryCommit); ok {
+		r0 = rf(ctx, owner, repo, sha, opts)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*github.RepositoryCommit)
+		}
+	}
+
+	var r1 *github.Response
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, string, *github.ListOptions) *github.Response); ok {
+		r1 = rf(ctx, owner, repo, sha, opts)
+	} else {
+		if ret.Get(1) != nil {
+			r1 = ret.Get(1).(*github.Response)
+		}
+	}
+
+	var r2 error
+	if rf, ok := ret.Get(2).(func(context.Context, string, string, string, *github.ListOptions) error); ok {
+		r2 = rf(ctx, owner, repo, sha, opts)
+	} else {
+		r2 = ret.Error(2)
+	}
+
+	return r0, r1, r2
+}
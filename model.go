@@ -28,21 +28,46 @@ type (
 		IssueNumber int
 		Action      string
 		Head        PullRequestBranch
-		Repository  Repository
-		User        User
+		// BaseRef is the name of the branch the PR is merged into
+		BaseRef    string
+		Repository Repository
+		User       User
 	}
 
 	StatusEvent struct {
+		SHA         string
+		State       string
+		Context     string
+		Description string
+		TargetURL   string
+		Branches    []Branch
+		Repository  Repository
+	}
+
+	// CheckEvent represents both check_run and check_suite events. For
+	// check suites, Name is the name of the app that created the suite.
+	CheckEvent struct {
+		// Kind is checkRunKind or checkSuiteKind
+		Kind       string
+		Action     string
 		SHA        string
-		State      string
-		Branches   []Branch
+		Conclusion string
+		Name       string
+		Summary    string
+		TargetURL  string
+		Repository Repository
+	}
+
+	PushEvent struct {
+		Ref        string
 		Repository Repository
 	}
 
 	Repository struct {
-		Owner string
-		Name  string
-		URL   string
+		Owner         string
+		Name          string
+		URL           string
+		DefaultBranch string
 	}
 
 	PullRequestBranch struct {
@@ -108,8 +133,9 @@ func headRepository(pr *github.PullRequest) Repository {
 
 func repositoryInternalRepresentation(repo *github.Repository) Repository {
 	return Repository{
-		Owner: *repo.Owner.Login,
-		Name:  *repo.Name,
-		URL:   *repo.SSHURL,
+		Owner:         *repo.Owner.Login,
+		Name:          *repo.Name,
+		URL:           *repo.SSHURL,
+		DefaultBranch: repo.GetDefaultBranch(),
 	}
 }
@@ -7,7 +7,17 @@ type messageRepository struct {
 	Owner struct {
 		Login string `json:"login"`
 	} `json:"owner"`
-	SSHURL string `json:"ssh_url"`
+	SSHURL        string `json:"ssh_url"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (r messageRepository) internalRepresentation() Repository {
+	return Repository{
+		Owner:         r.Owner.Login,
+		Name:          r.Name,
+		URL:           r.SSHURL,
+		DefaultBranch: r.DefaultBranch,
+	}
 }
 
 func parseIssueComment(body []byte) (IssueComment, error) {
@@ -34,11 +44,7 @@ func parseIssueComment(body []byte) (IssueComment, error) {
 		IssueNumber:   message.Issue.Number,
 		Comment:       message.Comment.Body,
 		IsPullRequest: message.Issue.PullRequest.URL != "",
-		Repository: Repository{
-			Owner: message.Repository.Owner.Login,
-			Name:  message.Repository.Name,
-			URL:   message.Repository.SSHURL,
-		},
+		Repository:    message.Repository.internalRepresentation(),
 		User: User{
 			Login: message.Issue.User.Login,
 		},
@@ -54,6 +60,9 @@ func parsePullRequestEvent(body []byte) (PullRequestEvent, error) {
 				SHA        string            `json:"sha"`
 				Repository messageRepository `json:"repo"`
 			} `json:"head"`
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
 			User struct {
 				Login string `json:"login"`
 			} `json:"user"`
@@ -68,18 +77,51 @@ func parsePullRequestEvent(body []byte) (PullRequestEvent, error) {
 		IssueNumber: message.Number,
 		Action:      message.Action,
 		Head: PullRequestBranch{
-			SHA: message.PullRequest.Head.SHA,
-			Repository: Repository{
-				Owner: message.PullRequest.Head.Repository.Owner.Login,
-				Name:  message.PullRequest.Head.Repository.Name,
-				URL:   message.PullRequest.Head.Repository.SSHURL,
-			},
+			SHA:        message.PullRequest.Head.SHA,
+			Repository: message.PullRequest.Head.Repository.internalRepresentation(),
 		},
-		Repository: Repository{
-			Owner: message.Repository.Owner.Login,
-			Name:  message.Repository.Name,
-			URL:   message.Repository.SSHURL,
+		BaseRef:    message.PullRequest.Base.Ref,
+		Repository: message.Repository.internalRepresentation(),
+		User: User{
+			Login: message.PullRequest.User.Login,
 		},
+	}, nil
+}
+
+// parsePullRequestReviewEvent parses a pull_request_review event into a
+// PullRequestEvent, because the parts of the event that the bot cares about
+// are the same for both events.
+func parsePullRequestReviewEvent(body []byte) (PullRequestEvent, error) {
+	var message struct {
+		Action      string `json:"action"`
+		PullRequest struct {
+			Number int `json:"number"`
+			Head   struct {
+				SHA        string            `json:"sha"`
+				Repository messageRepository `json:"repo"`
+			} `json:"head"`
+			Base struct {
+				Ref string `json:"ref"`
+			} `json:"base"`
+			User struct {
+				Login string `json:"login"`
+			} `json:"user"`
+		} `json:"pull_request"`
+		Repository messageRepository `json:"repository"`
+	}
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return PullRequestEvent{}, err
+	}
+	return PullRequestEvent{
+		IssueNumber: message.PullRequest.Number,
+		Action:      message.Action,
+		Head: PullRequestBranch{
+			SHA:        message.PullRequest.Head.SHA,
+			Repository: message.PullRequest.Head.Repository.internalRepresentation(),
+		},
+		BaseRef:    message.PullRequest.Base.Ref,
+		Repository: message.Repository.internalRepresentation(),
 		User: User{
 			Login: message.PullRequest.User.Login,
 		},
@@ -88,9 +130,12 @@ func parsePullRequestEvent(body []byte) (PullRequestEvent, error) {
 
 func parseStatusEvent(body []byte) (StatusEvent, error) {
 	var message struct {
-		SHA      string `json:"sha"`
-		State    string `json:"state"`
-		Branches []struct {
+		SHA         string `json:"sha"`
+		State       string `json:"state"`
+		Context     string `json:"context"`
+		Description string `json:"description"`
+		TargetURL   string `json:"target_url"`
+		Branches    []struct {
 			Commit struct {
 				SHA string `json:"sha"`
 			} `json:"commit"`
@@ -110,13 +155,135 @@ func parseStatusEvent(body []byte) (StatusEvent, error) {
 		}
 	}
 	return StatusEvent{
-		SHA:      message.SHA,
-		State:    message.State,
-		Branches: branches,
-		Repository: Repository{
-			Owner: message.Repository.Owner.Login,
-			Name:  message.Repository.Name,
-			URL:   message.Repository.SSHURL,
-		},
+		SHA:         message.SHA,
+		State:       message.State,
+		Context:     message.Context,
+		Description: message.Description,
+		TargetURL:   message.TargetURL,
+		Branches:    branches,
+		Repository:  message.Repository.internalRepresentation(),
+	}, nil
+}
+
+func parseCheckRunEvent(body []byte) (CheckEvent, error) {
+	var message struct {
+		Action   string `json:"action"`
+		CheckRun struct {
+			HeadSHA    string `json:"head_sha"`
+			Conclusion string `json:"conclusion"`
+			Name       string `json:"name"`
+			DetailsURL string `json:"details_url"`
+			Output     struct {
+				Title string `json:"title"`
+			} `json:"output"`
+		} `json:"check_run"`
+		Repository messageRepository `json:"repository"`
+	}
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return CheckEvent{}, err
+	}
+	return CheckEvent{
+		Kind:       checkRunKind,
+		Action:     message.Action,
+		SHA:        message.CheckRun.HeadSHA,
+		Conclusion: message.CheckRun.Conclusion,
+		Name:       message.CheckRun.Name,
+		Summary:    message.CheckRun.Output.Title,
+		TargetURL:  message.CheckRun.DetailsURL,
+		Repository: message.Repository.internalRepresentation(),
+	}, nil
+}
+
+func parseCheckSuiteEvent(body []byte) (CheckEvent, error) {
+	var message struct {
+		Action     string `json:"action"`
+		CheckSuite struct {
+			HeadSHA    string `json:"head_sha"`
+			Conclusion string `json:"conclusion"`
+			App        struct {
+				Name string `json:"name"`
+			} `json:"app"`
+		} `json:"check_suite"`
+		Repository messageRepository `json:"repository"`
+	}
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return CheckEvent{}, err
+	}
+	return CheckEvent{
+		Kind:       checkSuiteKind,
+		Action:     message.Action,
+		SHA:        message.CheckSuite.HeadSHA,
+		Conclusion: message.CheckSuite.Conclusion,
+		Name:       message.CheckSuite.App.Name,
+		Repository: message.Repository.internalRepresentation(),
 	}, nil
 }
+
+func parsePushEvent(body []byte) (PushEvent, error) {
+	var message struct {
+		Ref        string            `json:"ref"`
+		Repository messageRepository `json:"repository"`
+	}
+	err := json.Unmarshal(body, &message)
+	if err != nil {
+		return PushEvent{}, err
+	}
+	return PushEvent{
+		Ref:        message.Ref,
+		Repository: message.Repository.internalRepresentation(),
+	}, nil
+}
+
+// parseOrderingKey parses the PR or the issue that the event is about. The
+// status and check events aren't about a single PR, so they're keyed by the
+// commit instead, and the rest of the events by the repository. The events
+// keyed by a commit aren't ordered with the events of the PRs that the
+// commit is in.
+func parseOrderingKey(body []byte) (string, error) {
+	var message struct {
+		Number int `json:"number"`
+		Issue  struct {
+			Number int `json:"number"`
+		} `json:"issue"`
+		PullRequest struct {
+			Number int `json:"number"`
+		} `json:"pull_request"`
+		SHA      string `json:"sha"`
+		CheckRun struct {
+			HeadSHA string `json:"head_sha"`
+		} `json:"check_run"`
+		CheckSuite struct {
+			HeadSHA string `json:"head_sha"`
+		} `json:"check_suite"`
+		Repository messageRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return "", err
+	}
+	repository := message.Repository.internalRepresentation()
+	for _, number := range []int{message.Issue.Number, message.PullRequest.Number, message.Number} {
+		if number != 0 {
+			return Issue{Number: number, Repository: repository}.FullName(), nil
+		}
+	}
+	for _, sha := range []string{message.SHA, message.CheckRun.HeadSHA, message.CheckSuite.HeadSHA} {
+		if sha != "" {
+			return repository.Owner + "/" + repository.Name + "@" + sha, nil
+		}
+	}
+	return repository.Owner + "/" + repository.Name, nil
+}
+
+// parseEventRepository parses the repository that any event is about. The
+// repository is empty for events that aren't about a repository.
+func parseEventRepository(body []byte) (Repository, error) {
+	var message struct {
+		Repository messageRepository `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return Repository{}, err
+	}
+	return message.Repository.internalRepresentation(), nil
+}
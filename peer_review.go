@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+const (
+	reviewStateApproved         = "APPROVED"
+	reviewStateChangesRequested = "CHANGES_REQUESTED"
+	reviewStateDismissed        = "DISMISSED"
+)
+
+func isPeerReviewAction(action string) bool {
+	return action == "submitted" || action == "edited" || action == "dismissed"
+}
+
+func updatePeerReviewStatus(pullRequestEvent PullRequestEvent, requiredApprovals int,
+	pullRequests PullRequests, repositories Repositories) *ErrorResponse {
+
+	log.Printf("Checking reviews for PR %s.\n", pullRequestEvent.Issue().FullName())
+	reviews, errResp := getReviews(pullRequestEvent, pullRequests)
+	if errResp != nil {
+		return errResp
+	}
+	approvers, changeRequesters, errResp := tallyReviews(reviews, pullRequestEvent, repositories)
+	if errResp != nil {
+		return errResp
+	}
+	status := peerReviewStatus(approvers, changeRequesters, requiredApprovals)
+	return setStatusForPREvent(pullRequestEvent, status, repositories)
+}
+
+// tallyReviews returns the logins of collaborators who have approved the
+// current HEAD of the PR and of collaborators who have requested changes that
+// have not been dismissed or followed by an approval.
+func tallyReviews(reviews []*github.PullRequestReview, pullRequestEvent PullRequestEvent,
+	repositories Repositories) ([]string, []string, *ErrorResponse) {
+
+	approvers := []string{}
+	changeRequesters := []string{}
+	for _, review := range latestReviews(reviews, pullRequestEvent.User.Login) {
+		login := review.GetUser().GetLogin()
+		state := review.GetState()
+		isCurrentApproval := state == reviewStateApproved && review.GetCommitID() == pullRequestEvent.Head.SHA
+		if !isCurrentApproval && state != reviewStateChangesRequested {
+			continue
+		}
+		isCollab, err := isCollaborator(pullRequestEvent.Repository, User{Login: login}, repositories)
+		if err != nil {
+			message := fmt.Sprintf("Failed to check if reviewer %s is a collaborator", login)
+			return nil, nil, &ErrorResponse{err, http.StatusBadGateway, message}
+		} else if !isCollab {
+			continue
+		}
+		if isCurrentApproval {
+			approvers = append(approvers, login)
+		} else {
+			changeRequesters = append(changeRequesters, login)
+		}
+	}
+	return approvers, changeRequesters, nil
+}
+
+// latestReviews returns the latest approving, change requesting or dismissed
+// review of every reviewer other than the author, in the order the reviewers
+// first reviewed the PR.
+func latestReviews(reviews []*github.PullRequestReview, author string) []*github.PullRequestReview {
+	latestReviewByLogin := map[string]*github.PullRequestReview{}
+	reviewers := []string{}
+	for _, review := range reviews {
+		login := review.GetUser().GetLogin()
+		if login == "" || login == author {
+			continue
+		}
+		switch review.GetState() {
+		case reviewStateApproved, reviewStateChangesRequested, reviewStateDismissed:
+			if _, seen := latestReviewByLogin[login]; !seen {
+				reviewers = append(reviewers, login)
+			}
+			latestReviewByLogin[login] = review
+		}
+	}
+	latest := []*github.PullRequestReview{}
+	for _, login := range reviewers {
+		latest = append(latest, latestReviewByLogin[login])
+	}
+	return latest
+}
+
+func peerReviewStatus(approvers, changeRequesters []string, requiredApprovals int) *github.RepoStatus {
+	if len(changeRequesters) > 0 {
+		return createPeerReviewStatus("pending", "Changes requested by "+strings.Join(changeRequesters, ", "))
+	} else if len(approvers) < requiredApprovals {
+		return createPeerReviewStatus("pending", fmt.Sprintf(
+			"%d of %d required approvals",
+			len(approvers),
+			requiredApprovals,
+		))
+	}
+	return createPeerReviewStatus("success", "Approved by "+strings.Join(approvers, ", "))
+}
+
+func createPeerReviewStatus(state, description string) *github.RepoStatus {
+	return &github.RepoStatus{
+		State:       github.String(state),
+		Description: github.String(truncateStatusDescription(description)),
+		Context:     github.String(githubStatusPeerReviewContext),
+	}
+}
@@ -0,0 +1,210 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("pull_request_review event", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+		})
+
+		var pullRequestHeadSHA = "1235"
+		var oldHeadSHA = "1234"
+		var headRepository = grh.Repository{
+			Owner: "other",
+			Name:  "github-review-helper-fork",
+			URL:   "git@github.com:other/github-review-helper-fork.git",
+		}
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "pull_request_review",
+			}
+		})
+		requestJSON.Is(func() string {
+			return PullRequestReviewEvent("submitted", pullRequestHeadSHA, headRepository)
+		})
+
+		review := func(login, state, commitID string) *github.PullRequestReview {
+			return &github.PullRequestReview{
+				User:     &github.User{Login: github.String(login)},
+				State:    github.String(state),
+				CommitID: github.String(commitID),
+			}
+		}
+		mockReviews := func(reviews ...*github.PullRequestReview) {
+			pullRequests.
+				On("ListReviews", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+				Return(reviews, &github.Response{}, noError)
+		}
+		mockCollaborator := func(login string, isCollaborator bool) {
+			repositories.
+				On("IsCollaborator", anyContext, repositoryOwner, repositoryName, login).
+				Return(isCollaborator, emptyResponse, noError)
+		}
+		expectPeerStatus := func(state string) {
+			repositories.
+				On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+					mock.MatchedBy(func(status github.RepoStatus) bool {
+						return *status.State == state && *status.Context == "review/peer"
+					}),
+				).
+				Return(emptyResult, emptyResponse, noError)
+		}
+
+		Context("with peer review status disabled", func() {
+			It("ignores the event", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+			})
+		})
+
+		Context("with 2 approvals required", func() {
+			BeforeEach(func() {
+				context.Config.RequiredApprovals = 2
+			})
+
+			Context("with a comment being added to a review", func() {
+				requestJSON.Is(func() string {
+					return PullRequestReviewEvent("commented", pullRequestHeadSHA, headRepository)
+				})
+
+				It("ignores the event", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+
+			Context("with listing reviews failing", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListReviews", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(emptyResult, emptyResponse, errArbitrary)
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+
+			Context("with 2 collaborators having approved the current HEAD", func() {
+				BeforeEach(func() {
+					mockReviews(
+						review("alice", "APPROVED", pullRequestHeadSHA),
+						review("bob", "COMMENTED", pullRequestHeadSHA),
+						review("bob", "APPROVED", pullRequestHeadSHA),
+					)
+					mockCollaborator("alice", true)
+					mockCollaborator("bob", true)
+				})
+
+				It("reports success status to GitHub", func() {
+					expectPeerStatus("success")
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with one of the approvals being for an older commit", func() {
+				BeforeEach(func() {
+					mockReviews(
+						review("alice", "APPROVED", pullRequestHeadSHA),
+						review("bob", "APPROVED", oldHeadSHA),
+					)
+					mockCollaborator("alice", true)
+				})
+
+				It("reports pending status to GitHub", func() {
+					expectPeerStatus("pending")
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with one of the approvals being from a non-collaborator", func() {
+				BeforeEach(func() {
+					mockReviews(
+						review("alice", "APPROVED", pullRequestHeadSHA),
+						review("mallory", "APPROVED", pullRequestHeadSHA),
+					)
+					mockCollaborator("alice", true)
+					mockCollaborator("mallory", false)
+				})
+
+				It("reports pending status to GitHub", func() {
+					expectPeerStatus("pending")
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with changes requested on an older commit", func() {
+				BeforeEach(func() {
+					mockReviews(
+						review("carol", "CHANGES_REQUESTED", oldHeadSHA),
+						review("alice", "APPROVED", pullRequestHeadSHA),
+						review("bob", "APPROVED", pullRequestHeadSHA),
+					)
+					mockCollaborator("alice", true)
+					mockCollaborator("bob", true)
+					mockCollaborator("carol", true)
+				})
+
+				It("reports pending status to GitHub", func() {
+					expectPeerStatus("pending")
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with a dismissed change request", func() {
+				BeforeEach(func() {
+					mockReviews(
+						review("carol", "CHANGES_REQUESTED", oldHeadSHA),
+						review("alice", "APPROVED", pullRequestHeadSHA),
+						review("carol", "DISMISSED", oldHeadSHA),
+						review("bob", "APPROVED", pullRequestHeadSHA),
+					)
+					mockCollaborator("alice", true)
+					mockCollaborator("bob", true)
+				})
+
+				It("reports success status to GitHub", func() {
+					expectPeerStatus("success")
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+		})
+	})
+})
@@ -8,6 +8,7 @@ import (
 
 	"github.com/google/go-github/v84/github"
 	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/git"
 	"github.com/salemove/github-review-helper/mocks"
 	"github.com/stretchr/testify/mock"
 
@@ -53,9 +54,9 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			}
 		})
 
-		Context("with the PR being closed", func() {
+		Context("with the PR being labeled", func() {
 			requestJSON.Is(func() string {
-				return PullRequestEvent("closed", pullRequestHeadSHA, headRepository)
+				return PullRequestEvent("labeled", pullRequestHeadSHA, headRepository)
 			})
 
 			It("succeeds with 'ignored' response", func() {
@@ -65,6 +66,40 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			})
 		})
 
+		Context("with the PR being closed", func() {
+			var gitRepos *mocks.Repos
+			BeforeEach(func() {
+				gitRepos = *context.GitRepos
+			})
+
+			requestJSON.Is(func() string {
+				return PullRequestEvent("closed", pullRequestHeadSHA, headRepository)
+			})
+
+			It("prunes the backups of the PR", func() {
+				gitRepos.
+					On("DeleteBackups", headRepository.URL, git.BackupPrefix(issueNumber)).
+					Return(noError)
+
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				gitRepos.AssertNotCalled(GinkgoT(), "GetUpdatedRepo", mock.Anything, mock.Anything, mock.Anything)
+			})
+
+			Context("with pruning the backups failing", func() {
+				BeforeEach(func() {
+					gitRepos.
+						On("DeleteBackups", headRepository.URL, git.BackupPrefix(issueNumber)).
+						Return(errArbitrary)
+				})
+
+				It("responds with an internal server error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				})
+			})
+		})
+
 		Context("with the PR being synchronized", func() {
 			requestJSON.Is(func() string {
 				return PullRequestEvent("synchronize", pullRequestHeadSHA, headRepository)
@@ -184,6 +219,112 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 				})
 			})
 
+			Context("with list of commits from GitHub including amend commits", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(githubCommits(
+							commit{arbitrarySHA, "Changing things"},
+							commit{pullRequestHeadSHA, "amend! Changing things\n\nChanging things properly"},
+						), emptyResponse, noError)
+				})
+
+				It("reports pending squash status to GitHub", func() {
+					repositories.
+						On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+							mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "pending" && *status.Context == "review/squash"
+							}),
+						).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with list of commits from GitHub including nested fixup commits", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(githubCommits(
+							commit{arbitrarySHA, "Changing things"},
+							commit{"1234", "fixup! Changing things"},
+							commit{pullRequestHeadSHA, "fixup! fixup! Changing things"},
+						), emptyResponse, noError)
+				})
+
+				It("reports pending squash status to GitHub", func() {
+					repositories.
+						On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+							mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "pending" && *status.Context == "review/squash"
+							}),
+						).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with list of commits from GitHub including fixup commits without a target in the PR", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(githubCommits(
+							commit{arbitrarySHA, "Changing things"},
+							commit{"1234", "fixup! Changing things"},
+							commit{pullRequestHeadSHA, "squash! Something on master\n\nOopsie"},
+						), emptyResponse, noError)
+				})
+
+				It("reports failure squash status naming the orphaned fixups to GitHub", func() {
+					repositories.
+						On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+							mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "failure" && *status.Context == "review/squash" &&
+									strings.Contains(*status.Description, "squash! Something on master") &&
+									!strings.Contains(*status.Description, "fixup! Changing things")
+							}),
+						).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with list of commits from GitHub including a fixup commit targeting a later commit", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(githubCommits(
+							commit{arbitrarySHA, "Changing things"},
+							commit{"1234", "fixup! " + pullRequestHeadSHA},
+							commit{pullRequestHeadSHA, "Changing more things"},
+						), emptyResponse, noError)
+				})
+
+				It("reports failure squash status naming the orphaned fixup to GitHub", func() {
+					repositories.
+						On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+							mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "failure" && *status.Context == "review/squash" &&
+									strings.Contains(*status.Description, "fixup! "+pullRequestHeadSHA)
+							}),
+						).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
 			Context("with paged list of commits in mixed order from GitHub including fixup commits", func() {
 				BeforeEach(func() {
 					perPage := 1
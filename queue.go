@@ -0,0 +1,411 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// queuedEventSuffix is the suffix of the files that hold the queued events.
+// The files are named after the events' sequence numbers, so that sorting
+// them by name restores the order the events were received in.
+const queuedEventSuffix = ".json"
+
+// failedEventsDir is the subdirectory of the queue directory that the events
+// are moved to when processing them has failed for good, so that they could
+// be inspected and redelivered manually.
+const failedEventsDir = "failed"
+
+type processOutcome int
+
+const (
+	processed processOutcome = iota
+	// failedTransiently means that trying again later might succeed, e.g.
+	// when the GitHub API failed.
+	failedTransiently
+	failedPermanently
+)
+
+// queuedEvent is a webhook event that has been authenticated and is waiting
+// to be processed.
+type queuedEvent struct {
+	DeliveryID string `json:"delivery_id"`
+	EventType  string `json:"event_type"`
+	// OrderingKey identifies the PR or the repository the event is about.
+	// The events with the same key are processed in the order they were
+	// received in.
+	OrderingKey string `json:"ordering_key"`
+	Body        []byte `json:"body"`
+	// Attempt is the index of the next try in the queue's try delays
+	Attempt int `json:"attempt,omitempty"`
+	// NotBefore is the time the next try is due at
+	NotBefore time.Time `json:"not_before"`
+
+	sequence uint64
+}
+
+// EventQueue stores the webhook events in a directory until a pool of
+// workers has processed them. The events that haven't been processed when
+// the bot stops are processed after it's started again, so every event is
+// processed at least once. The events that fail transiently are tried again
+// with the configured delays before they're given up on. A worker doesn't
+// wait for the retries, but only the later events with the same ordering key
+// are held back until the retry has succeeded or been given up on.
+type EventQueue struct {
+	dir          string
+	tryDelays    []time.Duration
+	mutex        sync.Mutex
+	nextSequence uint64
+	shards       []*queueShard
+	stop         chan struct{}
+	workersWg    sync.WaitGroup
+}
+
+// queueShard holds the events of the ordering keys that one worker is
+// responsible for.
+type queueShard struct {
+	mutex  sync.Mutex
+	events []queuedEvent
+	// wake is signaled when an event is added
+	wake chan struct{}
+}
+
+// NewEventQueue creates a queue with the given number of workers that keeps
+// the events in dir. Processing an event is tried at most once for every
+// delay in tryDelays, waiting for the delay before the try. The events that
+// are already in dir are queued again.
+func NewEventQueue(dir string, workers int, tryDelays []time.Duration) (*EventQueue, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create the event queue directory: %v", err)
+	}
+	if len(tryDelays) == 0 {
+		tryDelays = []time.Duration{0}
+	}
+	q := &EventQueue{
+		dir:       dir,
+		tryDelays: tryDelays,
+		shards:    make([]*queueShard, workers),
+		stop:      make(chan struct{}),
+	}
+	for i := range q.shards {
+		q.shards[i] = &queueShard{wake: make(chan struct{}, 1)}
+	}
+	events, err := q.load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the queued events: %v", err)
+	}
+	for _, event := range events {
+		q.shardFor(event.OrderingKey).add(event)
+		q.nextSequence = event.sequence + 1
+	}
+	return q, nil
+}
+
+// pending returns the events that are waiting to be processed.
+func (q *EventQueue) pending() []queuedEvent {
+	events := []queuedEvent{}
+	for _, shard := range q.shards {
+		shard.mutex.Lock()
+		events = append(events, shard.events...)
+		shard.mutex.Unlock()
+	}
+	return events
+}
+
+// start starts the workers that process the events. finish is called with
+// the outcome once the event has been processed or given up on, but not for
+// the events that are left in the queue when it's stopped.
+func (q *EventQueue) start(process func(queuedEvent) processOutcome,
+	finish func(event queuedEvent, succeeded bool)) {
+
+	for _, shard := range q.shards {
+		q.workersWg.Add(1)
+		go q.work(shard, process, finish)
+	}
+}
+
+// Stop waits for the workers to finish processing their current events and
+// stops them. The rest of the events are left in the directory.
+func (q *EventQueue) Stop() {
+	close(q.stop)
+	q.workersWg.Wait()
+}
+
+// push stores the event and queues it for processing.
+func (q *EventQueue) push(event queuedEvent) error {
+	q.mutex.Lock()
+	event.sequence = q.nextSequence
+	q.nextSequence++
+	event.NotBefore = time.Now().Add(q.tryDelays[0])
+	err := q.write(event)
+	q.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	q.shardFor(event.OrderingKey).add(event)
+	return nil
+}
+
+func (q *EventQueue) shardFor(orderingKey string) *queueShard {
+	hash := fnv.New32a()
+	hash.Write([]byte(orderingKey))
+	return q.shards[hash.Sum32()%uint32(len(q.shards))]
+}
+
+func (q *EventQueue) work(shard *queueShard, process func(queuedEvent) processOutcome,
+	finish func(queuedEvent, bool)) {
+
+	defer q.workersWg.Done()
+	for {
+		select {
+		case <-q.stop:
+			return
+		default:
+		}
+		event, ok, wait := shard.next(time.Now())
+		if !ok {
+			if !q.waitForEvents(shard, wait) {
+				return
+			}
+			continue
+		}
+		outcome := q.processEvent(event, process)
+		if outcome == failedTransiently && event.Attempt+1 < len(q.tryDelays) {
+			q.scheduleRetry(shard, event)
+			continue
+		}
+		shard.remove(event.sequence)
+		finish(event, outcome == processed)
+		if outcome == processed {
+			if err := os.Remove(q.path(event.sequence)); err != nil {
+				log.Printf("Failed to remove the processed event %d from the queue: %v\n", event.sequence, err)
+			}
+		} else {
+			q.moveToFailed(event)
+		}
+	}
+}
+
+// waitForEvents waits until an event is added to the shard or, unless wait
+// is zero, until wait has passed. It returns false if the queue was stopped.
+func (q *EventQueue) waitForEvents(shard *queueShard, wait time.Duration) bool {
+	var due <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		due = timer.C
+	}
+	select {
+	case <-shard.wake:
+	case <-due:
+	case <-q.stop:
+		return false
+	}
+	return true
+}
+
+// scheduleRetry leaves the event in the queue to be tried again after the
+// next delay. The event is stored with the new attempt, so that the retry
+// survives restarts as well.
+func (q *EventQueue) scheduleRetry(shard *queueShard, event queuedEvent) {
+	event.Attempt++
+	delay := q.tryDelays[event.Attempt]
+	event.NotBefore = time.Now().Add(delay)
+	log.Printf("Retrying the queued event %d (delivery %s) in %s\n", event.sequence, event.DeliveryID, delay)
+	if err := q.write(event); err != nil {
+		log.Printf("Failed to store the retry of the event %d: %v\n", event.sequence, err)
+	}
+	shard.update(event)
+}
+
+// processEvent processes the event, recovering from panics, so that a single
+// event can't stop the worker. A panic isn't expected to go away when the
+// event is tried again.
+func (q *EventQueue) processEvent(event queuedEvent,
+	process func(queuedEvent) processOutcome) (outcome processOutcome) {
+
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Processing the queued event %d (delivery %s) panicked: %v\n", event.sequence,
+				event.DeliveryID, err)
+			outcome = failedPermanently
+		}
+	}()
+	return process(event)
+}
+
+// moveToFailed moves the event out of the queue into the failed events
+// directory, so that it isn't processed again after restarts.
+func (q *EventQueue) moveToFailed(event queuedEvent) {
+	log.Printf("Giving up on the queued event %d (delivery %s)\n", event.sequence, event.DeliveryID)
+	failedDir := filepath.Join(q.dir, failedEventsDir)
+	if err := os.MkdirAll(failedDir, 0700); err != nil {
+		log.Printf("Failed to create the failed events directory: %v\n", err)
+		return
+	}
+	path := q.path(event.sequence)
+	if err := os.Rename(path, filepath.Join(failedDir, filepath.Base(path))); err != nil {
+		log.Printf("Failed to move the event %d to the failed events: %v\n", event.sequence, err)
+	}
+}
+
+// write writes the event into a file in the queue directory.
+func (q *EventQueue) write(event queuedEvent) error {
+	contents, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to serialize the event: %v", err)
+	}
+	if err := writeFileSynced(q.path(event.sequence), contents); err != nil {
+		return fmt.Errorf("failed to store the event: %v", err)
+	}
+	return nil
+}
+
+// load reads the queued events from the directory, oldest first. The
+// leftovers of events that were being written when the bot stopped are
+// removed.
+func (q *EventQueue) load() ([]queuedEvent, error) {
+	entries, err := os.ReadDir(q.dir)
+	if err != nil {
+		return nil, err
+	}
+	events := []queuedEvent{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(filepath.Join(q.dir, name))
+			continue
+		} else if !strings.HasSuffix(name, queuedEventSuffix) {
+			continue
+		}
+		sequence, err := strconv.ParseUint(strings.TrimSuffix(name, queuedEventSuffix), 10, 64)
+		if err != nil {
+			log.Printf("Ignoring unexpected file %s in the event queue\n", name)
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(q.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var event queuedEvent
+		if err := json.Unmarshal(contents, &event); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		event.sequence = sequence
+		events = append(events, event)
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].sequence < events[j].sequence
+	})
+	return events, nil
+}
+
+func (q *EventQueue) path(sequence uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", sequence, queuedEventSuffix))
+}
+
+// writeFileSynced writes the contents into the file at path. The file is only
+// renamed to its final name once it has been synced to disk, so that a crash
+// can't leave a partially written file behind. The temporary files are
+// prefixed with ".tmp-" in the same directory.
+func writeFileSynced(path string, contents []byte) error {
+	dir := filepath.Dir(path)
+	tmpFile, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to create a temporary file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(contents); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to write %s: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("failed to sync %s: %v", tmpFile.Name(), err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %v", tmpFile.Name(), err)
+	}
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes sure that the renames in the directory survive a crash.
+func syncDir(dir string) error {
+	file, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %v", dir, err)
+	}
+	return nil
+}
+
+func (s *queueShard) add(event queuedEvent) {
+	s.mutex.Lock()
+	s.events = append(s.events, event)
+	s.mutex.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+		// The worker has already been woken up
+	}
+}
+
+// next returns the oldest event of the shard that is due without removing it,
+// so that the event is counted as pending until it has been processed. An
+// event that isn't due yet holds back the later events with the same
+// ordering key. If no event is due, wait is the time until the next one is,
+// or zero if there are none.
+func (s *queueShard) next(now time.Time) (event queuedEvent, ok bool, wait time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	heldBackKeys := map[string]bool{}
+	for _, event := range s.events {
+		if heldBackKeys[event.OrderingKey] {
+			continue
+		} else if event.NotBefore.After(now) {
+			heldBackKeys[event.OrderingKey] = true
+			if untilDue := event.NotBefore.Sub(now); wait == 0 || untilDue < wait {
+				wait = untilDue
+			}
+			continue
+		}
+		return event, true, 0
+	}
+	return queuedEvent{}, false, wait
+}
+
+func (s *queueShard) update(event queuedEvent) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.events {
+		if s.events[i].sequence == event.sequence {
+			s.events[i] = event
+			return
+		}
+	}
+}
+
+func (s *queueShard) remove(sequence uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := range s.events {
+		if s.events[i].sequence == sequence {
+			s.events = append(s.events[:i], s.events[i+1:]...)
+			return
+		}
+	}
+}
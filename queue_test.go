@@ -0,0 +1,294 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("event queue", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			queueDir         string
+			queue            *grh.EventQueue
+			request          *http.Request
+			responseRecorder *httptest.ResponseRecorder
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			repositories = *context.Repositories
+			issues = *context.Issues
+
+			var err error
+			queueDir, err = os.MkdirTemp("", "queue-test")
+			Expect(err).NotTo(HaveOccurred())
+			queue, err = grh.NewEventQueue(queueDir, 4, context.Config.GithubAPITryDeltas)
+			Expect(err).NotTo(HaveOccurred())
+			*context.Queue = queue
+		})
+		JustBeforeEach(func() {
+			request = *context.Request
+		})
+		AfterEach(func() {
+			queue.Stop()
+			os.RemoveAll(queueDir)
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event":    "issue_comment",
+				"X-GitHub-Delivery": "delivery-1",
+			}
+		})
+		requestJSON.Is(func() string {
+			return IssueCommentEvent("!cancel", arbitraryIssueAuthor)
+		})
+
+		queuedEvents := func() []string {
+			entries, err := os.ReadDir(queueDir)
+			Expect(err).NotTo(HaveOccurred())
+			names := []string{}
+			for _, entry := range entries {
+				if !entry.IsDir() {
+					names = append(names, entry.Name())
+				}
+			}
+			return names
+		}
+		failedEvents := func() []os.DirEntry {
+			entries, err := os.ReadDir(filepath.Join(queueDir, "failed"))
+			if os.IsNotExist(err) {
+				return nil
+			}
+			Expect(err).NotTo(HaveOccurred())
+			return entries
+		}
+
+		BeforeEach(func() {
+			repositories.
+				On("IsCollaborator", anyContext, repositoryOwner, repositoryName, arbitraryIssueAuthor).
+				Return(true, emptyResponse, noError)
+			issues.
+				On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.Anything).
+				Return(emptyResult, emptyResponse, noError).
+				Maybe()
+			(*context.PullRequests).
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(&github.PullRequest{
+					Number: github.Int(issueNumber),
+					Head: &github.PullRequestBranch{
+						SHA: github.String(arbitrarySHA),
+					},
+				}, emptyResponse, noError).
+				Maybe()
+		})
+
+		It("responds before processing the event", func() {
+			release := make(chan time.Time)
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError).
+				WaitUntil(release).
+				Once()
+
+			handle()
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(responseRecorder.Body.String()).To(ContainSubstring("Queued"))
+			Expect(queuedEvents()).To(HaveLen(1))
+
+			close(release)
+			Eventually(queuedEvents).Should(BeEmpty())
+		})
+
+		It("processes the events of a PR in the order they were received in", func() {
+			var (
+				mutex sync.Mutex
+				order []int
+			)
+			release := make(chan time.Time)
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError).
+				WaitUntil(release).
+				Run(func(mock.Arguments) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					order = append(order, 1)
+				}).
+				Once()
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError).
+				Run(func(mock.Arguments) {
+					mutex.Lock()
+					defer mutex.Unlock()
+					order = append(order, 2)
+				}).
+				Once()
+
+			handle()
+			request.Header.Set("X-GitHub-Delivery", "delivery-2")
+			handle()
+
+			close(release)
+			Eventually(queuedEvents).Should(BeEmpty())
+			mutex.Lock()
+			defer mutex.Unlock()
+			Expect(order).To(Equal([]int{1, 2}))
+		})
+
+		Context("with processing the event failing once", func() {
+			BeforeEach(func() {
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, errArbitrary).
+					Once()
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, noError).
+					Once()
+			})
+
+			It("retries the event", func() {
+				handle()
+				Eventually(queuedEvents).Should(BeEmpty())
+				issues.AssertNumberOfCalls(GinkgoT(), "RemoveLabelForIssue", 2)
+				Expect(failedEvents()).To(BeEmpty())
+			})
+		})
+
+		Context("with the retry of a failed event being due later", func() {
+			var body string
+			requestJSON.Is(func() string {
+				return body
+			})
+
+			BeforeEach(func() {
+				body = IssueCommentEvent("!cancel", arbitraryIssueAuthor)
+				// A single worker processes all the events
+				queue.Stop()
+				var err error
+				queue, err = grh.NewEventQueue(queueDir, 1, []time.Duration{0, time.Hour})
+				Expect(err).NotTo(HaveOccurred())
+				*context.Queue = queue
+
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, errArbitrary).
+					Once()
+			})
+
+			retryScheduled := func() bool {
+				contents, err := os.ReadFile(filepath.Join(queueDir, queuedEvents()[0]))
+				Expect(err).NotTo(HaveOccurred())
+				return strings.Contains(string(contents), `"attempt":1`)
+			}
+
+			It("processes the other events in the meantime", func() {
+				handle()
+				Eventually(retryScheduled).Should(BeTrue())
+
+				body = "{}"
+				request.Header.Set("X-Github-Event", "gibberish")
+				request.Header.Set("X-GitHub-Delivery", "delivery-2")
+				request.Header.Set("X-Hub-Signature-256", Signature256(context.Config.Secret, body))
+				handle()
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Queued"))
+
+				Eventually(queuedEvents).Should(HaveLen(1))
+				Expect(retryScheduled()).To(BeTrue())
+			})
+
+			It("holds back the later events of the PR", func() {
+				handle()
+				Eventually(retryScheduled).Should(BeTrue())
+
+				request.Header.Set("X-GitHub-Delivery", "delivery-2")
+				handle()
+
+				Consistently(queuedEvents, 200*time.Millisecond).Should(HaveLen(2))
+				issues.AssertNumberOfCalls(GinkgoT(), "RemoveLabelForIssue", 1)
+			})
+		})
+
+		Context("with processing the event always failing", func() {
+			BeforeEach(func() {
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, errArbitrary)
+			})
+
+			It("moves the event to the failed events after the last try", func() {
+				handle()
+				Eventually(failedEvents).Should(HaveLen(1))
+				Expect(queuedEvents()).To(BeEmpty())
+				issues.AssertNumberOfCalls(GinkgoT(), "RemoveLabelForIssue", numberOfGithubTries)
+			})
+
+			It("accepts a redelivery of the event", func() {
+				handle()
+				Eventually(failedEvents).Should(HaveLen(1))
+
+				handle()
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Queued"))
+			})
+		})
+
+		Context("with processing the event panicking", func() {
+			BeforeEach(func() {
+				issues.
+					On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+					Return(emptyResponse, noError).
+					Run(func(mock.Arguments) {
+						panic("arbitrary panic")
+					})
+			})
+
+			It("doesn't retry the event and accepts a redelivery of it", func() {
+				handle()
+				Eventually(failedEvents).Should(HaveLen(1))
+				issues.AssertNumberOfCalls(GinkgoT(), "RemoveLabelForIssue", 1)
+
+				handle()
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("Queued"))
+			})
+		})
+
+		It("processes the events that were queued before a restart", func() {
+			queue.Stop()
+			handle()
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			Expect(queuedEvents()).To(HaveLen(1))
+
+			issues.
+				On("RemoveLabelForIssue", anyContext, repositoryOwner, repositoryName, issueNumber, grh.MergingLabel).
+				Return(emptyResponse, noError).
+				Once()
+			var err error
+			queue, err = grh.NewEventQueue(queueDir, 4, context.Config.GithubAPITryDeltas)
+			Expect(err).NotTo(HaveOccurred())
+			grh.CreateHandler(*context.Config, *context.GitRepos, &sync.WaitGroup{}, queue, *context.PullRequests,
+				repositories, issues, *context.Search, *context.Checks)
+
+			Eventually(queuedEvents).Should(BeEmpty())
+		})
+	})
+})
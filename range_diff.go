@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// rangeDiffMarker identifies the comment that the bot keeps up to date with
+// the range-diff of the latest squash.
+const rangeDiffMarker = "<!-- review-helper:range-diff -->"
+
+// maxRangeDiffLength keeps the comment under GitHub's limit of 65536
+// characters. It's counted in bytes, which is never less than the number of
+// characters.
+const maxRangeDiffLength = 60000
+
+// postRangeDiff posts the range-diff of a squash as a collapsible comment on
+// the PR, or updates the comment if the PR already has one.
+func postRangeDiff(issue Issue, rangeDiff, botLogin string, issues Issues) *ErrorResponse {
+	return postStickyComment(issue, rangeDiffMarker, rangeDiffComment(rangeDiff), botLogin, issues)
+}
+
+func rangeDiffComment(rangeDiff string) string {
+	truncated := false
+	if len(rangeDiff) > maxRangeDiffLength {
+		// Cut at the start of a rune to keep multi-byte characters whole
+		end := maxRangeDiffLength
+		for end > 0 && !utf8.RuneStart(rangeDiff[end]) {
+			end--
+		}
+		rangeDiff = rangeDiff[:end]
+		truncated = true
+	}
+	// The fence has to be longer than any backtick sequence in the diff
+	fence := "```"
+	for strings.Contains(rangeDiff, fence) {
+		fence += "`"
+	}
+	body := rangeDiffMarker + "\n" +
+		"<details>\n" +
+		"<summary>Range-diff of the latest squash</summary>\n\n" +
+		fence + "\n" + rangeDiff + "\n" + fence + "\n"
+	if truncated {
+		body += "\nThe range-diff was too long and has been truncated.\n"
+	}
+	return body + "</details>\n"
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+)
+
+const githubStatusRebaseContext = "review/rebase"
+
+func isRebaseCommand(comment string) bool {
+	arguments := strings.Fields(comment)
+	switch len(arguments) {
+	case 1:
+		return arguments[0] == "!rebase"
+	case 2:
+		return arguments[0] == "!rebase" && arguments[1] == "autosquash"
+	}
+	return false
+}
+
+// isAutosquashRequested reports whether the rebase command asks for the
+// fixup! and squash! commits to be squashed while rebasing.
+func isAutosquashRequested(comment string) bool {
+	return strings.HasSuffix(strings.TrimSpace(comment), "autosquash")
+}
+
+func handleRebaseCommand(issueComment IssueComment, pr *github.PullRequest, gitRepos git.Repos,
+	repositories Repositories, issues Issues) Response {
+
+	if isAcrossForks(pr) {
+		// The base branch is only available in the base repository, but the
+		// head branch has to be pushed to the head repository.
+		err := comment("I'm sorry, but I can't rebase PRs across forks.", issueComment.Repository,
+			issueComment.IssueNumber, issues)
+		if err != nil {
+			return ErrorResponse{err, http.StatusBadGateway, "Failed to respond to the rebase command"}
+		}
+		return SuccessResponse{"PR is across forks. Not rebasing."}
+	}
+	autosquash := isAutosquashRequested(issueComment.Comment)
+	log.Printf("Rebasing %s onto %s (autosquash: %t)\n", *pr.Head.Ref, *pr.Base.Ref, autosquash)
+
+	repository := headRepository(pr)
+	gitRepo, err := gitRepos.GetUpdatedRepo(repository.URL, repository.Owner, repository.Name)
+	if err != nil {
+		message := fmt.Sprintf("Failed to get an updated repo for PR %s", prFullName(pr))
+		return ErrorResponse{err, http.StatusInternalServerError, message}
+	}
+	err = gitRepo.RebaseAndPush("origin/"+*pr.Base.Ref, *pr.Head.SHA, *pr.Head.Ref, git.BackupPrefix(*pr.Number),
+		autosquash)
+	var conflictErr *git.ErrRebaseConflict
+	var movedErr *git.ErrBranchMoved
+	if errors.As(err, &movedErr) {
+		return reportBranchMoved(pr, movedErr, issues)
+	} else if errors.As(err, &conflictErr) {
+		return reportRebaseConflict(pr, conflictErr, repositories, issues)
+	} else if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to rebase the PR"}
+	}
+	return SuccessResponse{fmt.Sprintf("Rebased PR %s onto %s", prFullName(pr), *pr.Base.Ref)}
+}
+
+func reportRebaseConflict(pr *github.PullRequest, conflictErr *git.ErrRebaseConflict,
+	repositories Repositories, issues Issues) Response {
+
+	log.Printf("Rebasing PR %s failed: %v. Setting a failure status.\n", prFullName(pr), conflictErr)
+	description := fmt.Sprintf("Automatic rebase onto %s failed. Please rebase manually", *pr.Base.Ref)
+	status := &github.RepoStatus{
+		State:       github.String("failure"),
+		Description: github.String(truncateStatusDescription(description)),
+		Context:     github.String(githubStatusRebaseContext),
+	}
+	if errResp := setStatusForPR(pr, status, repositories); errResp != nil {
+		return errResp
+	}
+	issue := prIssue(pr)
+	if err := comment(rebaseConflictMessage(pr, conflictErr.Files), issue.Repository, issue.Number,
+		issues); err != nil {
+		message := fmt.Sprintf("Failed to notify the author of PR %s about the rebase conflict", issue.FullName())
+		return ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	return SuccessResponse{}
+}
+
+func rebaseConflictMessage(pr *github.PullRequest, files []string) string {
+	message := fmt.Sprintf("I'm unable to rebase this PR onto `%s`", *pr.Base.Ref)
+	if len(files) == 0 {
+		return message + ". @" + *pr.User.Login + ", can you please take a look?"
+	}
+	message += " because of conflicts in the following files:\n\n"
+	for _, file := range files {
+		message += fmt.Sprintf("- `%s`\n", file)
+	}
+	return message + "\n@" + *pr.User.Login + ", can you please rebase manually?"
+}
@@ -0,0 +1,191 @@
+package main_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("!rebase comment", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+			gitRepos         *mocks.Repos
+			gitRepo          *mocks.Repo
+
+			headSHA = "1235"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+			gitRepos = *context.GitRepos
+			gitRepo = new(mocks.Repo)
+		})
+		AfterEach(func() {
+			gitRepo.AssertExpectations(GinkgoT())
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "issue_comment",
+			}
+		})
+		requestJSON.Is(func() string {
+			return IssueCommentEvent("!rebase", arbitraryIssueAuthor)
+		})
+
+		pr := &github.PullRequest{
+			Number: github.Int(issueNumber),
+			Base: &github.PullRequestBranch{
+				SHA:  github.String("1234"),
+				Ref:  github.String("master"),
+				Repo: repository,
+			},
+			Head: &github.PullRequestBranch{
+				SHA:  github.String(headSHA),
+				Ref:  github.String("feature"),
+				Repo: repository,
+			},
+			User: &github.User{
+				Login: github.String(arbitraryIssueAuthor),
+			},
+		}
+
+		ForCollaborator(context, repositoryOwner, repositoryName, arbitraryIssueAuthor, func() {
+			Context("with fetching the PR failing", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(emptyResult, emptyResponse, errArbitrary)
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+
+			Context("with fetching the PR succeeding", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(pr, emptyResponse, noError)
+					gitRepos.
+						On("GetUpdatedRepo", sshURL, repositoryOwner, repositoryName).
+						Return(gitRepo, noError)
+				})
+
+				Context("with the rebase succeeding", func() {
+					It("rebases the PR onto its base branch", func() {
+						gitRepo.
+							On("RebaseAndPush", "origin/master", headSHA, "feature", git.BackupPrefix(issueNumber), false).
+							Return(noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+
+					Context("with autosquash requested", func() {
+						requestJSON.Is(func() string {
+							return IssueCommentEvent("!rebase autosquash", arbitraryIssueAuthor)
+						})
+
+						It("rebases the PR with autosquash", func() {
+							gitRepo.
+								On("RebaseAndPush", "origin/master", headSHA, "feature", git.BackupPrefix(issueNumber), true).
+								Return(noError)
+
+							handle()
+							Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						})
+					})
+				})
+
+				Context("with the rebase failing for an unknown reason", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RebaseAndPush", "origin/master", headSHA, "feature", git.BackupPrefix(issueNumber), false).
+							Return(errors.New("other git error"))
+					})
+
+					It("responds with an internal server error", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+					})
+				})
+
+				Context("with the PR branch having moved", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RebaseAndPush", "origin/master", headSHA, "feature", git.BackupPrefix(issueNumber), false).
+							Return(&git.ErrBranchMoved{
+								Err:         errors.New("stale info"),
+								Branch:      "feature",
+								ExpectedSHA: headSHA,
+							})
+					})
+
+					It("asks the author to re-run the command", func() {
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+								return strings.Contains(*issueComment.Body, "moved") &&
+									strings.Contains(*issueComment.Body, "re-run") &&
+									strings.Contains(*issueComment.Body, "@"+arbitraryIssueAuthor)
+							})).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+
+				Context("with the rebase failing due to conflicts", func() {
+					BeforeEach(func() {
+						gitRepo.
+							On("RebaseAndPush", "origin/master", headSHA, "feature", git.BackupPrefix(issueNumber), false).
+							Return(&git.ErrRebaseConflict{
+								Err:   errors.New("merge conflict"),
+								Files: []string{"foo.go", "bar/baz.go"},
+							})
+					})
+
+					It("reports the failure with a status and a comment listing the files", func() {
+						repositories.
+							On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "failure" && *status.Context == "review/rebase"
+							})).
+							Return(emptyResult, emptyResponse, noError)
+						issues.
+							On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+								return strings.Contains(*issueComment.Body, "`foo.go`") &&
+									strings.Contains(*issueComment.Body, "`bar/baz.go`") &&
+									strings.Contains(*issueComment.Body, "@"+arbitraryIssueAuthor)
+							})).
+							Return(emptyResult, emptyResponse, noError)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					})
+				})
+			})
+		})
+	})
+})
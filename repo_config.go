@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+	"gopkg.in/yaml.v3"
+)
+
+// repoConfigPath is the path of the per-repository configuration file. The
+// file is read from the base branch of the PR.
+const repoConfigPath = ".github/review-helper.yml"
+
+var mergeMethods = []string{"merge", "squash", "rebase"}
+
+// commandNames maps the commands to the names used for enabling them in the
+// per-repository configuration file.
+var commandNames = map[commentType]string{
+	squashCommand: "squash",
+	mergeCommand:  "merge",
+	cancelCommand: "cancel",
+	checkCommand:  "check",
+	rebaseCommand: "rebase",
+	undoCommand:   "undo",
+}
+
+// RepoConfig holds the settings that repositories can override with their
+// configuration file.
+type RepoConfig struct {
+	MergeMethod       string
+	RequiredApprovals int
+	MergingLabel      string
+	Commands          []string
+	SquashCheck       bool
+	// CoAuthorTrailers is true if the authors of the squashed commits are
+	// added as Co-authored-by trailers to the commits they're squashed into
+	CoAuthorTrailers bool
+	// MergeCommitTitle and MergeCommitMessage are nil if GitHub's default
+	// merge commit title and message should be used.
+	MergeCommitTitle   *template.Template
+	MergeCommitMessage *template.Template
+	// CommitLint is nil if commit messages aren't checked
+	CommitLint *commitLintRules
+	// CompleteCheck is nil if the commits aren't checked to be complete
+	CompleteCheck *git.CommitCheck
+}
+
+// repoConfigFile is the format of the configuration file. Pointers are used
+// to distinguish settings that are left out from zero values.
+type repoConfigFile struct {
+	MergeMethod       *string   `yaml:"merge_method"`
+	RequiredApprovals *int      `yaml:"required_approvals"`
+	MergingLabel      *string   `yaml:"merging_label"`
+	Commands          *[]string `yaml:"commands"`
+	SquashCheck       *bool     `yaml:"squash_check"`
+	CoAuthorTrailers  *bool     `yaml:"co_author_trailers"`
+
+	MergeCommitTitle   *string `yaml:"merge_commit_title"`
+	MergeCommitMessage *string `yaml:"merge_commit_message"`
+
+	CommitLint    *commitLintFile    `yaml:"commit_lint"`
+	CompleteCheck *completeCheckFile `yaml:"complete_check"`
+}
+
+func defaultRepoConfig(conf Config) RepoConfig {
+	commands := []string{}
+	for _, name := range commandNames {
+		commands = append(commands, name)
+	}
+	sort.Strings(commands)
+	return RepoConfig{
+		MergeMethod:       "merge",
+		RequiredApprovals: conf.RequiredApprovals,
+		MergingLabel:      MergingLabel,
+		Commands:          commands,
+		SquashCheck:       true,
+		CoAuthorTrailers:  true,
+	}
+}
+
+func (c RepoConfig) isCommandEnabled(command commentType) bool {
+	name, ok := commandNames[command]
+	if !ok {
+		// Commands that have no name can't be disabled
+		return true
+	}
+	return contains(c.Commands, name)
+}
+
+func (c RepoConfig) isPeerReviewEnabled() bool {
+	return c.RequiredApprovals > 0
+}
+
+// parseRepoConfig parses the contents of a configuration file, using the
+// defaults for the settings that are left out.
+func parseRepoConfig(data []byte, defaults RepoConfig) (RepoConfig, error) {
+	var file repoConfigFile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && err != io.EOF {
+		return RepoConfig{}, err
+	}
+	repoConfig := defaults
+	if file.MergeMethod != nil {
+		if !contains(mergeMethods, *file.MergeMethod) {
+			return RepoConfig{}, fmt.Errorf("merge_method must be one of %v, got \"%s\"", mergeMethods,
+				*file.MergeMethod)
+		}
+		repoConfig.MergeMethod = *file.MergeMethod
+	}
+	if file.RequiredApprovals != nil {
+		if *file.RequiredApprovals < 0 {
+			return RepoConfig{}, errors.New("required_approvals must not be negative")
+		}
+		repoConfig.RequiredApprovals = *file.RequiredApprovals
+	}
+	if file.MergingLabel != nil {
+		if *file.MergingLabel == "" {
+			return RepoConfig{}, errors.New("merging_label must not be empty")
+		}
+		repoConfig.MergingLabel = *file.MergingLabel
+	}
+	if file.Commands != nil {
+		for _, command := range *file.Commands {
+			if !isKnownCommandName(command) {
+				return RepoConfig{}, fmt.Errorf("unknown command \"%s\" in commands", command)
+			}
+		}
+		repoConfig.Commands = *file.Commands
+	}
+	if file.SquashCheck != nil {
+		repoConfig.SquashCheck = *file.SquashCheck
+	}
+	if file.CoAuthorTrailers != nil {
+		repoConfig.CoAuthorTrailers = *file.CoAuthorTrailers
+	}
+	if file.MergeCommitTitle != nil {
+		tmpl, err := parseMergeTemplate("merge_commit_title", *file.MergeCommitTitle)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("invalid merge_commit_title: %v", err)
+		}
+		repoConfig.MergeCommitTitle = tmpl
+	}
+	if file.MergeCommitMessage != nil {
+		tmpl, err := parseMergeTemplate("merge_commit_message", *file.MergeCommitMessage)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("invalid merge_commit_message: %v", err)
+		}
+		repoConfig.MergeCommitMessage = tmpl
+	}
+	if file.CommitLint != nil {
+		rules, err := parseCommitLintRules(*file.CommitLint)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("invalid commit_lint: %v", err)
+		}
+		repoConfig.CommitLint = rules
+	}
+	if file.CompleteCheck != nil {
+		check, err := parseCompleteCheck(*file.CompleteCheck)
+		if err != nil {
+			return RepoConfig{}, fmt.Errorf("invalid complete_check: %v", err)
+		}
+		repoConfig.CompleteCheck = check
+	}
+	return repoConfig, nil
+}
+
+func isKnownCommandName(name string) bool {
+	for _, commandName := range commandNames {
+		if commandName == name {
+			return true
+		}
+	}
+	return false
+}
+
+// repoConfigs loads the configuration files of repositories from the base
+// branches of the PRs and caches them until the branch is pushed to. The
+// merging label is always taken from the default branch, because the PRs to
+// merge are searched for by their label before their base branches are
+// known.
+type repoConfigs struct {
+	mutex        sync.Mutex
+	defaults     RepoConfig
+	repositories Repositories
+	cache        map[string]repoConfigEntry
+	// generations counts the invalidations of every repository, so that a
+	// configuration that was loaded before an invalidation isn't cached
+	// after it
+	generations map[string]uint64
+}
+
+// repoConfigEntry is either a configuration or the error that an invalid
+// configuration file caused. Invalid files are cached like valid ones, so
+// that they aren't fetched for every event.
+type repoConfigEntry struct {
+	repoConfig RepoConfig
+	errResp    *ErrorResponse
+}
+
+func newRepoConfigs(conf Config, repositories Repositories) *repoConfigs {
+	return &repoConfigs{
+		defaults:     defaultRepoConfig(conf),
+		repositories: repositories,
+		cache:        map[string]repoConfigEntry{},
+		generations:  map[string]uint64{},
+	}
+}
+
+func repoConfigKey(repository Repository) string {
+	return repository.Owner + "/" + repository.Name
+}
+
+// get returns the configuration of the repository on the given branch. An
+// empty branch stands for the default branch.
+func (r *repoConfigs) get(repository Repository, branch string) (RepoConfig, *ErrorResponse) {
+	if branch == repository.DefaultBranch {
+		branch = ""
+	}
+	repoConfig, errResp := r.getForBranch(repository, branch)
+	if errResp != nil || branch == "" {
+		return repoConfig, errResp
+	}
+	defaultConfig, errResp := r.getForBranch(repository, "")
+	if errResp != nil {
+		return RepoConfig{}, errResp
+	}
+	repoConfig.MergingLabel = defaultConfig.MergingLabel
+	return repoConfig, nil
+}
+
+func (r *repoConfigs) getForBranch(repository Repository, branch string) (RepoConfig, *ErrorResponse) {
+	key := repoConfigKey(repository)
+	cacheKey := key + "@" + branch
+	r.mutex.Lock()
+	entry, ok := r.cache[cacheKey]
+	generation := r.generations[key]
+	r.mutex.Unlock()
+	if !ok {
+		var errResp *ErrorResponse
+		entry, errResp = r.load(repository, branch)
+		if errResp != nil {
+			return RepoConfig{}, errResp
+		}
+		r.mutex.Lock()
+		if r.generations[key] == generation {
+			r.cache[cacheKey] = entry
+		}
+		r.mutex.Unlock()
+	}
+	if entry.errResp != nil {
+		return RepoConfig{}, entry.errResp
+	}
+	return entry.repoConfig, nil
+}
+
+// load fetches and parses the configuration file. The returned error is only
+// set for the failures that shouldn't be cached.
+func (r *repoConfigs) load(repository Repository, branch string) (repoConfigEntry, *ErrorResponse) {
+	name := repoConfigKey(repository)
+	if branch != "" {
+		name += "@" + branch
+	}
+	log.Printf("Loading %s for %s.\n", repoConfigPath, name)
+	// An empty ref makes GitHub use the default branch
+	opts := &github.RepositoryContentGetOptions{Ref: branch}
+	fileContent, _, resp, err := r.repositories.GetContents(context.TODO(), repository.Owner,
+		repository.Name, repoConfigPath, opts)
+	if err != nil {
+		if is404Error(resp) {
+			return repoConfigEntry{repoConfig: r.defaults}, nil
+		}
+		message := fmt.Sprintf("Failed to get %s for %s", repoConfigPath, name)
+		return repoConfigEntry{}, &ErrorResponse{err, http.StatusBadGateway, message}
+	} else if fileContent == nil {
+		message := fmt.Sprintf("Expected %s in %s to be a file", repoConfigPath, name)
+		return repoConfigEntry{errResp: &ErrorResponse{nil, http.StatusInternalServerError, message}}, nil
+	}
+	content, err := fileContent.GetContent()
+	if err != nil {
+		message := fmt.Sprintf("Failed to decode %s for %s", repoConfigPath, name)
+		return repoConfigEntry{errResp: &ErrorResponse{err, http.StatusInternalServerError, message}}, nil
+	}
+	repoConfig, err := parseRepoConfig([]byte(content), r.defaults)
+	if err != nil {
+		message := fmt.Sprintf("Invalid %s in %s", repoConfigPath, name)
+		return repoConfigEntry{errResp: &ErrorResponse{err, http.StatusInternalServerError, message}}, nil
+	}
+	return repoConfigEntry{repoConfig: repoConfig}, nil
+}
+
+// invalidate forgets the cached configuration of the branch. All the
+// configurations of the repository are forgotten when the branch is the
+// default branch, because the other branches take the merging label from it.
+func (r *repoConfigs) invalidate(repository Repository, branch string) {
+	key := repoConfigKey(repository)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.generations[key]++
+	if branch != repository.DefaultBranch {
+		delete(r.cache, key+"@"+branch)
+		return
+	}
+	for cacheKey := range r.cache {
+		if strings.HasPrefix(cacheKey, key+"@") {
+			delete(r.cache, cacheKey)
+		}
+	}
+}
+
+func contains(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,365 @@
+package main_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("repository configuration file", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+			issues           *mocks.Issues
+
+			issueAuthor = "procoder"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+			issues = *context.Issues
+		})
+
+		mockConfigFile := func(content string) {
+			repositories.
+				On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+				Return(&github.RepositoryContent{
+					Content: github.String(content),
+				}, emptyResult, emptyResponse, noError)
+		}
+		mockPRTo := func(baseRef string) {
+			pullRequests.
+				On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+				Return(&github.PullRequest{
+					Number: github.Int(issueNumber),
+					Base: &github.PullRequestBranch{
+						Ref: github.String(baseRef),
+					},
+				}, emptyResponse, noError)
+		}
+		// The configuration is only loaded for the commands of collaborators
+		mockCollaborator := func() {
+			repositories.
+				On("IsCollaborator", anyContext, repositoryOwner, repositoryName, issueAuthor).
+				Return(true, emptyResponse, noError)
+		}
+		onBranch := func(ref string) interface{} {
+			return mock.MatchedBy(func(opts *github.RepositoryContentGetOptions) bool {
+				return opts.Ref == ref
+			})
+		}
+
+		Context("with an issue comment", func() {
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event": "issue_comment",
+				}
+			})
+
+			Context("with fetching the configuration file failing", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				BeforeEach(func() {
+					mockCollaborator()
+					mockPRTo("")
+					repositories.
+						On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+						Return(emptyResult, emptyResult, emptyResponse, errArbitrary)
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+
+			Context("with an invalid configuration file", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				BeforeEach(func() {
+					mockCollaborator()
+					mockPRTo("")
+					mockConfigFile("merge_method: fast-forward\n")
+				})
+
+				It("fails with an internal error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Invalid .github/review-helper.yml"))
+				})
+
+				It("doesn't fetch the file again for the next event", func() {
+					handle()
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+					repositories.AssertNumberOfCalls(GinkgoT(), "GetContents", 1)
+				})
+			})
+
+			Context("with an unknown setting in the configuration file", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				BeforeEach(func() {
+					mockCollaborator()
+					mockPRTo("")
+					mockConfigFile("merge_mehtod: squash\n")
+				})
+
+				It("fails with an internal error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				})
+			})
+
+			Context("with the command being disabled", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				BeforeEach(func() {
+					mockCollaborator()
+					mockPRTo("")
+					mockConfigFile("commands: [merge, check]\n")
+				})
+
+				It("ignores the command", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+
+			Context("with the command being disabled on the PR's base branch", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				BeforeEach(func() {
+					mockCollaborator()
+					mockPRTo("release")
+					repositories.
+						On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml",
+							onBranch("release")).
+						Return(&github.RepositoryContent{
+							Content: github.String("commands: [merge, check]\n"),
+						}, emptyResult, emptyResponse, noError)
+				})
+
+				It("ignores the command", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+
+			Context("with the squash check being disabled", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!check", issueAuthor)
+				})
+
+				ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+					BeforeEach(func() {
+						mockPRTo("")
+						mockConfigFile("squash_check: false\n")
+					})
+
+					It("doesn't check for fixup commits", func() {
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+						Expect(responseRecorder.Body.String()).To(ContainSubstring("Squash check disabled"))
+					})
+				})
+			})
+
+			Context("with the default branch pushed to while the configuration is loaded", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!squash", issueAuthor)
+				})
+
+				var handler grh.Handler
+				BeforeEach(func() {
+					conf := context.Config
+					mockCollaborator()
+					mockPRTo("")
+					pushEvent := PushEvent("refs/heads/master", "master")
+					repositories.
+						On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+						Run(func(mock.Arguments) {
+							pushRequest, err := http.NewRequest("POST", "http://localhost/whatever",
+								strings.NewReader(pushEvent))
+							Expect(err).NotTo(HaveOccurred())
+							pushRequest.Header.Set("X-Github-Event", "push")
+							pushRequest.Header.Set("X-Hub-Signature-256", Signature256(conf.Secret, pushEvent))
+							pushResponse := handler(httptest.NewRecorder(), pushRequest)
+							Expect(pushResponse).To(BeAssignableToTypeOf(grh.SuccessResponse{}))
+						}).
+						Return(&github.RepositoryContent{
+							Content: github.String("commands: [merge, check]\n"),
+						}, emptyResult, emptyResponse, noError).
+						Once()
+					mockConfigFile("commands: [merge, check]\n")
+				})
+
+				It("doesn't cache the configuration loaded before the push", func() {
+					handler = grh.CreateHandler(*context.Config, *context.GitRepos, &sync.WaitGroup{}, nil,
+						pullRequests, repositories, issues, *context.Search, *context.Checks)
+					for i := 0; i < 2; i++ {
+						request := *context.Request
+						request.Body = io.NopCloser(strings.NewReader(IssueCommentEvent("!squash", issueAuthor)))
+						response := handler(httptest.NewRecorder(), request)
+						Expect(response).To(BeAssignableToTypeOf(grh.SuccessResponse{}))
+					}
+					repositories.AssertNumberOfCalls(GinkgoT(), "GetContents", 2)
+				})
+			})
+
+			Context("with a custom merging label and merge method", func() {
+				requestJSON.Is(func() string {
+					return IssueCommentEvent("!merge", issueAuthor)
+				})
+
+				headSHA := "1235"
+				pr := &github.PullRequest{
+					Number:    github.Int(issueNumber),
+					Merged:    github.Bool(false),
+					Mergeable: github.Bool(true),
+					Base: &github.PullRequestBranch{
+						SHA:  github.String("1234"),
+						Ref:  github.String("master"),
+						Repo: repository,
+					},
+					Head: &github.PullRequestBranch{
+						SHA:  github.String(headSHA),
+						Ref:  github.String("feature"),
+						Repo: repository,
+					},
+					User: &github.User{
+						Login: github.String(issueAuthor),
+					},
+				}
+
+				ForCollaborator(context, repositoryOwner, repositoryName, issueAuthor, func() {
+					BeforeEach(func() {
+						mockConfigFile("merging_label: ready to merge\nmerge_method: squash\n")
+					})
+
+					It("uses the configured label and merge method", func() {
+						issues.
+							On("AddLabelsToIssue", anyContext, repositoryOwner, repositoryName, issueNumber, []string{"ready to merge"}).
+							Return(emptyResult, emptyResponse, noError)
+						pullRequests.
+							On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+							Return(pr, emptyResponse, noError)
+						repositories.
+							On("GetCombinedStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.AnythingOfType("*github.ListOptions")).
+							Return(&github.CombinedStatus{
+								State: github.String("success"),
+							}, emptyResponse, noError)
+						pullRequests.
+							On("Merge", anyContext, repositoryOwner, repositoryName, issueNumber, "",
+								&github.PullRequestOptions{MergeMethod: "squash"}).
+							Return(emptyResult, emptyResponse, errArbitrary)
+
+						handle()
+						Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+					})
+				})
+			})
+		})
+
+		Context("with a pull_request event", func() {
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event": "pull_request",
+				}
+			})
+			requestJSON.Is(func() string {
+				return PullRequestEvent("synchronize", arbitrarySHA, grh.Repository{
+					Owner: repositoryOwner,
+					Name:  repositoryName,
+					URL:   sshURL,
+				})
+			})
+
+			Context("with the squash check being disabled", func() {
+				BeforeEach(func() {
+					mockConfigFile("squash_check: false\n")
+				})
+
+				It("doesn't check for fixup commits", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Squash check disabled"))
+				})
+			})
+		})
+
+		Context("with a push event", func() {
+			headers.Is(func() map[string]string {
+				return map[string]string{
+					"X-Github-Event": "push",
+				}
+			})
+
+			Context("to the default branch", func() {
+				requestJSON.Is(func() string {
+					return PushEvent("refs/heads/master", "master")
+				})
+
+				It("clears the cached configuration", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Cleared"))
+				})
+			})
+
+			Context("to another branch", func() {
+				requestJSON.Is(func() string {
+					return PushEvent("refs/heads/feature", "master")
+				})
+
+				It("clears the cached configuration of the branch", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Cleared"))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("feature"))
+				})
+			})
+
+			Context("to a tag", func() {
+				requestJSON.Is(func() string {
+					return PushEvent("refs/tags/v1.0.0", "master")
+				})
+
+				It("ignores the event", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+					Expect(responseRecorder.Body.String()).To(ContainSubstring("Ignoring"))
+				})
+			})
+		})
+	})
+})
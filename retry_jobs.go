@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// retryJobSuffix is the suffix of the files that hold the retry jobs.
+const retryJobSuffix = ".json"
+
+type retryJobKind string
+
+const (
+	// checkCommitsJob checks the commits of a PR. See checkCommits.
+	checkCommitsJob retryJobKind = "check_commits"
+	// mergeReadyJob merges the PRs that a successful status or check might
+	// have made ready for merging.
+	mergeReadyJob retryJobKind = "merge_ready"
+	// cancelMergingJob stops merging the PRs that a failed status or check
+	// belongs to.
+	cancelMergingJob retryJobKind = "cancel_merging"
+)
+
+// retryJob describes a single try of an operation that is retried when the
+// GitHub API fails or isn't up to date yet. The jobs only consist of data,
+// so that the scheduled tries could be persisted and resumed after a
+// restart.
+type retryJob struct {
+	Kind retryJobKind `json:"kind"`
+	// Key is the key the job can be cancelled by. See scheduledRetries.
+	Key string `json:"key"`
+	// Attempt is the index of the try in the configured try delays
+	Attempt int       `json:"attempt"`
+	Due     time.Time `json:"due"`
+	// Repository is the base repository of the PR for checkCommitsJob and
+	// the repository of the status or check for the other kinds
+	Repository Repository `json:"repository"`
+	// Branch is the branch whose configuration the job uses. It's the base
+	// branch of the PR for checkCommitsJob and empty, i.e. the default
+	// branch, for the other kinds.
+	Branch string `json:"branch,omitempty"`
+
+	// The fields of checkCommitsJob
+	IssueNumber int  `json:"issue_number,omitempty"`
+	User        User `json:"user"`
+	// Head is the head of the PR that the commits are checked for. The
+	// commits of the PR's current head are checked when it's nil.
+	Head   *PullRequestBranch `json:"head,omitempty"`
+	Squash bool               `json:"squash,omitempty"`
+
+	// The fields of mergeReadyJob and cancelMergingJob
+	SHA     string       `json:"sha,omitempty"`
+	Failure checkFailure `json:"failure"`
+
+	// id identifies the file the job is persisted in. It's 0 for jobs that
+	// haven't been persisted.
+	id uint64
+}
+
+func (job retryJob) Issue() Issue {
+	return Issue{
+		Number:     job.IssueNumber,
+		Repository: job.Repository,
+		User:       job.User,
+	}
+}
+
+// retryJobs persists the scheduled retry jobs in a directory, one file per
+// job. The jobs are only kept in memory when the directory is empty.
+type retryJobs struct {
+	dir    string
+	mutex  sync.Mutex
+	nextID uint64
+}
+
+// newRetryJobs creates the store and returns the jobs that were persisted
+// in dir before, ordered by their due time.
+func newRetryJobs(dir string) (*retryJobs, []retryJob, error) {
+	s := &retryJobs{dir: dir, nextID: 1}
+	if dir == "" {
+		return s, []retryJob{}, nil
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create the retry jobs directory: %v", err)
+	}
+	jobs, err := s.load()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load the retry jobs: %v", err)
+	}
+	for _, job := range jobs {
+		if job.id >= s.nextID {
+			s.nextID = job.id + 1
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].Due.Before(jobs[j].Due)
+	})
+	return s, jobs, nil
+}
+
+// isPersistent reports whether the jobs survive a restart.
+func (s *retryJobs) isPersistent() bool {
+	return s.dir != ""
+}
+
+// save persists the job, replacing its previous version, if any.
+func (s *retryJobs) save(job *retryJob) error {
+	if !s.isPersistent() {
+		return nil
+	}
+	s.mutex.Lock()
+	if job.id == 0 {
+		job.id = s.nextID
+		s.nextID++
+	}
+	s.mutex.Unlock()
+	contents, err := json.Marshal(job)
+	if err != nil {
+		return fmt.Errorf("failed to serialize the retry job: %v", err)
+	}
+	if err := writeFileSynced(s.path(job.id), contents); err != nil {
+		return fmt.Errorf("failed to store the retry job: %v", err)
+	}
+	return nil
+}
+
+// remove removes the job once it has run or been cancelled.
+func (s *retryJobs) remove(job retryJob) {
+	if !s.isPersistent() || job.id == 0 {
+		return
+	}
+	if err := os.Remove(s.path(job.id)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove the retry job %d: %v\n", job.id, err)
+	}
+}
+
+func (s *retryJobs) load() ([]retryJob, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	jobs := []retryJob{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".tmp-") {
+			os.Remove(filepath.Join(s.dir, name))
+			continue
+		} else if !strings.HasSuffix(name, retryJobSuffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, retryJobSuffix), 10, 64)
+		if err != nil || id == 0 {
+			log.Printf("Ignoring unexpected file %s in the retry jobs directory\n", name)
+			continue
+		}
+		contents, err := os.ReadFile(filepath.Join(s.dir, name))
+		if err != nil {
+			return nil, err
+		}
+		var job retryJob
+		if err := json.Unmarshal(contents, &job); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", name, err)
+		}
+		job.id = id
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+func (s *retryJobs) path(id uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", id, retryJobSuffix))
+}
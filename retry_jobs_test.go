@@ -0,0 +1,179 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v84/github"
+	grh "github.com/salemove/github-review-helper"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("persisted retries", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			conf             *grh.Config
+			jobsDir          string
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			repositories     *mocks.Repositories
+		)
+		BeforeEach(func() {
+			conf = context.Config
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			repositories = *context.Repositories
+
+			var err error
+			jobsDir, err = os.MkdirTemp("", "retry-jobs-test")
+			Expect(err).NotTo(HaveOccurred())
+			conf.RetryJobsDir = jobsDir
+		})
+		AfterEach(func() {
+			os.RemoveAll(jobsDir)
+		})
+
+		var pullRequestHeadSHA = "1235"
+		var headRepository = grh.Repository{
+			Owner: "other",
+			Name:  "github-review-helper-fork",
+			URL:   "git@github.com:other/github-review-helper-fork.git",
+		}
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "pull_request",
+			}
+		})
+		requestJSON.Is(func() string {
+			return PullRequestEvent("synchronize", pullRequestHeadSHA, headRepository)
+		})
+
+		persistedJobs := func() []os.DirEntry {
+			entries, err := os.ReadDir(jobsDir)
+			Expect(err).NotTo(HaveOccurred())
+			return entries
+		}
+
+		// mockOutdatedCommits makes the list of commits not include the
+		// event's head, which makes the commit checks get retried
+		mockOutdatedCommits := func() {
+			pullRequests.
+				On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+				Return(githubCommits(
+					commit{arbitrarySHA, "Changing things"},
+				), emptyResponse, noError)
+		}
+
+		Context("with all the tries failing", func() {
+			BeforeEach(mockOutdatedCommits)
+
+			It("removes the job after the last try", func() {
+				handle()
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				pullRequests.AssertNumberOfCalls(GinkgoT(), "ListCommits", numberOfGithubTries)
+				Expect(persistedJobs()).To(BeEmpty())
+			})
+		})
+
+		Context("with a retry scheduled for later", func() {
+			BeforeEach(func() {
+				conf.GithubAPITryDeltas = []time.Duration{0, time.Hour}
+				mockOutdatedCommits()
+			})
+
+			It("persists the retry", func() {
+				// The retry isn't waited for, unlike in handle()
+				handler := grh.CreateHandler(*conf, *context.GitRepos, &sync.WaitGroup{}, nil, pullRequests,
+					repositories, *context.Issues, *context.Search, *context.Checks)
+				response := handler(responseRecorder, *context.Request)
+				response.WriteResponse(responseRecorder)
+
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				Expect(responseRecorder.Body.String()).To(ContainSubstring("asynchronously"))
+				pullRequests.AssertNumberOfCalls(GinkgoT(), "ListCommits", 1)
+				Expect(persistedJobs()).To(HaveLen(1))
+			})
+		})
+
+		Context("with jobs persisted before a restart", func() {
+			var attempt int
+
+			BeforeEach(func() {
+				attempt = 1
+				// The job is resumed before the suite mocks the repository
+				// configuration
+				repositories.
+					On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+					Return(emptyResult, emptyResult, notFoundResponse, errArbitrary).
+					Maybe()
+			})
+
+			writeJob := func() {
+				job := `{
+  "kind": "check_commits",
+  "key": "",
+  "attempt": ` + strconv.Itoa(attempt) + `,
+  "due": "` + time.Now().Add(-time.Minute).Format(time.RFC3339) + `",
+  "repository": {"owner": "` + repositoryOwner + `", "name": "` + repositoryName + `", "url": "` + sshURL + `"},
+  "issue_number": ` + strconv.Itoa(issueNumber) + `,
+  "head": {"sha": "` + pullRequestHeadSHA + `", "repository": {"owner": "` + headRepository.Owner +
+					`", "name": "` + headRepository.Name + `", "url": "` + headRepository.URL + `"}},
+  "squash": true
+}`
+				path := filepath.Join(jobsDir, "00000000000000000001.json")
+				Expect(os.WriteFile(path, []byte(job), 0600)).To(Succeed())
+			}
+
+			Context("with the job succeeding", func() {
+				BeforeEach(func() {
+					pullRequests.
+						On("ListCommits", anyContext, repositoryOwner, repositoryName, issueNumber, mock.AnythingOfType("*github.ListOptions")).
+						Return(githubCommits(
+							commit{arbitrarySHA, "Changing things"},
+							commit{pullRequestHeadSHA, "Another casual commit"},
+						), emptyResponse, noError)
+					repositories.
+						On("CreateStatus", anyContext, headRepository.Owner, headRepository.Name, pullRequestHeadSHA,
+							mock.MatchedBy(func(status github.RepoStatus) bool {
+								return *status.State == "success" && *status.Context == "review/squash"
+							}),
+						).
+						Return(emptyResult, emptyResponse, noError)
+					writeJob()
+				})
+
+				It("resumes the job", func() {
+					Eventually(persistedJobs).Should(BeEmpty())
+					pullRequests.AssertNumberOfCalls(GinkgoT(), "ListCommits", 1)
+				})
+			})
+
+			Context("with the job being the last try", func() {
+				BeforeEach(func() {
+					attempt = numberOfGithubTries - 1
+					mockOutdatedCommits()
+					writeJob()
+				})
+
+				It("doesn't retry it again", func() {
+					Eventually(persistedJobs).Should(BeEmpty())
+					pullRequests.AssertNumberOfCalls(GinkgoT(), "ListCommits", 1)
+				})
+			})
+		})
+	})
+})
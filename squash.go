@@ -11,8 +11,6 @@ import (
 	"github.com/salemove/github-review-helper/git"
 )
 
-var ErrSquashConflict = errors.New("Rebase failed due to a squash conflict")
-
 func isSquashCommand(comment string) bool {
 	return strings.TrimSpace(comment) == "!squash"
 }
@@ -21,81 +19,209 @@ func isCheckCommand(comment string) bool {
 	return strings.TrimSpace(comment) == "!check"
 }
 
-func handleSquashCommand(issueComment IssueComment, gitRepos git.Repos, pullRequests PullRequests, repositories Repositories) Response {
-	pr, errResp := getPR(issueComment, pullRequests)
-	if errResp != nil {
-		return errResp
-	}
-	return squashAndReportFailure(pr, gitRepos, repositories)
+func handleSquashCommand(pr *github.PullRequest, repoConfig RepoConfig, botLogin string, gitRepos git.Repos,
+	repositories Repositories, issues Issues) Response {
+	return squashAndReportFailure(pr, repoConfig.CoAuthorTrailers, botLogin, gitRepos, repositories, issues)
+}
+
+// commitChecks selects the checks that are run for the commits of a PR.
+type commitChecks struct {
+	squash bool
+	// lint is nil if commit messages aren't checked
+	lint *commitLintRules
+	// complete is nil if the commits aren't checked to be complete
+	complete *git.CommitCheck
 }
 
-func checkForFixupCommitsOnPREvent(pullRequestEvent PullRequestEvent, pullRequests PullRequests,
-	repositories Repositories, retry retryGithubOperation) Response {
+func (checks commitChecks) isEmpty() bool {
+	return !checks.squash && checks.lint == nil && checks.complete == nil
+}
 
-	isExpectedHead := func(head string) bool {
-		return head == pullRequestEvent.Head.SHA
+func checkCommitsOnPREvent(pullRequestEvent PullRequestEvent, squash bool, retry retryGithubOperation) Response {
+	head := pullRequestEvent.Head
+	return checkCommits(retryJob{
+		Kind:        checkCommitsJob,
+		Key:         unkeyedOperation,
+		Repository:  pullRequestEvent.Repository,
+		Branch:      pullRequestEvent.BaseRef,
+		IssueNumber: pullRequestEvent.IssueNumber,
+		User:        pullRequestEvent.User,
+		Head:        &head,
+		Squash:      squash,
+	}, retry)
+}
+
+func checkCommitsOnIssueComment(issueComment IssueComment, baseRef string, repoConfig RepoConfig,
+	retry retryGithubOperation) Response {
+
+	checks := commitChecks{
+		squash:   repoConfig.SquashCheck,
+		lint:     repoConfig.CommitLint,
+		complete: repoConfig.CompleteCheck,
 	}
-	setStatus := func(status *github.RepoStatus) *ErrorResponse {
-		return setStatusForPREvent(pullRequestEvent, status, repositories)
+	if checks.isEmpty() {
+		return SuccessResponse{"Squash check disabled for this repository and commit messages aren't checked. " +
+			"Not checking the commits."}
+	}
+	return checkCommits(retryJob{
+		Kind:        checkCommitsJob,
+		Key:         unkeyedOperation,
+		Repository:  issueComment.Repository,
+		Branch:      baseRef,
+		IssueNumber: issueComment.IssueNumber,
+		User:        issueComment.User,
+		Squash:      checks.squash,
+	}, retry)
+}
+
+func checkCommits(job retryJob, retry retryGithubOperation) Response {
+	log.Printf("Checking the commits of PR %s.\n", job.Issue().FullName())
+	maybeSyncResponse := retry(job)
+	if maybeSyncResponse.OperationFinishedSynchronously {
+		return maybeSyncResponse.Response
 	}
-	return checkForFixupCommits(pullRequestEvent, isExpectedHead, setStatus, pullRequests, retry)
+	return SuccessResponse{fmt.Sprintf(
+		"Continuing checking the commits of PR %s asynchronously.",
+		job.Issue().FullName(),
+	)}
 }
 
-func checkForFixupCommitsOnIssueComment(issueComment IssueComment, pullRequests PullRequests,
-	repositories Repositories, retry retryGithubOperation) Response {
+// runCommitChecks runs a checkCommitsJob. It checks the PR for fixup
+// commits, commit messages that break the repository's rules and/or
+// incomplete commits, depending on the job and the repository's
+// configuration.
+func runCommitChecks(job retryJob, repoConfig RepoConfig, botLogin string, gitRepos git.Repos,
+	pullRequests PullRequests, repositories Repositories, issues Issues, runAsync runAsyncOperation) asyncResponse {
 
+	checks := commitChecks{squash: job.Squash, lint: repoConfig.CommitLint, complete: repoConfig.CompleteCheck}
 	isExpectedHead := func(string) bool { return true }
 	setStatus := func(status *github.RepoStatus) *ErrorResponse {
-		pr, errResp := getPR(issueComment, pullRequests)
+		pr, errResp := getPR(job, pullRequests)
 		if errResp != nil {
 			return errResp
 		}
 		return setStatusForPR(pr, status, repositories)
 	}
-	return checkForFixupCommits(issueComment, isExpectedHead, setStatus, pullRequests, retry)
-}
-
-func checkForFixupCommits(issueable Issueable, isExpectedHead func(string) bool,
-	setStatus func(*github.RepoStatus) *ErrorResponse, pullRequests PullRequests,
-	retry retryGithubOperation) Response {
+	if job.Head != nil {
+		pullRequestEvent := PullRequestEvent{
+			IssueNumber: job.IssueNumber,
+			Head:        *job.Head,
+			Repository:  job.Repository,
+			User:        job.User,
+		}
+		isExpectedHead = func(head string) bool {
+			return head == pullRequestEvent.Head.SHA
+		}
+		setStatus = func(status *github.RepoStatus) *ErrorResponse {
+			return setStatusForPREvent(pullRequestEvent, status, repositories)
+		}
+	}
 
-	log.Printf("Checking for fixup commits for PR %s.\n", issueable.Issue().FullName())
-	maybeSyncResponse := retry(func() asyncResponse {
-		commits, asyncErrResp := getCommits(issueable, isExpectedHead, pullRequests)
-		if asyncErrResp != nil {
-			return asyncErrResp.toAsyncResponse()
+	commits, asyncErrResp := getCommits(job, isExpectedHead, pullRequests)
+	if asyncErrResp != nil {
+		return asyncErrResp.toAsyncResponse()
+	}
+	if checks.squash {
+		if errResp := reportFixupCommits(commits, setStatus); errResp != nil {
+			return nonRetriable(errResp)
 		}
-		if !includesFixupCommits(commits) {
-			status := createSquashStatus("success", "No fixup! or squash! commits to be squashed")
-			if errResp := setStatus(status); errResp != nil {
-				return nonRetriable(errResp)
-			}
-			return nonRetriable(SuccessResponse{})
+	}
+	if checks.lint != nil {
+		issue := job.Issue()
+		violations, errResp := lintCommits(issue, commits, checks.lint, repositories)
+		if errResp != nil {
+			return nonRetriable(errResp)
 		}
-		status := createSquashStatus("pending", "This PR needs to be squashed with !squash before merging")
-		if errResp := setStatus(status); errResp != nil {
+		if errResp := reportCommitViolations(issue, violations, setStatus, botLogin, issues); errResp != nil {
 			return nonRetriable(errResp)
 		}
-		return nonRetriable(SuccessResponse{})
-	})
-	if maybeSyncResponse.OperationFinishedSynchronously {
-		return maybeSyncResponse.Response
 	}
-	return SuccessResponse{fmt.Sprintf(
-		"Continuing checking for fixup commits for PR %s asynchronously.",
-		issueable.Issue().FullName(),
-	)}
+	if checks.complete != nil {
+		errResp := startCompleteCheck(job, commits, checks.complete, setStatus, botLogin, gitRepos, pullRequests,
+			repositories, issues, runAsync)
+		if errResp != nil {
+			return nonRetriable(errResp)
+		}
+	}
+	return nonRetriable(SuccessResponse{})
+}
+
+// reportFixupCommits sets the review/squash status based on the fixup
+// commits in the PR.
+func reportFixupCommits(commits []*github.RepositoryCommit,
+	setStatus func(*github.RepoStatus) *ErrorResponse) *ErrorResponse {
+
+	if orphans := orphanedFixupCommits(commits); len(orphans) > 0 {
+		description := "Fixups without a target in this PR: " + strings.Join(orphans, ", ")
+		return setStatus(createSquashStatus("failure", truncateStatusDescription(description)))
+	} else if !includesFixupCommits(commits) {
+		return setStatus(createSquashStatus("success", "No fixup!, squash! or amend! commits to be squashed"))
+	}
+	return setStatus(createSquashStatus("pending", "This PR needs to be squashed with !squash before merging"))
 }
 
 func includesFixupCommits(commits []*github.RepositoryCommit) bool {
 	for _, commit := range commits {
-		if strings.HasPrefix(*commit.Commit.Message, "fixup! ") || strings.HasPrefix(*commit.Commit.Message, "squash! ") {
+		if git.IsFixup(commitSubject(commit)) {
 			return true
 		}
 	}
 	return false
 }
 
+// orphanedFixupCommits returns the subjects of the fixup!, squash! and amend! commits
+// whose target isn't in the PR. Autosquash leaves such commits as they are,
+// so they would end up in the base branch.
+func orphanedFixupCommits(commits []*github.RepositoryCommit) []string {
+	orphans := []string{}
+	for _, orphan := range git.OrphanedFixups(gitCommits(topologicalOrder(commits))) {
+		orphans = append(orphans, orphan.Subject)
+	}
+	return orphans
+}
+
+// gitCommits converts the commits for the autosquash helpers of the git
+// package, keeping their order.
+func gitCommits(commits []*github.RepositoryCommit) []git.Commit {
+	converted := make([]git.Commit, len(commits))
+	for i, commit := range commits {
+		converted[i] = git.Commit{SHA: *commit.SHA, Subject: commitSubject(commit)}
+	}
+	return converted
+}
+
+func commitSubject(commit *github.RepositoryCommit) string {
+	return strings.SplitN(*commit.Commit.Message, "\n", 2)[0]
+}
+
+// topologicalOrder orders the commits so that parents come before their
+// children, which is the order the commits are rebased in.
+func topologicalOrder(commits []*github.RepositoryCommit) []*github.RepositoryCommit {
+	commitsBySHA := make(map[string]*github.RepositoryCommit, len(commits))
+	for _, commit := range commits {
+		commitsBySHA[*commit.SHA] = commit
+	}
+	ordered := make([]*github.RepositoryCommit, 0, len(commits))
+	visited := make(map[string]bool, len(commits))
+	var visit func(commit *github.RepositoryCommit)
+	visit = func(commit *github.RepositoryCommit) {
+		if visited[*commit.SHA] {
+			return
+		}
+		visited[*commit.SHA] = true
+		for _, parent := range commit.Parents {
+			if parentCommit, inPR := commitsBySHA[*parent.SHA]; inPR {
+				visit(parentCommit)
+			}
+		}
+		ordered = append(ordered, commit)
+	}
+	for _, commit := range commits {
+		visit(commit)
+	}
+	return ordered
+}
+
 func createSquashStatus(state, description string) *github.RepoStatus {
 	return &github.RepoStatus{
 		State:       github.String(state),
@@ -104,35 +230,125 @@ func createSquashStatus(state, description string) *github.RepoStatus {
 	}
 }
 
-func squashAndReportFailure(pr *github.PullRequest, gitRepos git.Repos, repositories Repositories) Response {
+func squashAndReportFailure(pr *github.PullRequest, addCoAuthors bool, botLogin string, gitRepos git.Repos,
+	repositories Repositories, issues Issues) Response {
 	log.Printf("Squashing %s that's going to be merged into %s\n", *pr.Head.Ref, *pr.Base.Ref)
-	err := squash(pr, gitRepos, repositories)
-	if err == ErrSquashConflict {
+	rangeDiff, err := squash(pr, addCoAuthors, gitRepos, repositories)
+	var conflictErr *git.ErrSquashConflict
+	var treeErr *git.ErrTreeMismatch
+	var movedErr *git.ErrBranchMoved
+	var messageErr *git.ErrMessageMismatch
+	if errors.As(err, &movedErr) {
+		return reportBranchMoved(pr, movedErr, issues)
+	} else if errors.As(err, &messageErr) {
+		log.Printf("Refusing to push the squashed commits: %s. Setting a failure status.\n", err)
+		description := fmt.Sprintf("Squashing didn't apply the message of %s. Please squash manually",
+			messageErr.Commit.Subject)
+		status := createSquashStatus("failure", truncateStatusDescription(description))
+		if errResp := setStatusForPR(pr, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{}
+	} else if errors.As(err, &treeErr) {
+		log.Printf("Refusing to push the squashed commits: %s. Setting a failure status.\n", err)
+		status := createSquashStatus("failure", "Squashing would change the contents of the PR. Please squash manually")
+		if errResp := setStatusForPR(pr, status, repositories); errResp != nil {
+			return errResp
+		}
+		return SuccessResponse{}
+	} else if errors.As(err, &conflictErr) {
 		log.Printf("Failed to autosquash the commits with an interactive rebase: %s. Setting a failure status.\n", err)
 		status := createSquashStatus("failure", "Automatic squash failed. Please squash manually")
 		if errResp := setStatusForPR(pr, status, repositories); errResp != nil {
 			return errResp
 		}
+		issue := prIssue(pr)
+		if err = comment(squashConflictMessage(conflictErr, issue), issue.Repository, issue.Number,
+			issues); err != nil {
+			message := fmt.Sprintf("Failed to notify the author of PR %s about the squash conflict",
+				issue.FullName())
+			return ErrorResponse{err, http.StatusBadGateway, message}
+		}
 		return SuccessResponse{}
 	} else if err != nil {
 		return ErrorResponse{err, http.StatusInternalServerError, "Failed to squash the commits in the PR"}
+	} else if rangeDiff != "" {
+		if errResp := postRangeDiff(prIssue(pr), rangeDiff, botLogin, issues); errResp != nil {
+			return errResp
+		}
 	}
 	return SuccessResponse{}
 }
 
-func squash(pr *github.PullRequest, gitRepos git.Repos, repositories Repositories) error {
+// squash squashes the commits in the PR and pushes them. Returns the
+// range-diff of the original and the squashed commits or an empty string if
+// squashing didn't change the commits. If addCoAuthors is true, the authors of
+// the squashed commits are credited in the commits they're squashed into.
+func squash(pr *github.PullRequest, addCoAuthors bool, gitRepos git.Repos, repositories Repositories) (string,
+	error) {
 	headRepository := headRepository(pr)
 	gitRepo, err := gitRepos.GetUpdatedRepo(headRepository.URL, headRepository.Owner, headRepository.Name)
 	if err != nil {
 		log.Println(err)
-		return errors.New("Failed to update the local repo")
+		return "", errors.New("Failed to update the local repo")
 	}
-	if err = gitRepo.AutosquashAndPush("origin/"+*pr.Base.Ref, *pr.Head.SHA, *pr.Head.Ref); err != nil {
-		log.Println(err)
-		if _, ok := err.(*git.ErrSquashConflict); ok {
-			return ErrSquashConflict
+	upstreamRef := "origin/" + *pr.Base.Ref
+	squashedSHA, err := gitRepo.AutosquashAndPush(upstreamRef, *pr.Head.SHA, *pr.Head.Ref,
+		git.BackupPrefix(*pr.Number), addCoAuthors)
+	if err != nil {
+		return "", err
+	} else if squashedSHA == *pr.Head.SHA {
+		return "", nil
+	}
+	rangeDiff, err := gitRepo.RangeDiff(upstreamRef, *pr.Head.SHA, squashedSHA)
+	if err != nil {
+		// The squashed commits have already been pushed, so only the report
+		// is missing
+		log.Printf("Failed to compute the range-diff for PR %s: %v\n", prFullName(pr), err)
+		return "", nil
+	}
+	return rangeDiff, nil
+}
+
+// squashConflictMessage describes which commit failed to be squashed and
+// which files had conflicts, so that the author would know which fixup needs
+// to be reworked.
+func squashConflictMessage(conflictErr *git.ErrSquashConflict, issue Issue) string {
+	message := "I'm unable to automatically squash the commits in this PR"
+	if conflictErr.Commit.SHA != "" {
+		message += fmt.Sprintf(", because applying %s", describeCommit(conflictErr.Commit))
+		if conflictErr.FixupTarget.SHA != "" {
+			message += fmt.Sprintf(" onto %s", describeCommit(conflictErr.FixupTarget))
+		}
+		message += " failed"
+	}
+	message += "."
+	if len(conflictErr.Files) > 0 {
+		message += " The following files had conflicts:\n\n"
+		for _, file := range conflictErr.Files {
+			message += fmt.Sprintf("- `%s`\n", file)
 		}
-		return err
+		message += "\n"
+	} else {
+		message += " "
 	}
-	return nil
+	return message + fmt.Sprintf("@%s, can you please squash manually?", issue.User.Login)
+}
+
+// reportBranchMoved lets the author know that the bot didn't push its changes,
+// because the PR branch was updated while the bot was working on it.
+func reportBranchMoved(pr *github.PullRequest, movedErr *git.ErrBranchMoved, issues Issues) Response {
+	log.Printf("Not pushing to PR %s: %s\n", prFullName(pr), movedErr)
+	issue := prIssue(pr)
+	message := fmt.Sprintf("The `%s` branch moved while I was working on it, so I didn't push my changes "+
+		"to avoid overwriting the new commits. @%s, please re-run the command.", *pr.Head.Ref, issue.User.Login)
+	if err := comment(message, issue.Repository, issue.Number, issues); err != nil {
+		errorMessage := fmt.Sprintf("Failed to notify the author of PR %s about the moved branch", issue.FullName())
+		return ErrorResponse{err, http.StatusBadGateway, errorMessage}
+	}
+	return SuccessResponse{fmt.Sprintf("Branch of PR %s moved. Not pushing.", prFullName(pr))}
+}
+
+func describeCommit(commit git.Commit) string {
+	return fmt.Sprintf("`%s` (%s)", commit.Subject, shortSHA(commit.SHA))
 }
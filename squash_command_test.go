@@ -4,6 +4,8 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"unicode/utf8"
 
 	"github.com/google/go-github/v84/github"
 	"github.com/salemove/github-review-helper/git"
@@ -38,6 +40,23 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			return IssueCommentEvent("!squash", arbitraryIssueAuthor)
 		})
 
+		pr := &github.PullRequest{
+			Number: github.Int(issueNumber),
+			Base: &github.PullRequestBranch{
+				SHA:  github.String("1234"),
+				Ref:  github.String("master"),
+				Repo: repository,
+			},
+			Head: &github.PullRequestBranch{
+				SHA:  github.String("1235"),
+				Ref:  github.String("feature"),
+				Repo: repository,
+			},
+			User: &github.User{
+				Login: github.String(arbitraryIssueAuthor),
+			},
+		}
+
 		ForCollaborator(context, repositoryOwner, repositoryName, arbitraryIssueAuthor, func() {
 			Context("with GitHub request failing", func() {
 				BeforeEach(func() {
@@ -53,20 +72,6 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 			})
 
 			Context("with GitHub request succeeding", func() {
-				pr := &github.PullRequest{
-					Number: github.Int(issueNumber),
-					Base: &github.PullRequestBranch{
-						SHA:  github.String("1234"),
-						Ref:  github.String("master"),
-						Repo: repository,
-					},
-					Head: &github.PullRequestBranch{
-						SHA:  github.String("1235"),
-						Ref:  github.String("feature"),
-						Repo: repository,
-					},
-				}
-
 				BeforeEach(func() {
 					pullRequests.
 						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
@@ -75,6 +80,38 @@ var _ = TestWebhookHandler(func(context WebhookTestContext) {
 
 				ItSquashesPR(context, pr)
 			})
+
+			Context("with co-author trailers disabled for the repository", func() {
+				var gitRepo *mocks.Repo
+
+				BeforeEach(func() {
+					repositories := *context.Repositories
+					repositories.
+						On("GetContents", anyContext, repositoryOwner, repositoryName, ".github/review-helper.yml", mock.Anything).
+						Return(&github.RepositoryContent{
+							Content: github.String("co_author_trailers: false\n"),
+						}, emptyResult, emptyResponse, noError)
+					pullRequests.
+						On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+						Return(pr, emptyResponse, noError)
+					gitRepo = new(mocks.Repo)
+					(*context.GitRepos).
+						On("GetUpdatedRepo", sshURL, repositoryOwner, repositoryName).
+						Return(gitRepo, noError)
+				})
+				AfterEach(func() {
+					gitRepo.AssertExpectations(GinkgoT())
+				})
+
+				It("squashes the PR without adding co-authors", func() {
+					gitRepo.
+						On("AutosquashAndPush", "origin/master", "1235", "feature", git.BackupPrefix(issueNumber), false).
+						Return("1235", noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
 		})
 	})
 })
@@ -85,6 +122,7 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 
 		responseRecorder *httptest.ResponseRecorder
 		repositories     *mocks.Repositories
+		issues           *mocks.Issues
 		gitRepos         *mocks.Repos
 		gitRepo          *mocks.Repo
 
@@ -96,6 +134,7 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 	BeforeEach(func() {
 		responseRecorder = *context.ResponseRecorder
 		repositories = *context.Repositories
+		issues = *context.Issues
 		gitRepos = *context.GitRepos
 
 		gitRepo = new(mocks.Repo)
@@ -110,16 +149,99 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 
 	Context("with autosquash and push failing due to a squash conflict", func() {
 		BeforeEach(func() {
-			squashErr := &git.ErrSquashConflict{Err: errors.New("merge conflict")}
+			squashErr := &git.ErrSquashConflict{
+				Err: errors.New("merge conflict"),
+				Commit: git.Commit{
+					SHA:     "c9b5e1096a18765a14f6fb295c585efd40487a24",
+					Subject: "fixup! Add foo",
+				},
+				FixupTarget: git.Commit{
+					SHA:     "43c3c0c406518f3f326474f9e378027f86f27caf",
+					Subject: "Add foo",
+				},
+				Files: []string{"foo.go"},
+			}
+			gitRepo.
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return("", squashErr)
+		})
+
+		Context("with setting the status succeeding", func() {
+			BeforeEach(func() {
+				repositories.
+					On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+						return *status.State == "failure" && *status.Context == "review/squash"
+					})).
+					Return(emptyResult, emptyResponse, noError)
+			})
+
+			It("reports the failure and its details in a comment", func() {
+				issues.
+					On("CreateComment", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+						return strings.Contains(*issueComment.Body, "`fixup! Add foo` (c9b5e10)") &&
+							strings.Contains(*issueComment.Body, "onto `Add foo` (43c3c0c)") &&
+							strings.Contains(*issueComment.Body, "- `foo.go`") &&
+							strings.Contains(*issueComment.Body, "@"+*pr.User.Login)
+					})).
+					Return(emptyResult, emptyResponse, noError)
+
+				handle()
+
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+
+			Context("with commenting failing", func() {
+				BeforeEach(func() {
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.Anything).
+						Return(emptyResult, emptyResponse, errArbitrary)
+				})
+
+				It("fails with a gateway error", func() {
+					handle()
+
+					Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+				})
+			})
+		})
+	})
+
+	Context("with autosquash and push failing due to the squash changing the tree", func() {
+		BeforeEach(func() {
+			gitRepo.
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return("", &git.ErrTreeMismatch{ExpectedTree: "1234", ActualTree: "1235"})
+		})
+
+		It("reports the failure", func() {
+			repositories.
+				On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
+					return *status.State == "failure" && *status.Context == "review/squash" &&
+						strings.Contains(*status.Description, "change the contents")
+				})).
+				Return(emptyResult, emptyResponse, noError)
+
+			handle()
+
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		})
+	})
+
+	Context("with autosquash and push failing due to an amend! commit's message not being applied", func() {
+		BeforeEach(func() {
 			gitRepo.
-				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef).
-				Return(squashErr)
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return("", &git.ErrMessageMismatch{
+					Commit:          git.Commit{SHA: "1234", Subject: "amend! Add foo"},
+					ExpectedMessage: "Add a better foo",
+				})
 		})
 
 		It("reports the failure", func() {
 			repositories.
 				On("CreateStatus", anyContext, repositoryOwner, repositoryName, headSHA, mock.MatchedBy(func(status github.RepoStatus) bool {
-					return *status.State == "failure" && *status.Context == "review/squash"
+					return *status.State == "failure" && *status.Context == "review/squash" &&
+						strings.Contains(*status.Description, "amend! Add foo")
 				})).
 				Return(emptyResult, emptyResponse, noError)
 
@@ -132,8 +254,8 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 	Context("with autosquash and push failing due to a reason other than a squash conflict", func() {
 		BeforeEach(func() {
 			gitRepo.
-				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef).
-				Return(errors.New("other git error"))
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return("", errors.New("other git error"))
 		})
 
 		It("responds with an internal server error", func() {
@@ -143,11 +265,11 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 		})
 	})
 
-	Context("with autosquash and push succeeding", func() {
+	Context("with autosquash and push succeeding without changing the commits", func() {
 		BeforeEach(func() {
 			gitRepo.
-				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef).
-				Return(noError)
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return(headSHA, noError)
 		})
 
 		It("returns 200 OK", func() {
@@ -156,4 +278,116 @@ var ItSquashesPR = func(context WebhookTestContext, pr *github.PullRequest) {
 			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
 		})
 	})
+
+	Context("with autosquash and push succeeding", func() {
+		var (
+			squashedSHA = "1236"
+			rangeDiff   = "1:  1235 ! 1:  1236 Add foo"
+		)
+
+		BeforeEach(func() {
+			gitRepo.
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return(squashedSHA, noError)
+			gitRepo.
+				On("RangeDiff", "origin/"+baseRef, headSHA, squashedSHA).
+				Return(rangeDiff, noError)
+		})
+
+		isRangeDiffComment := func(issueComment *github.IssueComment) bool {
+			return strings.HasPrefix(*issueComment.Body, "<!-- review-helper:range-diff -->") &&
+				strings.Contains(*issueComment.Body, "<details>") &&
+				strings.Contains(*issueComment.Body, rangeDiff)
+		}
+
+		Context("without an earlier range-diff comment", func() {
+			BeforeEach(func() {
+				issues.
+					On("ListComments", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+					Return([]*github.IssueComment{
+						{ID: github.Int64(1), Body: github.String("!squash")},
+					}, &github.Response{}, noError)
+			})
+
+			It("posts the range-diff in a comment", func() {
+				issues.
+					On("CreateComment", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.MatchedBy(isRangeDiffComment)).
+					Return(emptyResult, emptyResponse, noError)
+
+				handle()
+
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("with an earlier range-diff comment", func() {
+			BeforeEach(func() {
+				issues.
+					On("ListComments", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+					Return([]*github.IssueComment{
+						{ID: github.Int64(1), Body: github.String("!squash")},
+						{
+							ID:   github.Int64(2),
+							Body: github.String("<!-- review-helper:range-diff -->\nold"),
+							User: &github.User{Login: github.String(botLogin)},
+						},
+					}, &github.Response{}, noError)
+			})
+
+			It("updates the earlier comment", func() {
+				issues.
+					On("EditComment", anyContext, repositoryOwner, repositoryName, int64(2), mock.MatchedBy(isRangeDiffComment)).
+					Return(emptyResult, emptyResponse, noError)
+
+				handle()
+
+				Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+			})
+		})
+
+		Context("with listing the comments failing", func() {
+			BeforeEach(func() {
+				issues.
+					On("ListComments", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+					Return(emptyResult, emptyResponse, errArbitrary)
+			})
+
+			It("fails with a gateway error", func() {
+				handle()
+
+				Expect(responseRecorder.Code).To(Equal(http.StatusBadGateway))
+			})
+		})
+	})
+
+	Context("with autosquash and push succeeding with a range-diff too long for a comment", func() {
+		BeforeEach(func() {
+			squashedSHA := "1236"
+			// The multi-byte characters don't end at the length limit
+			longRangeDiff := "1:  1235 ! 1:  1236" + strings.Repeat("ä", 40000)
+			gitRepo.
+				On("AutosquashAndPush", "origin/"+baseRef, headSHA, headRef, git.BackupPrefix(issueNumber), true).
+				Return(squashedSHA, noError)
+			gitRepo.
+				On("RangeDiff", "origin/"+baseRef, headSHA, squashedSHA).
+				Return(longRangeDiff, noError)
+			issues.
+				On("ListComments", anyContext, repositoryOwner, repositoryName, *pr.Number, mock.AnythingOfType("*github.IssueListCommentsOptions")).
+				Return([]*github.IssueComment{}, &github.Response{}, noError)
+		})
+
+		It("truncates the range-diff at a character boundary", func() {
+			issues.
+				On("CreateComment", anyContext, repositoryOwner, repositoryName, *pr.Number,
+					mock.MatchedBy(func(issueComment *github.IssueComment) bool {
+						return utf8.ValidString(*issueComment.Body) &&
+							strings.Contains(*issueComment.Body, "truncated")
+					})).
+				Return(emptyResult, emptyResponse, noError)
+
+			handle()
+
+			Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+		})
+	})
 }
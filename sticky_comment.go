@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+)
+
+// postStickyComment posts a comment on the issue, or updates the issue's
+// earlier comment that starts with the same marker. The body has to start
+// with the marker. Only the comments of botLogin are updated, because anyone
+// could post a comment that starts with the marker.
+func postStickyComment(issue Issue, marker, body, botLogin string, issues Issues) *ErrorResponse {
+	existingComment, errResp := findStickyComment(issue, marker, botLogin, issues)
+	if errResp != nil {
+		return errResp
+	} else if existingComment != nil {
+		return editComment(issue, existingComment, body, issues)
+	}
+	if err := comment(body, issue.Repository, issue.Number, issues); err != nil {
+		message := fmt.Sprintf("Failed to comment on %s", issue.FullName())
+		return &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	return nil
+}
+
+// updateStickyComment updates the issue's earlier comment that starts with
+// the marker. Nothing is posted if there is no such comment.
+func updateStickyComment(issue Issue, marker, body, botLogin string, issues Issues) *ErrorResponse {
+	existingComment, errResp := findStickyComment(issue, marker, botLogin, issues)
+	if errResp != nil || existingComment == nil {
+		return errResp
+	}
+	return editComment(issue, existingComment, body, issues)
+}
+
+func editComment(issue Issue, issueComment *github.IssueComment, body string, issues Issues) *ErrorResponse {
+	_, _, err := issues.EditComment(context.TODO(), issue.Repository.Owner, issue.Repository.Name,
+		*issueComment.ID, &github.IssueComment{Body: github.String(body)})
+	if err != nil {
+		message := fmt.Sprintf("Failed to update comment %d on %s", *issueComment.ID, issue.FullName())
+		return &ErrorResponse{err, http.StatusBadGateway, message}
+	}
+	return nil
+}
+
+func findStickyComment(issue Issue, marker, botLogin string, issues Issues) (*github.IssueComment, *ErrorResponse) {
+	pageNr := 1
+	for {
+		listOptions := &github.IssueListCommentsOptions{
+			ListOptions: github.ListOptions{
+				Page:    pageNr,
+				PerPage: 100,
+			},
+		}
+		comments, resp, err := issues.ListComments(context.TODO(), issue.Repository.Owner, issue.Repository.Name,
+			issue.Number, listOptions)
+		if err != nil {
+			message := fmt.Sprintf("Getting comments for %s failed", issue.FullName())
+			return nil, &ErrorResponse{err, http.StatusBadGateway, message}
+		}
+		for _, issueComment := range comments {
+			if issueComment.GetUser().GetLogin() == botLogin && strings.HasPrefix(issueComment.GetBody(), marker) {
+				return issueComment, nil
+			}
+		}
+		if resp.NextPage == 0 {
+			return nil, nil
+		}
+		pageNr = resp.NextPage
+	}
+}
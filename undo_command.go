@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+)
+
+func isUndoCommand(comment string) bool {
+	return strings.TrimSpace(comment) == "!undo"
+}
+
+// handleUndoCommand restores the PR branch to the state it was in before the
+// bot last force pushed to it.
+func handleUndoCommand(pr *github.PullRequest, gitRepos git.Repos, issues Issues) Response {
+	log.Printf("Restoring the latest backup of PR %s\n", prFullName(pr))
+
+	repository := headRepository(pr)
+	gitRepo, err := gitRepos.GetUpdatedRepo(repository.URL, repository.Owner, repository.Name)
+	if err != nil {
+		message := fmt.Sprintf("Failed to get an updated repo for PR %s", prFullName(pr))
+		return ErrorResponse{err, http.StatusInternalServerError, message}
+	}
+	restoredSHA, err := gitRepo.RestoreLatestBackup(git.BackupPrefix(*pr.Number), *pr.Head.Ref)
+	var movedErr *git.ErrBranchMoved
+	if errors.As(err, &movedErr) {
+		log.Printf("Not restoring the backup of PR %s: %s\n", prFullName(pr), movedErr)
+		message := fmt.Sprintf("`%s` has changed since my last push, so I didn't undo it to avoid "+
+			"overwriting the new commits.", *pr.Head.Ref)
+		return respondToUndo(pr, message, issues)
+	} else if errors.Is(err, git.ErrNoBackup) {
+		return respondToUndo(pr, "There's nothing to undo. I haven't changed this PR's commits.", issues)
+	} else if err != nil {
+		return ErrorResponse{err, http.StatusInternalServerError, "Failed to restore the backup"}
+	}
+	message := fmt.Sprintf("I've restored `%s` to %s, the state it was in before my last push.",
+		*pr.Head.Ref, restoredSHA)
+	return respondToUndo(pr, message, issues)
+}
+
+func respondToUndo(pr *github.PullRequest, message string, issues Issues) Response {
+	issue := prIssue(pr)
+	if err := comment(message, issue.Repository, issue.Number, issues); err != nil {
+		errorMessage := fmt.Sprintf("Failed to respond to the undo command on PR %s", issue.FullName())
+		return ErrorResponse{err, http.StatusBadGateway, errorMessage}
+	}
+	return SuccessResponse{message}
+}
+
+// pruneBackups deletes the backups of a PR once they're no longer needed,
+// i.e. after the PR has been merged or closed.
+func pruneBackups(pullRequestEvent PullRequestEvent, gitRepos git.Repos) Response {
+	repository := pullRequestEvent.Head.Repository
+	if repository.URL == "" {
+		// The head repository has been deleted together with the backups
+		return SuccessResponse{"Head repository not available. No backups to prune."}
+	}
+	backupPrefix := git.BackupPrefix(pullRequestEvent.IssueNumber)
+	if err := gitRepos.DeleteBackups(repository.URL, backupPrefix); err != nil {
+		message := fmt.Sprintf("Failed to prune the backups of PR %s", pullRequestEvent.Issue().FullName())
+		return ErrorResponse{err, http.StatusInternalServerError, message}
+	}
+	return SuccessResponse{fmt.Sprintf("Pruned the backups of PR %s", pullRequestEvent.Issue().FullName())}
+}
@@ -0,0 +1,153 @@
+package main_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/google/go-github/v84/github"
+	"github.com/salemove/github-review-helper/git"
+	"github.com/salemove/github-review-helper/mocks"
+	"github.com/stretchr/testify/mock"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = TestWebhookHandler(func(context WebhookTestContext) {
+	Describe("!undo comment", func() {
+		var (
+			handle      = context.Handle
+			headers     = context.Headers
+			requestJSON = context.RequestJSON
+
+			responseRecorder *httptest.ResponseRecorder
+			pullRequests     *mocks.PullRequests
+			issues           *mocks.Issues
+			gitRepos         *mocks.Repos
+			gitRepo          *mocks.Repo
+
+			headSHA = "1235"
+		)
+		BeforeEach(func() {
+			responseRecorder = *context.ResponseRecorder
+			pullRequests = *context.PullRequests
+			issues = *context.Issues
+			gitRepos = *context.GitRepos
+			gitRepo = new(mocks.Repo)
+		})
+		AfterEach(func() {
+			gitRepo.AssertExpectations(GinkgoT())
+		})
+
+		headers.Is(func() map[string]string {
+			return map[string]string{
+				"X-Github-Event": "issue_comment",
+			}
+		})
+		requestJSON.Is(func() string {
+			return IssueCommentEvent("!undo", arbitraryIssueAuthor)
+		})
+
+		pr := &github.PullRequest{
+			Number: github.Int(issueNumber),
+			Base: &github.PullRequestBranch{
+				SHA:  github.String("1234"),
+				Ref:  github.String("master"),
+				Repo: repository,
+			},
+			Head: &github.PullRequestBranch{
+				SHA:  github.String(headSHA),
+				Ref:  github.String("feature"),
+				Repo: repository,
+			},
+			User: &github.User{
+				Login: github.String(arbitraryIssueAuthor),
+			},
+		}
+
+		commentContaining := func(text string) func(*github.IssueComment) bool {
+			return func(issueComment *github.IssueComment) bool {
+				return strings.Contains(*issueComment.Body, text)
+			}
+		}
+
+		ForCollaborator(context, repositoryOwner, repositoryName, arbitraryIssueAuthor, func() {
+			BeforeEach(func() {
+				pullRequests.
+					On("Get", anyContext, repositoryOwner, repositoryName, issueNumber).
+					Return(pr, emptyResponse, noError)
+				gitRepos.
+					On("GetUpdatedRepo", sshURL, repositoryOwner, repositoryName).
+					Return(gitRepo, noError)
+			})
+
+			Context("with a backup to restore", func() {
+				BeforeEach(func() {
+					gitRepo.
+						On("RestoreLatestBackup", git.BackupPrefix(issueNumber), "feature").
+						Return("1230", noError)
+				})
+
+				It("confirms restoring the backup with a comment", func() {
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+							mock.MatchedBy(commentContaining("restored `feature` to 1230"))).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("without any backups", func() {
+				BeforeEach(func() {
+					gitRepo.
+						On("RestoreLatestBackup", git.BackupPrefix(issueNumber), "feature").
+						Return("", git.ErrNoBackup)
+				})
+
+				It("replies that there's nothing to undo", func() {
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+							mock.MatchedBy(commentContaining("nothing to undo"))).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with new commits pushed since the backup", func() {
+				BeforeEach(func() {
+					gitRepo.
+						On("RestoreLatestBackup", git.BackupPrefix(issueNumber), "feature").
+						Return("", &git.ErrBranchMoved{Err: errArbitrary, Branch: "feature", ExpectedSHA: "1234"})
+				})
+
+				It("replies that the branch has changed", func() {
+					issues.
+						On("CreateComment", anyContext, repositoryOwner, repositoryName, issueNumber,
+							mock.MatchedBy(commentContaining("`feature` has changed since my last push"))).
+						Return(emptyResult, emptyResponse, noError)
+
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusOK))
+				})
+			})
+
+			Context("with restoring the backup failing", func() {
+				BeforeEach(func() {
+					gitRepo.
+						On("RestoreLatestBackup", git.BackupPrefix(issueNumber), "feature").
+						Return("", errArbitrary)
+				})
+
+				It("responds with an internal server error", func() {
+					handle()
+					Expect(responseRecorder.Code).To(Equal(http.StatusInternalServerError))
+				})
+			})
+		})
+	})
+})